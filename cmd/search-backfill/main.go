@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"fizicamd-backend-go/internal/config"
+	"fizicamd-backend-go/internal/db"
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/joho/godotenv"
+)
+
+// search-backfill fills resource_entries.search_text from the stored blocks
+// for resources saved before the column existed.
+func main() {
+	all := flag.Bool("all", false, "recompute every resource, not only those with an empty search_text")
+	flag.Parse()
+
+	_ = godotenv.Load()
+	cfg := config.Load()
+
+	database, err := db.Open(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("db: %v", err)
+	}
+	updated, err := services.RebuildSearchText(database, *all)
+	if err != nil {
+		log.Fatalf("search backfill: %v", err)
+	}
+	log.Printf("search backfill: updated %d resources", updated)
+}
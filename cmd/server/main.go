@@ -52,8 +52,25 @@ func main() {
 	hub := services.NewMetricsHub()
 	go hub.Run(ctx)
 
-	server := httpapi.NewServer(database, cfg, hub)
-	go metricsLoop(ctx, server)
+	store, err := services.OpenStorage(cfg, cfg.StorageBackend)
+	if err != nil {
+		log.Fatalf("storage: %v", err)
+	}
+
+	scanner, err := services.OpenScanner(cfg)
+	if err != nil {
+		log.Fatalf("scanner: %v", err)
+	}
+
+	server := httpapi.NewServer(database, cfg, hub, store, scanner)
+	instance := services.MetricsInstanceFor(cfg)
+	go metricsLoop(ctx, server, instance)
+	go metricsListenLoop(ctx, server, instance)
+	go metricsRollupLoop(ctx, server)
+	go mediaGCLoop(ctx, server)
+	go uploadExpiryLoop(ctx, server)
+	go mediaScanLoop(ctx, server)
+	go analyticsRollupLoop(ctx, server)
 
 	addr := ":8080"
 	if value := os.Getenv("PORT"); value != "" {
@@ -71,13 +88,30 @@ func main() {
 		}
 	}()
 
+	var metricsServer *http.Server
+	if cfg.MetricsListenAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", server.Telemetry.Handler(cfg.MetricsToken))
+		metricsServer = &http.Server{Addr: cfg.MetricsListenAddr, Handler: mux}
+		go func() {
+			log.Printf("metrics listening on %s", cfg.MetricsListenAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("metrics server: %v", err)
+			}
+		}()
+	}
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 	<-stop
 	cancel()
+	hub.Close()
 	ctxShutdown, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelShutdown()
 	_ = httpServer.Shutdown(ctxShutdown)
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(ctxShutdown)
+	}
 	log.Printf("shutdown complete")
 }
 
@@ -173,18 +207,185 @@ func cleanupOldLogs(logDir string, retentionDays int) {
 	}
 }
 
-func metricsLoop(ctx context.Context, server *httpapi.Server) {
+func metricsLoop(ctx context.Context, server *httpapi.Server, instance services.MetricsInstance) {
+	alerts := services.NewAlertEvaluator()
+	notifiers := services.AlertNotifiersFor(server.Config)
+	staleAfter := time.Duration(server.Config.AlertStaleMinutes) * time.Minute
 	ticker := time.NewTicker(time.Duration(server.Config.MetricsSampleSeconds) * time.Second)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			sample, err := services.CaptureMetrics(server.DB, server.Config.MetricsDiskPath)
+			sample, err := services.CaptureMetrics(server.DB, server.Config.MetricsDiskPath, instance)
 			if err != nil {
 				log.Printf("metrics capture: %v", err)
 				continue
 			}
 			server.MetricsHub.Broadcast(sample)
+			if err := services.PublishMetricSample(server.DB, sample); err != nil {
+				log.Printf("metrics publish: %v", err)
+			}
+			changed, err := alerts.Evaluate(server.DB, sample)
+			if err != nil {
+				log.Printf("alerts: %v", err)
+			}
+			stale, err := services.ResolveStaleAlerts(server.DB, staleAfter, sample.CapturedAt)
+			if err != nil {
+				log.Printf("stale alerts: %v", err)
+			}
+			changed = append(changed, stale...)
+			for _, alert := range changed {
+				log.Printf("alert %s: %s (%s = %g)", alert.Status, alert.RuleName, alert.Metric, alert.Value)
+				server.MetricsHub.BroadcastAlert(alert)
+				if err := services.PublishAlert(server.DB, alert); err != nil {
+					log.Printf("alert publish: %v", err)
+				}
+				go notifyAlert(ctx, notifiers, alert)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// metricsListenLoop relays the samples and alerts of the other instances to
+// this instance's WebSocket clients, reconnecting after errors.
+func metricsListenLoop(ctx context.Context, server *httpapi.Server, instance services.MetricsInstance) {
+	for {
+		err := services.ListenMetrics(ctx, server.DB, server.MetricsHub, instance.ID)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("metrics listen: %v", err)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func notifyAlert(ctx context.Context, notifiers []services.AlertNotifier, alert services.Alert) {
+	for _, notifier := range notifiers {
+		notifyCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := notifier.Notify(notifyCtx, alert); err != nil {
+			log.Printf("alert notification (%T): %v", notifier, err)
+		}
+		cancel()
+	}
+}
+
+func metricsRollupLoop(ctx context.Context, server *httpapi.Server) {
+	if server.Config.MetricsRollupSeconds <= 0 {
+		return
+	}
+	retention := services.MetricsRetentionFor(server.Config)
+	ticker := time.NewTicker(time.Duration(server.Config.MetricsRollupSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := services.RollupMetrics(server.DB, retention, time.Now()); err != nil {
+				log.Printf("metrics rollup: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func mediaGCLoop(ctx context.Context, server *httpapi.Server) {
+	if server.Config.MediaGCIntervalMins <= 0 {
+		return
+	}
+	grace := time.Duration(server.Config.MediaGCGraceHours) * time.Hour
+	ticker := time.NewTicker(time.Duration(server.Config.MediaGCIntervalMins) * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report, err := services.CollectOrphanedMedia(server.DB, server.Storage, grace, server.Config.MediaGCDryRun)
+			if err == services.ErrJobRunning {
+				continue
+			}
+			if err != nil {
+				log.Printf("media gc: %v", err)
+				continue
+			}
+			if report.Found > 0 {
+				log.Printf("media gc: found %d orphaned assets (%d bytes), deleted %d, dry run %t", report.Found, report.TotalBytes, report.Deleted, report.DryRun)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func uploadExpiryLoop(ctx context.Context, server *httpapi.Server) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := services.ExpireUploads(server.DB, server.Config.MediaUploadPath)
+			if err != nil {
+				log.Printf("upload expiry: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("upload expiry: removed %d expired uploads", removed)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func mediaScanLoop(ctx context.Context, server *httpapi.Server) {
+	if server.Config.ScanIntervalSeconds <= 0 {
+		return
+	}
+	timeout := time.Duration(server.Config.ScanTimeoutSeconds) * time.Second
+	ticker := time.NewTicker(time.Duration(server.Config.ScanIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report, err := services.ScanPendingAssets(server.DB, server.Storage, server.Scanner, timeout, 50)
+			if err == services.ErrJobRunning {
+				continue
+			}
+			if err != nil {
+				log.Printf("media scan: %v", err)
+				continue
+			}
+			if report.Scanned > 0 || report.Failed > 0 {
+				log.Printf("media scan: scanned %d, quarantined %d, failed %d (%d given up)", report.Scanned, report.Quarantined, report.Failed, report.GaveUp)
+			}
+			// Previews are rendered only once an asset is READY, so files the
+			// background scan just cleared get theirs here.
+			if _, err := services.RenderPendingPDFPreviews(server.DB, server.Storage, server.Config.PDFThumbnailCommand, timeout, 20); err != nil {
+				log.Printf("pdf previews: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func analyticsRollupLoop(ctx context.Context, server *httpapi.Server) {
+	if server.Config.AnalyticsRollupMins <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(server.Config.AnalyticsRollupMins) * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, err := services.RollupVisits(server.DB, server.Config.AnalyticsSecret, time.Now())
+			if err != nil && err != services.ErrJobRunning {
+				log.Printf("analytics rollup: %v", err)
+			}
 		case <-ctx.Done():
 			return
 		}
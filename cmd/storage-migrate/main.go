@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+
+	"fizicamd-backend-go/internal/config"
+	"fizicamd-backend-go/internal/db"
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/joho/godotenv"
+)
+
+// storage-migrate copies every stored media file (blobs and image variants)
+// from one storage backend to another, e.g. before switching STORAGE_BACKEND
+// to s3.
+func main() {
+	from := flag.String("from", services.StorageBackendDisk, "source storage backend (disk or s3)")
+	to := flag.String("to", services.StorageBackendS3, "target storage backend (disk or s3)")
+	overwrite := flag.Bool("overwrite", false, "copy objects that already exist in the target with the same size")
+	dryRun := flag.Bool("dry-run", false, "only report what would be copied")
+	flag.Parse()
+
+	_ = godotenv.Load()
+	cfg := config.Load()
+	if *from == *to {
+		log.Fatalf("source and target backends must differ")
+	}
+
+	database, err := db.Open(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("db: %v", err)
+	}
+	src, err := services.OpenStorage(cfg, *from)
+	if err != nil {
+		log.Fatalf("source storage: %v", err)
+	}
+	dst, err := services.OpenStorage(cfg, *to)
+	if err != nil {
+		log.Fatalf("target storage: %v", err)
+	}
+
+	rows := []struct {
+		ID          string `db:"id"`
+		Bucket      string `db:"bucket"`
+		StorageKey  string `db:"storage_key"`
+		ContentType string `db:"content_type"`
+		SizeBytes   int64  `db:"size_bytes"`
+	}{}
+	if err := database.Select(&rows, `
+SELECT id, bucket, storage_key, content_type, size_bytes FROM media_blobs
+UNION ALL
+SELECT v.asset_id, m.bucket, v.storage_key, v.content_type, v.size_bytes
+FROM media_asset_variants v
+JOIN media_assets m ON m.id = v.asset_id
+ORDER BY 1, 3
+`); err != nil {
+		log.Fatalf("list assets: %v", err)
+	}
+
+	ctx := context.Background()
+	copied, skipped, failed := 0, 0, 0
+	var bytes int64
+	for _, row := range rows {
+		if !*overwrite {
+			if info, err := dst.Stat(ctx, row.Bucket, row.StorageKey); err == nil && info.Size == row.SizeBytes {
+				skipped++
+				continue
+			}
+		}
+		if *dryRun {
+			log.Printf("would copy %s/%s (%d bytes)", row.Bucket, row.StorageKey, row.SizeBytes)
+			copied++
+			bytes += row.SizeBytes
+			continue
+		}
+		size, err := services.CopyObject(ctx, src, dst, row.Bucket, row.StorageKey, row.ContentType)
+		if err != nil {
+			log.Printf("copy %s (%s/%s): %v", row.ID, row.Bucket, row.StorageKey, err)
+			failed++
+			continue
+		}
+		copied++
+		bytes += size
+	}
+	log.Printf("storage migrate %s -> %s: copied %d (%d bytes), skipped %d, failed %d", *from, *to, copied, bytes, skipped, failed)
+	if failed > 0 {
+		log.Fatalf("storage migrate finished with %d failures", failed)
+	}
+}
@@ -1,36 +1,143 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+
+	"golang.org/x/crypto/hkdf"
 )
 
 // Config holds runtime configuration loaded from environment variables.
 type Config struct {
-	DatabaseURL          string
-	JWTSecret            string
-	JWTIssuer            string
-	AccessTTLSeconds     int64
-	RefreshTTLSeconds    int64
-	MediaStoragePath     string
-	MetricsDiskPath      string
-	MetricsSampleSeconds int
-	CorsOrigins          []string
+	DatabaseURL                string
+	JWTSecret                  string
+	JWTIssuer                  string
+	AccessTTLSeconds           int64
+	RefreshTTLSeconds          int64
+	MediaStoragePath           string
+	StorageBackend             string
+	S3Endpoint                 string
+	S3Region                   string
+	S3Bucket                   string
+	S3AccessKey                string
+	S3SecretKey                string
+	S3UseSSL                   bool
+	MetricsDiskPath            string
+	MetricsSampleSeconds       int
+	CorsOrigins                []string
+	MediaGCIntervalMins        int
+	MediaGCGraceHours          int
+	MediaGCDryRun              bool
+	MediaSigningSecret         string
+	MediaURLTTLSeconds         int
+	MediaMaxAvatarMB           int
+	MediaMaxImageMB            int
+	MediaMaxDocumentMB         int
+	MediaMaxArchiveMB          int
+	MediaMaxVideoMB            int
+	MediaUploadPath            string
+	MediaUploadTTLHours        int
+	StorageQuotaStudentMB      int
+	StorageQuotaTeacherMB      int
+	StorageQuotaAdminMB        int
+	ScannerBackend             string
+	ClamdAddress               string
+	ScanTimeoutSeconds         int
+	ScanIntervalSeconds        int
+	ScanMaxMB                  int
+	PDFThumbnailCommand        string
+	MetricsToken               string
+	MetricsListenAddr          string
+	MetricsRawRetentionHours   int
+	MetricsMinuteRetentionDays int
+	MetricsHourRetentionDays   int
+	MetricsRollupSeconds       int
+	AlertWebhookURL            string
+	AlertEmailTo               []string
+	AlertStaleMinutes          int
+	SMTPHost                   string
+	SMTPPort                   int
+	SMTPUsername               string
+	SMTPPassword               string
+	SMTPFrom                   string
+	InstanceID                 string
+	AnalyticsRollupMins        int
+	AnalyticsSecret            string
 }
 
 func Load() Config {
+	jwtSecret := mustEnv("JWT_SECRET")
 	return Config{
-		DatabaseURL:          mustEnv("DATABASE_URL"),
-		JWTSecret:            mustEnv("JWT_SECRET"),
-		JWTIssuer:            envOr("JWT_ISSUER", "fizicamd"),
-		AccessTTLSeconds:     int64(envOrInt("ACCESS_TTL_SECONDS", 14400)),
-		RefreshTTLSeconds:    int64(envOrInt("REFRESH_TTL_SECONDS", 1209600)),
-		MediaStoragePath:     envOr("MEDIA_STORAGE_PATH", "storage/media"),
-		MetricsDiskPath:      envOr("METRICS_DISK_PATH", "storage/media"),
-		MetricsSampleSeconds: envOrInt("METRICS_SAMPLE_INTERVAL", 5),
-		CorsOrigins:          parseCSV(envOr("CORS_ORIGINS", "")),
+		DatabaseURL:                mustEnv("DATABASE_URL"),
+		JWTSecret:                  jwtSecret,
+		JWTIssuer:                  envOr("JWT_ISSUER", "fizicamd"),
+		AccessTTLSeconds:           int64(envOrInt("ACCESS_TTL_SECONDS", 14400)),
+		RefreshTTLSeconds:          int64(envOrInt("REFRESH_TTL_SECONDS", 1209600)),
+		MediaStoragePath:           envOr("MEDIA_STORAGE_PATH", "storage/media"),
+		StorageBackend:             envOr("STORAGE_BACKEND", "disk"),
+		S3Endpoint:                 envOr("S3_ENDPOINT", ""),
+		S3Region:                   envOr("S3_REGION", ""),
+		S3Bucket:                   envOr("S3_BUCKET", ""),
+		S3AccessKey:                envOr("S3_ACCESS_KEY", ""),
+		S3SecretKey:                envOr("S3_SECRET_KEY", ""),
+		S3UseSSL:                   envOrBool("S3_USE_SSL", true),
+		MetricsDiskPath:            envOr("METRICS_DISK_PATH", "storage/media"),
+		MetricsSampleSeconds:       envOrInt("METRICS_SAMPLE_INTERVAL", 5),
+		CorsOrigins:                parseCSV(envOr("CORS_ORIGINS", "")),
+		MediaGCIntervalMins:        envOrInt("MEDIA_GC_INTERVAL_MINUTES", 60),
+		MediaGCGraceHours:          envOrInt("MEDIA_GC_GRACE_HOURS", 168),
+		MediaGCDryRun:              envOrBool("MEDIA_GC_DRY_RUN", true),
+		MediaSigningSecret:         envOr("MEDIA_SIGNING_SECRET", deriveSecret(jwtSecret, "fizicamd media url signing")),
+		MediaURLTTLSeconds:         envOrInt("MEDIA_URL_TTL_SECONDS", 3600),
+		MediaMaxAvatarMB:           envOrInt("MEDIA_MAX_AVATAR_MB", 5),
+		MediaMaxImageMB:            envOrInt("MEDIA_MAX_IMAGE_MB", 15),
+		MediaMaxDocumentMB:         envOrInt("MEDIA_MAX_DOCUMENT_MB", 50),
+		MediaMaxArchiveMB:          envOrInt("MEDIA_MAX_ARCHIVE_MB", 100),
+		MediaMaxVideoMB:            envOrInt("MEDIA_MAX_VIDEO_MB", 500),
+		MediaUploadPath:            envOr("MEDIA_UPLOAD_PATH", "storage/uploads"),
+		MediaUploadTTLHours:        envOrInt("MEDIA_UPLOAD_TTL_HOURS", 24),
+		StorageQuotaStudentMB:      envOrInt("STORAGE_QUOTA_STUDENT_MB", 10),
+		StorageQuotaTeacherMB:      envOrInt("STORAGE_QUOTA_TEACHER_MB", 2048),
+		StorageQuotaAdminMB:        envOrInt("STORAGE_QUOTA_ADMIN_MB", 0),
+		ScannerBackend:             envOr("SCANNER_BACKEND", "none"),
+		ClamdAddress:               envOr("CLAMD_ADDRESS", "tcp://127.0.0.1:3310"),
+		ScanTimeoutSeconds:         envOrInt("SCAN_TIMEOUT_SECONDS", 60),
+		ScanIntervalSeconds:        envOrInt("SCAN_INTERVAL_SECONDS", 30),
+		ScanMaxMB:                  envOrInt("SCAN_MAX_MB", 25),
+		PDFThumbnailCommand:        envOr("PDF_THUMBNAIL_COMMAND", "pdftoppm"),
+		MetricsToken:               envOr("METRICS_TOKEN", ""),
+		MetricsListenAddr:          envOr("METRICS_LISTEN_ADDR", ""),
+		MetricsRawRetentionHours:   envOrInt("METRICS_RAW_RETENTION_HOURS", 48),
+		MetricsMinuteRetentionDays: envOrInt("METRICS_MINUTE_RETENTION_DAYS", 30),
+		MetricsHourRetentionDays:   envOrInt("METRICS_HOUR_RETENTION_DAYS", 730),
+		MetricsRollupSeconds:       envOrInt("METRICS_ROLLUP_INTERVAL_SECONDS", 60),
+		AlertWebhookURL:            envOr("ALERT_WEBHOOK_URL", ""),
+		AlertEmailTo:               parseCSV(envOr("ALERT_EMAIL_TO", "")),
+		AlertStaleMinutes:          envOrInt("ALERT_STALE_MINUTES", 10),
+		SMTPHost:                   envOr("SMTP_HOST", ""),
+		SMTPPort:                   envOrInt("SMTP_PORT", 587),
+		SMTPUsername:               envOr("SMTP_USERNAME", ""),
+		SMTPPassword:               envOr("SMTP_PASSWORD", ""),
+		SMTPFrom:                   envOr("SMTP_FROM", "alerts@fizicamd.local"),
+		InstanceID:                 envOr("INSTANCE_ID", ""),
+		AnalyticsRollupMins:        envOrInt("ANALYTICS_ROLLUP_INTERVAL_MINUTES", 15),
+		AnalyticsSecret:            envOr("ANALYTICS_SECRET", deriveSecret(jwtSecret, "fizicamd visitor hash")),
+	}
+}
+
+// deriveSecret derives a key for one purpose from a master secret with HKDF,
+// so the same master (JWT_SECRET) can back several HMACs without a signature
+// for one being valid for another.
+func deriveSecret(master, label string) string {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(master), nil, []byte(label)), key); err != nil {
+		panic("derive secret: " + err.Error())
 	}
+	return hex.EncodeToString(key)
 }
 
 func mustEnv(key string) string {
@@ -61,6 +168,18 @@ func envOrInt(key string, fallback int) int {
 	return parsed
 }
 
+func envOrBool(key string, fallback bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
 func parseCSV(raw string) []string {
 	if strings.TrimSpace(raw) == "" {
 		return nil
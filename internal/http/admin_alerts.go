@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type AlertsResponse struct {
+	Items    []services.Alert `json:"items"`
+	Total    int              `json:"total"`
+	Page     int              `json:"page"`
+	PageSize int              `json:"pageSize"`
+}
+
+type AlertRulesResponse struct {
+	Items   []services.AlertRule `json:"items"`
+	Metrics []string             `json:"metrics"`
+}
+
+type AlertRuleRequest struct {
+	Name           string  `json:"name"`
+	Metric         string  `json:"metric"`
+	Comparison     string  `json:"comparison"`
+	Threshold      float64 `json:"threshold"`
+	ClearThreshold float64 `json:"clearThreshold"`
+	ForSeconds     int     `json:"forSeconds"`
+	Enabled        *bool   `json:"enabled"`
+}
+
+// AdminListAlerts returns the alert history, newest first. status=FIRING
+// lists the alerts currently firing.
+func (s *Server) AdminListAlerts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page := parseInt(query.Get("page"), 1)
+	pageSize := parseInt(query.Get("pageSize"), 20)
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	status := strings.ToUpper(strings.TrimSpace(query.Get("status")))
+	if status != "" && status != services.AlertStatusFiring && status != services.AlertStatusResolved {
+		WriteError(w, http.StatusBadRequest, "Statusul trebuie să fie FIRING sau RESOLVED.")
+		return
+	}
+	items, total, err := services.ListAlerts(s.DB, status, pageSize, (page-1)*pageSize)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, AlertsResponse{Items: items, Total: total, Page: page, PageSize: pageSize})
+}
+
+func (s *Server) AdminListAlertRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := services.ListAlertRules(s.DB)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, AlertRulesResponse{Items: rules, Metrics: services.AlertMetricNames()})
+}
+
+func (s *Server) AdminCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	s.saveAlertRule(w, r, "", http.StatusCreated)
+}
+
+func (s *Server) AdminUpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	s.saveAlertRule(w, r, chi.URLParam(r, "ruleId"), http.StatusOK)
+}
+
+func (s *Server) saveAlertRule(w http.ResponseWriter, r *http.Request, ruleID string, status int) {
+	var req AlertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid payload")
+		return
+	}
+	rule, err := services.SaveAlertRule(s.DB, services.AlertRule{
+		ID:             ruleID,
+		Name:           req.Name,
+		Metric:         req.Metric,
+		Comparison:     req.Comparison,
+		Threshold:      req.Threshold,
+		ClearThreshold: req.ClearThreshold,
+		ForSeconds:     req.ForSeconds,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+	})
+	if err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	WriteJSON(w, status, rule)
+}
+
+func (s *Server) AdminDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	if err := services.DeleteAlertRule(s.DB, chi.URLParam(r, "ruleId")); err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,140 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const defaultAnalyticsDays = 30
+
+type AnalyticsVisitsResponse struct {
+	Interval string                     `json:"interval"`
+	From     string                     `json:"from"`
+	To       string                     `json:"to"`
+	Totals   services.AnalyticsPeriod   `json:"totals"`
+	Items    []services.AnalyticsPeriod `json:"items"`
+}
+
+type AnalyticsCountsResponse struct {
+	Items []services.AnalyticsCount `json:"items"`
+}
+
+type AnalyticsDevicesResponse struct {
+	Browsers []services.AnalyticsCount `json:"browsers"`
+	OS       []services.AnalyticsCount `json:"os"`
+	Devices  []services.AnalyticsCount `json:"devices"`
+}
+
+// analyticsRange reads the from/to days (to inclusive), defaulting to the
+// last 30 days. It returns to as the exclusive end.
+func analyticsRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	query := r.URL.Query()
+	end := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	to, err := parseDateParam(query.Get("to"), true)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Parametrul to este invalid.")
+		return time.Time{}, time.Time{}, false
+	}
+	if to != nil {
+		end = to.UTC().Truncate(24 * time.Hour)
+	}
+	start := end.AddDate(0, 0, -defaultAnalyticsDays)
+	from, err := parseDateParam(query.Get("from"), false)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Parametrul from este invalid.")
+		return time.Time{}, time.Time{}, false
+	}
+	if from != nil {
+		start = from.UTC().Truncate(24 * time.Hour)
+	}
+	if !start.Before(end) {
+		WriteError(w, http.StatusBadRequest, "Intervalul este invalid.")
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// AdminAnalyticsVisits returns visits and unique, new and returning visitors
+// per day, week or month (interval), with totals for the range.
+func (s *Server) AdminAnalyticsVisits(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := analyticsRange(w, r)
+	if !ok {
+		return
+	}
+	interval := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("interval")))
+	switch interval {
+	case "":
+		interval = services.AnalyticsIntervalDay
+	case services.AnalyticsIntervalDay, services.AnalyticsIntervalWeek, services.AnalyticsIntervalMonth:
+	default:
+		WriteError(w, http.StatusBadRequest, "Intervalul trebuie să fie day, week sau month.")
+		return
+	}
+	items, totals, err := services.VisitsByPeriod(s.DB, interval, from, to)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, AnalyticsVisitsResponse{
+		Interval: interval,
+		From:     from.Format("2006-01-02"),
+		To:       to.AddDate(0, 0, -1).Format("2006-01-02"),
+		Totals:   totals,
+		Items:    items,
+	})
+}
+
+func (s *Server) AdminAnalyticsPaths(w http.ResponseWriter, r *http.Request) {
+	s.analyticsTop(w, r, services.TopVisitPaths)
+}
+
+func (s *Server) AdminAnalyticsReferrers(w http.ResponseWriter, r *http.Request) {
+	s.analyticsTop(w, r, services.TopReferrers)
+}
+
+func (s *Server) analyticsTop(w http.ResponseWriter, r *http.Request, top func(db *sqlx.DB, from, to time.Time, limit int) ([]services.AnalyticsCount, error)) {
+	from, to, ok := analyticsRange(w, r)
+	if !ok {
+		return
+	}
+	limit := parseInt(r.URL.Query().Get("limit"), 20)
+	if limit > 100 {
+		limit = 100
+	}
+	items, err := top(s.DB, from, to, limit)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, AnalyticsCountsResponse{Items: items})
+}
+
+// AdminAnalyticsDevices breaks visits down by browser, OS and device class.
+func (s *Server) AdminAnalyticsDevices(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := analyticsRange(w, r)
+	if !ok {
+		return
+	}
+	response := AnalyticsDevicesResponse{}
+	for _, part := range []struct {
+		dimension string
+		target    *[]services.AnalyticsCount
+	}{
+		{"browser", &response.Browsers},
+		{"os", &response.OS},
+		{"device", &response.Devices},
+	} {
+		items, err := services.AgentBreakdown(s.DB, part.dimension, from, to)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		*part.target = items
+	}
+	WriteJSON(w, http.StatusOK, response)
+}
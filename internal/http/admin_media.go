@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"net/http"
+	"time"
+
+	"fizicamd-backend-go/internal/services"
+)
+
+func (s *Server) mediaGCGrace(r *http.Request) time.Duration {
+	hours := parseInt(r.URL.Query().Get("graceHours"), s.Config.MediaGCGraceHours)
+	return time.Duration(hours) * time.Hour
+}
+
+func (s *Server) AdminOrphanedMedia(w http.ResponseWriter, r *http.Request) {
+	report, err := services.CollectOrphanedMedia(s.DB, s.Storage, s.mediaGCGrace(r), true)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, report)
+}
+
+// AdminRunMediaGC runs a sweep on demand. Like the background sweep it only
+// reports what it would delete unless dryRun=false is passed explicitly.
+func (s *Server) AdminRunMediaGC(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dryRun") != "false"
+	report, err := services.CollectOrphanedMedia(s.DB, s.Storage, s.mediaGCGrace(r), dryRun)
+	if err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	WriteJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) AdminMediaDedup(w http.ResponseWriter, r *http.Request) {
+	report, err := services.BuildMediaDedupReport(s.DB, parseInt(r.URL.Query().Get("limit"), 20))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, report)
+}
+
+func (s *Server) AdminStorageConsumers(w http.ResponseWriter, r *http.Request) {
+	items, err := services.TopStorageConsumers(s.DB, s.Config, parseInt(r.URL.Query().Get("limit"), 20))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string][]services.StorageConsumer{"items": items})
+}
@@ -287,7 +287,7 @@ func (s *Server) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	var avatarID *string
 	_ = s.DB.Get(&avatarID, `SELECT avatar_media_id FROM user_profiles WHERE user_id = $1`, userID)
 	if avatarID != nil && *avatarID != "" {
-		_ = services.DeleteAsset(s.DB, s.Config.MediaStoragePath, *avatarID)
+		_ = services.DeleteAsset(s.DB, s.Storage, *avatarID)
 	}
 	_, _ = s.DB.Exec(`DELETE FROM users WHERE id = $1`, userID)
 	w.WriteHeader(http.StatusNoContent)
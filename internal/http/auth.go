@@ -19,39 +19,58 @@ const (
 func WithAuth(tokenService services.TokenService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			auth := r.Header.Get("Authorization")
-			if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
+			ctx, ok := authenticate(tokenService, r)
+			if !ok {
 				WriteError(w, http.StatusUnauthorized, "Authentication failed")
 				return
 			}
-			tokenStr := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
-			token, claims, err := tokenService.ParseToken(tokenStr)
-			if err != nil || !token.Valid {
-				WriteError(w, http.StatusUnauthorized, "Authentication failed")
-				return
-			}
-			if claims["typ"] != "access" {
-				WriteError(w, http.StatusUnauthorized, "Authentication failed")
-				return
-			}
-			userID, _ := claims["sub"].(string)
-			email, _ := claims["email"].(string)
-			roles := []string{}
-			if rawRoles, ok := claims["roles"].([]interface{}); ok {
-				for _, r := range rawRoles {
-					if s, ok := r.(string); ok {
-						roles = append(roles, s)
-					}
-				}
-			}
-			ctx := context.WithValue(r.Context(), ctxUserID, userID)
-			ctx = context.WithValue(ctx, ctxEmail, email)
-			ctx = context.WithValue(ctx, ctxRoles, roles)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// OptionalAuth attaches the caller identity when a valid access token is sent
+// and lets anonymous requests through otherwise.
+func OptionalAuth(tokenService services.TokenService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ctx, ok := authenticate(tokenService, r); ok {
+				r = r.WithContext(ctx)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func authenticate(tokenService services.TokenService, r *http.Request) (context.Context, bool) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" || !strings.HasPrefix(auth, "Bearer ") {
+		return nil, false
+	}
+	tokenStr := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	token, claims, err := tokenService.ParseToken(tokenStr)
+	if err != nil || !token.Valid {
+		return nil, false
+	}
+	if claims["typ"] != "access" {
+		return nil, false
+	}
+	userID, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	roles := []string{}
+	if rawRoles, ok := claims["roles"].([]interface{}); ok {
+		for _, r := range rawRoles {
+			if s, ok := r.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	}
+	ctx := context.WithValue(r.Context(), ctxUserID, userID)
+	ctx = context.WithValue(ctx, ctxEmail, email)
+	ctx = context.WithValue(ctx, ctxRoles, roles)
+	return ctx, true
+}
+
 func CurrentUserID(r *http.Request) string {
 	if value, ok := r.Context().Value(ctxUserID).(string); ok {
 		return value
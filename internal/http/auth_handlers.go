@@ -138,7 +138,7 @@ ORDER BY r.code
 		return
 	}
 	_ = services.SetLastLogin(s.DB, row.ID)
-	userDTO, err := buildUserDTO(s.DB, row.ID)
+	userDTO, err := buildUserDTO(s.DB, s.Signer, row.ID)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
@@ -184,7 +184,7 @@ ORDER BY r.code
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
-	userDTO, err := buildUserDTO(s.DB, userID)
+	userDTO, err := buildUserDTO(s.DB, s.Signer, userID)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
@@ -0,0 +1,21 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"fizicamd-backend-go/internal/services"
+)
+
+type BlockTypeDTO struct {
+	Type   string                 `json:"type"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+func (s *Server) ListBlockTypes(w http.ResponseWriter, r *http.Request) {
+	handlers := services.BlockHandlers()
+	items := make([]BlockTypeDTO, 0, len(handlers))
+	for _, handler := range handlers {
+		items = append(items, BlockTypeDTO{Type: handler.Type, Schema: handler.Schema})
+	}
+	WriteJSON(w, http.StatusOK, map[string][]BlockTypeDTO{"items": items})
+}
@@ -1,17 +1,22 @@
 package httpapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
-	"path/filepath"
 	"strings"
 	"time"
 
 	"fizicamd-backend-go/internal/services"
-
-	"github.com/go-chi/chi/v5"
 )
 
+// multipartOverhead is the room allowed for multipart boundaries and headers
+// around an uploaded file.
+const multipartOverhead = 1 << 20
+
 type ProfileUpdateRequest struct {
 	FirstName  *string `json:"firstName"`
 	LastName   *string `json:"lastName"`
@@ -31,7 +36,7 @@ type ChangePasswordRequest struct {
 
 func (s *Server) Me(w http.ResponseWriter, r *http.Request) {
 	userID := CurrentUserID(r)
-	userDTO, err := buildUserDTO(s.DB, userID)
+	userDTO, err := buildUserDTO(s.DB, s.Signer, userID)
 	if err != nil {
 		WriteError(w, http.StatusNotFound, "User not found")
 		return
@@ -69,7 +74,7 @@ WHERE user_id = $1
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
-	userDTO, err := buildUserDTO(s.DB, userID)
+	userDTO, err := buildUserDTO(s.DB, s.Signer, userID)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
@@ -117,7 +122,7 @@ func (s *Server) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 	var avatarID *string
 	_ = s.DB.Get(&avatarID, `SELECT avatar_media_id FROM user_profiles WHERE user_id = $1`, userID)
 	if avatarID != nil && strings.TrimSpace(*avatarID) != "" {
-		_ = services.DeleteAsset(s.DB, s.Config.MediaStoragePath, *avatarID)
+		_ = services.DeleteAsset(s.DB, s.Storage, *avatarID)
 	}
 	_, _ = s.DB.Exec(`DELETE FROM users WHERE id = $1`, userID)
 	w.WriteHeader(http.StatusNoContent)
@@ -129,33 +134,47 @@ func (s *Server) Ping(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *Server) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+type StorageUsageDTO struct {
+	UsedBytes      int64                       `json:"usedBytes"`
+	LimitBytes     *int64                      `json:"limitBytes"`
+	AvailableBytes *int64                      `json:"availableBytes"`
+	ByType         []services.StorageTypeUsage `json:"byType"`
+}
+
+func (s *Server) MyStorage(w http.ResponseWriter, r *http.Request) {
 	userID := CurrentUserID(r)
-	if err := r.ParseMultipartForm(20 << 20); err != nil {
-		WriteError(w, http.StatusBadRequest, "Fișierul este gol.")
-		return
-	}
-	file, header, err := r.FormFile("file")
+	quota, err := services.QuotaFor(s.DB, s.Config, userID)
 	if err != nil {
-		WriteError(w, http.StatusBadRequest, "Fișierul este gol.")
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
-	defer file.Close()
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
-	}
-	assetID, url, err := services.SaveMediaAsset(s.DB, s.Config.MediaStoragePath, services.BucketUsers, contentType, header.Filename, "AVATAR", userID, file)
+	byType, err := services.StorageUsageByType(s.DB, userID)
 	if err != nil {
-		if serr, ok := err.(services.ServiceError); ok {
-			WriteError(w, serr.Status, serr.Message)
-			return
-		}
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	dto := StorageUsageDTO{UsedBytes: quota.UsedBytes, ByType: byType}
+	if available, limited := quota.Available(); limited {
+		dto.LimitBytes = &quota.LimitBytes
+		dto.AvailableBytes = &available
+	}
+	WriteJSON(w, http.StatusOK, dto)
+}
+
+func (s *Server) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	userID := CurrentUserID(r)
 	var previous *string
 	_ = s.DB.Get(&previous, `SELECT avatar_media_id FROM user_profiles WHERE user_id = $1`, userID)
+	policy := services.AvatarUploadPolicy(s.Config)
+	quota, ok := s.uploadQuota(w, userID, previous)
+	if !ok {
+		return
+	}
+	policy.Quota = quota
+	assetID, ok := s.receiveUpload(w, r, policy, userID)
+	if !ok {
+		return
+	}
 	_, _ = s.DB.Exec(`
 INSERT INTO user_profiles (user_id, created_at, updated_at, contact_json, metadata)
 VALUES ($1,$2,$2,'{}','{}')
@@ -163,63 +182,122 @@ ON CONFLICT (user_id) DO NOTHING
 `, userID, time.Now().UTC())
 	_, _ = s.DB.Exec(`UPDATE user_profiles SET avatar_media_id = $1, updated_at = $2 WHERE user_id = $3`, assetID, time.Now().UTC(), userID)
 	if previous != nil && *previous != "" && *previous != assetID {
-		_ = services.DeleteAsset(s.DB, s.Config.MediaStoragePath, *previous)
+		_ = services.DeleteAsset(s.DB, s.Storage, *previous)
 	}
-	WriteJSON(w, http.StatusOK, map[string]string{"assetId": assetID, "url": url})
+	WriteJSON(w, http.StatusOK, s.uploadResponse(assetID))
 }
 
 func (s *Server) UploadResource(w http.ResponseWriter, r *http.Request) {
 	userID := CurrentUserID(r)
-	if err := r.ParseMultipartForm(50 << 20); err != nil {
-		WriteError(w, http.StatusBadRequest, "Fișierul este gol.")
+	policy := services.ResourceUploadPolicy(s.Config)
+	quota, ok := s.uploadQuota(w, userID, nil)
+	if !ok {
+		return
+	}
+	policy.Quota = quota
+	assetID, ok := s.receiveUpload(w, r, policy, userID)
+	if !ok {
 		return
 	}
+	WriteJSON(w, http.StatusOK, s.uploadResponse(assetID))
+}
+
+func (s *Server) uploadResponse(assetID string) map[string]string {
+	url := s.Signer.SignedURL(assetID)
+	status := services.MediaStatusPendingScan
+	_ = s.DB.Get(&status, `SELECT status FROM media_assets WHERE id = $1`, assetID)
+	response := map[string]string{"assetId": assetID, "url": url, "status": status}
+	if srcset := assetSrcset(s.DB, &assetID, &url); srcset != nil {
+		response["srcset"] = *srcset
+	}
+	return response
+}
+
+// uploadQuota loads the caller's storage quota. The asset being replaced, if
+// any, does not count towards usage since it is deleted after the upload.
+func (s *Server) uploadQuota(w http.ResponseWriter, userID string, replacing *string) (*services.StorageQuota, bool) {
+	quota, err := services.QuotaFor(s.DB, s.Config, userID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return nil, false
+	}
+	if replacing != nil && *replacing != "" {
+		var size int64
+		_ = s.DB.Get(&size, `SELECT size_bytes FROM media_assets WHERE id = $1 AND owner_user_id = $2`, *replacing, userID)
+		quota.UsedBytes -= size
+	}
+	return &quota, true
+}
+
+// receiveUpload stores the "file" form field under policy and writes the error
+// response when the upload is rejected. The request body is capped slightly
+// above the largest size the policy accepts.
+func (s *Server) receiveUpload(w http.ResponseWriter, r *http.Request, policy services.UploadPolicy, userID string) (string, bool) {
+	if policy.Quota != nil && r.ContentLength > multipartOverhead {
+		if err := policy.Quota.Check(r.ContentLength - multipartOverhead); err != nil {
+			mapServiceError(w, err)
+			return "", false
+		}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, policy.MaxBytes()+multipartOverhead)
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			WriteError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Fișierul depășește limita de %d MB.", policy.MaxBytes()>>20))
+			return "", false
+		}
+		WriteError(w, http.StatusBadRequest, "Fișierul este gol.")
+		return "", false
+	}
+	defer r.MultipartForm.RemoveAll()
 	file, header, err := r.FormFile("file")
 	if err != nil {
 		WriteError(w, http.StatusBadRequest, "Fișierul este gol.")
-		return
+		return "", false
 	}
 	defer file.Close()
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = "application/octet-stream"
-	}
-	mediaType := "OTHER"
-	if strings.HasPrefix(contentType, "image/") {
-		mediaType = "IMAGE"
-	} else if strings.EqualFold(contentType, "application/pdf") {
-		mediaType = "DOCUMENT"
-	}
-	assetID, url, err := services.SaveMediaAsset(s.DB, s.Config.MediaStoragePath, services.BucketResources, contentType, header.Filename, mediaType, userID, file)
+	assetID, _, err := services.SaveMediaAsset(s.DB, s.Storage, policy, header.Header.Get("Content-Type"), header.Filename, userID, file)
 	if err != nil {
-		if serr, ok := err.(services.ServiceError); ok {
-			WriteError(w, serr.Status, serr.Message)
-			return
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
 		}
-		WriteError(w, http.StatusInternalServerError, "Internal server error")
-		return
+		return "", false
 	}
-	WriteJSON(w, http.StatusOK, map[string]string{"assetId": assetID, "url": url})
+	switch s.scanUpload(assetID) {
+	case services.MediaStatusQuarantined:
+		WriteError(w, http.StatusUnprocessableEntity, quarantinedMessage)
+		return "", false
+	case services.MediaStatusScanFailed:
+		WriteError(w, http.StatusUnprocessableEntity, scanFailedMessage)
+		return "", false
+	}
+	return assetID, true
 }
 
-func (s *Server) MediaContent(w http.ResponseWriter, r *http.Request) {
-	assetID := chi.URLParam(r, "assetId")
-	row := struct {
-		Bucket      string  `db:"bucket"`
-		StorageKey  string  `db:"storage_key"`
-		Filename    *string `db:"filename"`
-		ContentType string  `db:"content_type"`
-	}{}
-	if err := s.DB.Get(&row, `SELECT bucket, storage_key, filename, content_type FROM media_assets WHERE id = $1`, assetID); err != nil {
-		WriteError(w, http.StatusNotFound, "Media negăsită")
-		return
-	}
-	path := filepath.Join(s.Config.MediaStoragePath, row.Bucket, row.StorageKey)
-	if row.Filename != nil {
-		w.Header().Set("Content-Disposition", "inline; filename=\""+*row.Filename+"\"")
+const (
+	quarantinedMessage = "Fișierul a fost blocat de scanarea antivirus."
+	scanFailedMessage  = "Fișierul nu a putut fi scanat antivirus."
+)
+
+// scanUpload scans a freshly stored asset. When the scanner is unavailable
+// the asset stays PENDING_SCAN and the background scan loop retries it,
+// unless the file cannot be scanned at all (SCAN_FAILED).
+func (s *Server) scanUpload(assetID string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.Config.ScanTimeoutSeconds)*time.Second)
+	defer cancel()
+	status, err := services.ScanAsset(ctx, s.DB, s.Storage, s.Scanner, assetID)
+	if err != nil {
+		log.Printf("scan asset %s: %v", assetID, err)
+		if status == services.MediaStatusScanFailed {
+			return status
+		}
+		return services.MediaStatusPendingScan
 	}
-	if row.ContentType != "" {
-		w.Header().Set("Content-Type", row.ContentType)
+	if status == services.MediaStatusReady {
+		// A preview is optional; the file is served either way.
+		if err := services.RenderPDFPreview(context.Background(), s.DB, s.Storage, s.Config.PDFThumbnailCommand, assetID); err != nil {
+			log.Printf("pdf preview %s: %v", assetID, err)
+		}
 	}
-	http.ServeFile(w, r, path)
+	return status
 }
@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+type MediaAccessRequest struct {
+	Policy  string  `json:"policy"`
+	GroupID *string `json:"groupId"`
+}
+
+// authorizeMediaRead writes the error response and returns false when the
+// caller may not read the asset. A valid signature grants access regardless
+// of the policy.
+func (s *Server) authorizeMediaRead(w http.ResponseWriter, r *http.Request, assetID string) (services.AssetAccess, bool) {
+	access, err := services.GetAssetAccess(s.DB, assetID)
+	if err != nil {
+		WriteError(w, http.StatusNotFound, "Media negăsită")
+		return access, false
+	}
+	query := r.URL.Query()
+	if s.Signer.Verify(access.ID, query.Get("expires"), query.Get("sig")) {
+		return access, true
+	}
+	userID := CurrentUserID(r)
+	allowed, err := services.CanReadAsset(s.DB, access, userID, hasRole(CurrentRoles(r), "ADMIN"))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return access, false
+	}
+	if allowed {
+		return access, true
+	}
+	if userID == "" {
+		WriteError(w, http.StatusUnauthorized, "Authentication failed")
+		return access, false
+	}
+	WriteError(w, http.StatusForbidden, "Nu aveți acces la acest fișier.")
+	return access, false
+}
+
+func (s *Server) MediaSignedURL(w http.ResponseWriter, r *http.Request) {
+	assetID := chi.URLParam(r, "assetId")
+	if _, ok := s.authorizeMediaRead(w, r, assetID); !ok {
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]string{"url": s.Signer.SignedURL(assetID)})
+}
+
+func (s *Server) UpdateMediaAccess(w http.ResponseWriter, r *http.Request) {
+	assetID := chi.URLParam(r, "assetId")
+	var req MediaAccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid payload")
+		return
+	}
+	access, err := services.GetAssetAccess(s.DB, assetID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			WriteError(w, http.StatusNotFound, "Media negăsită")
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	userID := CurrentUserID(r)
+	isAdmin := hasRole(CurrentRoles(r), "ADMIN")
+	if !isAdmin && (access.OwnerID == nil || *access.OwnerID != userID) {
+		WriteError(w, http.StatusForbidden, "Nu aveți acces la acest fișier.")
+		return
+	}
+	groupID := nullIfEmpty(strings.TrimSpace(ptrToString(req.GroupID)))
+	if err := services.SetAssetAccess(s.DB, access.ID, strings.ToUpper(strings.TrimSpace(req.Policy)), groupID, userID, isAdmin); err != nil {
+		if mapServiceError(w, err) {
+			return
+		}
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// signedAssetURL returns a signed content URL for an optional asset ID.
+func (s *Server) signedAssetURL(assetID *string) *string {
+	if assetID == nil || *assetID == "" {
+		return nil
+	}
+	url := s.Signer.SignedURL(*assetID)
+	return &url
+}
+
+// assetSrcset builds an <img srcset> value from the stored image variants of
+// an asset, appending ?w= to url. It returns nil when there are no variants.
+func assetSrcset(db *sqlx.DB, assetID, url *string) *string {
+	if assetID == nil || url == nil {
+		return nil
+	}
+	widths, err := services.ImageVariantWidthsFor(db, *assetID)
+	if err != nil {
+		return nil
+	}
+	return srcsetFor(url, widths)
+}
+
+// assetVariantWidths loads the variant widths of every asset in ids with one
+// query, for list endpoints that build a srcset per row. Errors yield an
+// empty map, i.e. no srcsets.
+func assetVariantWidths(db *sqlx.DB, ids []*string) map[string][]int {
+	assetIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != nil {
+			assetIDs = append(assetIDs, *id)
+		}
+	}
+	widths, err := services.ImageVariantWidthsForAssets(db, assetIDs)
+	if err != nil {
+		return map[string][]int{}
+	}
+	return widths
+}
+
+// srcsetFor builds an <img srcset> value from variant widths, appending ?w=
+// to url. It returns nil when there are no widths.
+func srcsetFor(url *string, widths []int) *string {
+	if url == nil || len(widths) == 0 {
+		return nil
+	}
+	separator := "?"
+	if strings.Contains(*url, "?") {
+		separator = "&"
+	}
+	entries := make([]string, 0, len(widths))
+	for _, width := range widths {
+		entries = append(entries, fmt.Sprintf("%s%sw=%d %dw", *url, separator, width, width))
+	}
+	srcset := strings.Join(entries, ", ")
+	return &srcset
+}
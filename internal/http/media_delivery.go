@@ -0,0 +1,175 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// publicMediaCache applies to PUBLIC assets. Their policy is derived from the
+// resources embedding them and can turn PRIVATE at any time, so shared caches
+// keep them briefly and then revalidate with the ETag. Image variants are
+// rendered once from the bytes their ETag names and never change, so
+// publicVariantCache lets caches keep them for a year.
+const (
+	publicMediaCache   = "public, max-age=300, must-revalidate"
+	publicVariantCache = "public, max-age=31536000, immutable"
+	privateMediaCache  = "private, max-age=300"
+)
+
+// MediaContent serves an asset (or one of its image variants with ?w=) with a
+// strong ETag derived from the stored sha256. HEAD, conditional and range
+// requests are handled by http.ServeContent over the storage reader, which
+// seeks in both the disk and S3 backends. ?download=1 forces an attachment.
+func (s *Server) MediaContent(w http.ResponseWriter, r *http.Request) {
+	assetID := chi.URLParam(r, "assetId")
+	access, ok := s.authorizeMediaRead(w, r, assetID)
+	if !ok {
+		return
+	}
+	row := struct {
+		Bucket      string  `db:"bucket"`
+		StorageKey  string  `db:"storage_key"`
+		Filename    *string `db:"filename"`
+		ContentType string  `db:"content_type"`
+		Status      string  `db:"status"`
+		SHA256      *string `db:"sha256"`
+	}{}
+	if err := s.DB.Get(&row, `SELECT bucket, storage_key, filename, content_type, status, sha256 FROM media_assets WHERE id = $1::uuid`, assetID); err != nil {
+		WriteError(w, http.StatusNotFound, "Media negăsită")
+		return
+	}
+	switch row.Status {
+	case services.MediaStatusReady:
+	case services.MediaStatusQuarantined:
+		WriteError(w, http.StatusForbidden, quarantinedMessage)
+		return
+	case services.MediaStatusScanFailed:
+		WriteError(w, http.StatusForbidden, scanFailedMessage)
+		return
+	default:
+		w.Header().Set("Retry-After", "10")
+		WriteError(w, http.StatusConflict, "Fișierul este în curs de scanare.")
+		return
+	}
+	etag := ""
+	variantServed := false
+	if row.SHA256 != nil && *row.SHA256 != "" {
+		etag = *row.SHA256
+	}
+	if width := parseInt(r.URL.Query().Get("w"), 0); width > 0 {
+		variant, err := services.PickImageVariant(s.DB, assetID, width)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if variant != nil {
+			row.StorageKey = variant.StorageKey
+			row.ContentType = variant.ContentType
+			row.Filename = nil
+			variantServed = true
+			if etag != "" {
+				etag = fmt.Sprintf("%s-w%d", etag, variant.Width)
+			}
+		}
+	}
+
+	header := w.Header()
+	switch {
+	case access.AccessPolicy == services.AccessPublic && variantServed:
+		header.Set("Cache-Control", publicVariantCache)
+	case access.AccessPolicy == services.AccessPublic:
+		header.Set("Cache-Control", publicMediaCache)
+	default:
+		header.Set("Cache-Control", privateMediaCache)
+		header.Set("Vary", "Authorization")
+	}
+	if etag != "" {
+		header.Set("ETag", `"`+etag+`"`)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	disposition := "inline"
+	if r.URL.Query().Get("download") == "1" {
+		disposition = "attachment"
+	}
+	header.Set("Content-Disposition", contentDisposition(disposition, ptrToString(row.Filename)))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if row.ContentType != "" {
+		header.Set("Content-Type", row.ContentType)
+	}
+
+	reader, info, err := s.Storage.Get(r.Context(), row.Bucket, row.StorageKey)
+	if err != nil {
+		header.Del("Cache-Control")
+		header.Del("ETag")
+		header.Del("Content-Disposition")
+		WriteError(w, http.StatusNotFound, "Media negăsită")
+		return
+	}
+	defer reader.Close()
+	http.ServeContent(w, r, "", info.ModTime, reader)
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == `"`+etag+`"` {
+			return true
+		}
+	}
+	return false
+}
+
+var filenameFallback = strings.NewReplacer(
+	"ă", "a", "â", "a", "î", "i", "ș", "s", "ş", "s", "ț", "t", "ţ", "t",
+	"Ă", "A", "Â", "A", "Î", "I", "Ș", "S", "Ş", "S", "Ț", "T", "Ţ", "T",
+)
+
+// contentDisposition formats an RFC 6266 header with an ASCII filename
+// fallback (Romanian diacritics transliterated, other characters replaced)
+// and the exact UTF-8 name in filename*.
+func contentDisposition(disposition, filename string) string {
+	filename = strings.TrimSpace(filename)
+	if filename == "" {
+		return disposition
+	}
+	var fallback strings.Builder
+	for _, r := range filenameFallback.Replace(filename) {
+		switch {
+		case r == '"' || r == '\\' || r < 0x20 || r == 0x7f:
+			fallback.WriteByte('_')
+		case r < utf8.RuneSelf:
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteByte('_')
+		}
+	}
+	value := disposition + `; filename="` + fallback.String() + `"`
+	if fallback.String() != filename {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// encodeRFC5987 percent-encodes everything outside RFC 5987 attr-char.
+func encodeRFC5987(value string) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			strings.IndexByte("!#$&+-.^_`|~", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -1,7 +1,11 @@
 package httpapi
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"fizicamd-backend-go/internal/services"
 
@@ -9,60 +13,215 @@ import (
 )
 
 type MetricsHistoryResponse struct {
-	Items []services.MetricSample `json:"items"`
+	Resolution string                 `json:"resolution"`
+	Items      []services.MetricPoint `json:"items"`
 }
 
+// MetricsHistory returns the latest raw samples, or with from (and optionally
+// to) the samples of that range. resolution is raw, 1m, 1h or auto (the
+// default), which picks a tier from the span of the range. instance limits
+// the points to one server instance; without it every instance is returned.
 func (s *Server) MetricsHistory(w http.ResponseWriter, r *http.Request) {
-	limit := parseInt(r.URL.Query().Get("limit"), 120)
-	if limit > 500 {
-		limit = 500
+	query := r.URL.Query()
+	instance := strings.TrimSpace(query.Get("instance"))
+	if query.Get("from") == "" && query.Get("to") == "" {
+		limit := parseInt(query.Get("limit"), 120)
+		if limit > 500 {
+			limit = 500
+		}
+		samples, err := services.LatestMetrics(s.DB, limit, instance)
+		if err != nil {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		items := make([]services.MetricPoint, 0, len(samples))
+		for _, sample := range samples {
+			items = append(items, services.MetricPoint{MetricSample: sample})
+		}
+		WriteJSON(w, http.StatusOK, MetricsHistoryResponse{Resolution: services.MetricsResolutionRaw, Items: items})
+		return
+	}
+	now := time.Now().UTC()
+	from, err := parseDateParam(query.Get("from"), false)
+	if err != nil || from == nil {
+		WriteError(w, http.StatusBadRequest, "Parametrul from este invalid.")
+		return
+	}
+	to, err := parseDateParam(query.Get("to"), true)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Parametrul to este invalid.")
+		return
+	}
+	if to == nil {
+		to = &now
+	}
+	if !from.Before(*to) {
+		WriteError(w, http.StatusBadRequest, "Intervalul este invalid.")
+		return
+	}
+	resolution := strings.ToLower(strings.TrimSpace(query.Get("resolution")))
+	switch resolution {
+	case "", services.MetricsResolutionAuto:
+		resolution = services.PickMetricsResolution(*from, *to, services.MetricsRetentionFor(s.Config), now)
+	case services.MetricsResolutionRaw, services.MetricsResolutionMinute, services.MetricsResolutionHour:
+	default:
+		WriteError(w, http.StatusBadRequest, "Rezoluția trebuie să fie raw, 1m, 1h sau auto.")
+		return
 	}
-	items, err := services.LatestMetrics(s.DB, limit)
+	items, err := services.MetricsRange(s.DB, resolution, *from, *to, instance)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
-	WriteJSON(w, http.StatusOK, MetricsHistoryResponse{Items: items})
+	WriteJSON(w, http.StatusOK, MetricsHistoryResponse{Resolution: resolution, Items: items})
 }
 
-func (s *Server) MetricsSocket(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("token")
-	if query == "" {
-		WriteError(w, http.StatusUnauthorized, "Authentication failed")
+// MetricsInstances lists the server instances that recorded samples.
+func (s *Server) MetricsInstances(w http.ResponseWriter, r *http.Request) {
+	items, err := services.ListMetricsInstances(s.DB)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
-	token, claims, err := s.Tokens.ParseToken(query)
-	if err != nil || !token.Valid || claims["typ"] != "access" {
-		WriteError(w, http.StatusUnauthorized, "Authentication failed")
+	WriteJSON(w, http.StatusOK, map[string][]services.MetricsInstanceInfo{"items": items})
+}
+
+type MetricsTicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// MetricsTicket issues a one-time ticket for opening /ws/metrics or
+// /sse/metrics from a browser, which cannot send the Authorization header
+// there.
+func (s *Server) MetricsTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, expiresAt, err := services.IssueMetricsTicket(s.DB, CurrentUserID(r))
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
-	roles := []string{}
-	if rawRoles, ok := claims["roles"].([]interface{}); ok {
-		for _, r := range rawRoles {
-			if srole, ok := r.(string); ok {
-				roles = append(roles, srole)
+	WriteJSON(w, http.StatusOK, MetricsTicketResponse{Ticket: ticket, ExpiresAt: expiresAt})
+}
+
+// authorizeMetricsStream admits admins authenticated by the Authorization
+// header or by a ?ticket= from MetricsTicket, writing the error response
+// otherwise.
+func (s *Server) authorizeMetricsStream(w http.ResponseWriter, r *http.Request) bool {
+	var roles []string
+	if ctx, ok := authenticate(s.Tokens, r); ok {
+		roles, _ = ctx.Value(ctxRoles).([]string)
+	} else if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		userID, err := services.RedeemMetricsTicket(s.DB, ticket)
+		if err != nil {
+			if !mapServiceError(w, err) {
+				WriteError(w, http.StatusInternalServerError, "Internal server error")
 			}
+			return false
+		}
+		if roles, err = services.FetchRoles(s.DB, userID); err != nil {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+			return false
 		}
+	} else {
+		WriteError(w, http.StatusUnauthorized, "Authentication failed")
+		return false
 	}
 	if !hasRole(roles, "ADMIN") {
+		WriteError(w, http.StatusForbidden, "Not allowed")
+		return false
+	}
+	return true
+}
+
+// checkMetricsOrigin accepts browsers on CORS_ORIGINS, or on the API's own
+// host when no origins are configured. Clients that send no Origin are not
+// browsers and are let through.
+func (s *Server) checkMetricsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(s.Config.CorsOrigins) == 0 {
+		parsed, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(parsed.Host, r.Host)
+	}
+	for _, allowed := range s.Config.CorsOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) MetricsSocket(w http.ResponseWriter, r *http.Request) {
+	if !s.checkMetricsOrigin(r) {
 		WriteError(w, http.StatusForbidden, "Not allowed")
 		return
 	}
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+	if !s.authorizeMetricsStream(w, r) {
+		return
 	}
+	upgrader := websocket.Upgrader{CheckOrigin: s.checkMetricsOrigin}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return
 	}
-	s.MetricsHub.Add(conn)
-	defer func() {
-		s.MetricsHub.Remove(conn)
-		_ = conn.Close()
-	}()
+	s.MetricsHub.Serve(conn)
+}
+
+const sseKeepAlive = 15 * time.Second
+
+// MetricsStream sends the same samples and alerts as MetricsSocket as
+// Server-Sent Events ("sample" and "alert" events). Since tickets are single
+// use, an EventSource that loses the connection must be reopened with a new
+// ticket rather than left to reconnect on its own.
+func (s *Server) MetricsStream(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeMetricsStream(w, r) {
+		return
+	}
+	subscription := s.MetricsHub.Subscribe()
+	if subscription == nil {
+		WriteError(w, http.StatusServiceUnavailable, "Server shutting down")
+		return
+	}
+	defer subscription.Close()
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
 	for {
-		if _, _, err := conn.ReadMessage(); err != nil {
-			break
+		var event []byte
+		select {
+		case message := <-subscription.Messages():
+			name, payload := "sample", message
+			if alert, ok := message.(services.AlertMessage); ok {
+				name, payload = "alert", alert.Alert
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				continue
+			}
+			event = []byte("event: " + name + "\ndata: " + string(data) + "\n\n")
+		case <-keepAlive.C:
+			event = []byte(": keep-alive\n\n")
+		case <-subscription.Done():
+			return
+		case <-r.Context().Done():
+			return
+		}
+		_ = controller.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := w.Write(event); err != nil {
+			return
+		}
+		if err := controller.Flush(); err != nil {
+			return
 		}
 	}
 }
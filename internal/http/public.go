@@ -50,7 +50,7 @@ func (s *Server) PublicSearch(w http.ResponseWriter, r *http.Request) {
 SELECT id, title, slug
 FROM resource_entries
 WHERE status = 'PUBLISHED'
-  AND (lower(title) LIKE $1 OR lower(summary) LIKE $1 OR tags::text ILIKE $1)
+  AND (lower(title) LIKE $1 OR lower(summary) LIKE $1 OR tags::text ILIKE $1 OR search_text ILIKE $1)
 ORDER BY published_at DESC
 LIMIT 20
 `, like); err != nil {
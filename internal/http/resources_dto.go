@@ -1,6 +1,11 @@
 package httpapi
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+
+	"fizicamd-backend-go/internal/services"
+)
 
 type CategoryDTO struct {
 	Code       string `json:"code"`
@@ -11,16 +16,17 @@ type CategoryDTO struct {
 }
 
 type ResourceCardDTO struct {
-	ID          string       `json:"id"`
-	Title       string       `json:"title"`
-	Slug        string       `json:"slug"`
-	Summary     string       `json:"summary"`
-	Category    *CategoryDTO `json:"category"`
-	AvatarURL   *string      `json:"avatarUrl"`
-	Tags        []string     `json:"tags"`
-	AuthorName  string       `json:"authorName"`
-	PublishedAt *string      `json:"publishedAt"`
-	Status      string       `json:"status"`
+	ID           string       `json:"id"`
+	Title        string       `json:"title"`
+	Slug         string       `json:"slug"`
+	Summary      string       `json:"summary"`
+	Category     *CategoryDTO `json:"category"`
+	AvatarURL    *string      `json:"avatarUrl"`
+	AvatarSrcset *string      `json:"avatarSrcset,omitempty"`
+	Tags         []string     `json:"tags"`
+	AuthorName   string       `json:"authorName"`
+	PublishedAt  *string      `json:"publishedAt"`
+	Status       string       `json:"status"`
 }
 
 type ResourceDetailDTO struct {
@@ -30,6 +36,7 @@ type ResourceDetailDTO struct {
 	Summary       string          `json:"summary"`
 	Category      *CategoryDTO    `json:"category"`
 	AvatarURL     *string         `json:"avatarUrl"`
+	AvatarSrcset  *string         `json:"avatarSrcset,omitempty"`
 	AvatarAssetID *string         `json:"avatarAssetId"`
 	Tags          []string        `json:"tags"`
 	AuthorName    string          `json:"authorName"`
@@ -44,3 +51,81 @@ type ResourceListResponse struct {
 	Page  int               `json:"page"`
 	Size  int               `json:"size"`
 }
+
+// PDFBlockPreviewDTO is added to PDF blocks as "preview" so readers can see a
+// document's size and first page before downloading it.
+type PDFBlockPreviewDTO struct {
+	PageCount    int     `json:"pageCount"`
+	Title        *string `json:"title,omitempty"`
+	Author       *string `json:"author,omitempty"`
+	ThumbnailURL *string `json:"thumbnailUrl,omitempty"`
+}
+
+// withPDFPreviews adds the stored page count, document title, author and
+// thumbnail of each referenced PDF to its block. Other blocks, and PDFs
+// without extracted information, are returned unchanged.
+func (s *Server) withPDFPreviews(blocks json.RawMessage) json.RawMessage {
+	var items []json.RawMessage
+	if err := json.Unmarshal(blocks, &items); err != nil {
+		return blocks
+	}
+	decoded := make([]map[string]json.RawMessage, len(items))
+	assetIDs := []string{}
+	for i, item := range items {
+		var block map[string]json.RawMessage
+		if err := json.Unmarshal(item, &block); err != nil {
+			continue
+		}
+		var blockType, assetID string
+		_ = json.Unmarshal(block["type"], &blockType)
+		_ = json.Unmarshal(block["assetId"], &assetID)
+		if blockType != "PDF" || assetID == "" {
+			continue
+		}
+		decoded[i] = block
+		assetIDs = append(assetIDs, assetID)
+	}
+	if len(assetIDs) == 0 {
+		return blocks
+	}
+	previews, err := services.GetPDFPreviews(s.DB, assetIDs)
+	if err != nil {
+		return blocks
+	}
+	changed := false
+	for i, block := range decoded {
+		if block == nil {
+			continue
+		}
+		var assetID string
+		_ = json.Unmarshal(block["assetId"], &assetID)
+		preview, ok := previews[assetID]
+		if !ok {
+			continue
+		}
+		dto := PDFBlockPreviewDTO{PageCount: preview.PageCount, Title: preview.Title, Author: preview.Author}
+		if preview.ThumbnailWidth != nil {
+			url := fmt.Sprintf("%s&w=%d", s.Signer.SignedURL(assetID), *preview.ThumbnailWidth)
+			dto.ThumbnailURL = &url
+		}
+		encoded, err := json.Marshal(dto)
+		if err != nil {
+			continue
+		}
+		block["preview"] = encoded
+		updated, err := json.Marshal(block)
+		if err != nil {
+			continue
+		}
+		items[i] = updated
+		changed = true
+	}
+	if !changed {
+		return blocks
+	}
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		return blocks
+	}
+	return encoded
+}
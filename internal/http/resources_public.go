@@ -81,6 +81,11 @@ LIMIT $%d OFFSET $%d`
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	avatarIDs := make([]*string, 0, len(rows))
+	for _, row := range rows {
+		avatarIDs = append(avatarIDs, row.AvatarID)
+	}
+	variantWidths := assetVariantWidths(s.DB, avatarIDs)
 	items := make([]ResourceCardDTO, 0, len(rows))
 	for _, row := range rows {
 		categoryDTO := s.fetchCategory(row.Category)
@@ -98,16 +103,17 @@ LIMIT $%d OFFSET $%d`
 		}
 		author := s.authorDisplayName(row.AuthorID)
 		items = append(items, ResourceCardDTO{
-			ID:          row.ID,
-			Title:       row.Title,
-			Slug:        row.Slug,
-			Summary:     row.Summary,
-			Category:    categoryDTO,
-			AvatarURL:   avatarURL,
-			Tags:        tags,
-			AuthorName:  author,
-			PublishedAt: published,
-			Status:      row.Status,
+			ID:           row.ID,
+			Title:        row.Title,
+			Slug:         row.Slug,
+			Summary:      row.Summary,
+			Category:     categoryDTO,
+			AvatarURL:    avatarURL,
+			AvatarSrcset: srcsetFor(avatarURL, variantWidths[ptrToString(row.AvatarID)]),
+			Tags:         tags,
+			AuthorName:   author,
+			PublishedAt:  published,
+			Status:       row.Status,
 		})
 	}
 	WriteJSON(w, http.StatusOK, ResourceListResponse{Items: items, Total: total, Page: page, Size: limit})
@@ -161,11 +167,12 @@ WHERE slug = $1
 		Summary:       row.Summary,
 		Category:      categoryDTO,
 		AvatarURL:     avatarURL,
+		AvatarSrcset:  assetSrcset(s.DB, row.AvatarID, avatarURL),
 		AvatarAssetID: row.AvatarID,
 		Tags:          tags,
 		AuthorName:    author,
 		PublishedAt:   published,
 		Status:        row.Status,
-		Blocks:        json.RawMessage(row.Content),
+		Blocks:        s.withPDFPreviews(services.HydrateBlocks(row.Content)),
 	})
 }
@@ -51,16 +51,17 @@ FROM resource_entries
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	avatarIDs := make([]*string, 0, len(rows))
+	for _, row := range rows {
+		avatarIDs = append(avatarIDs, row.AvatarID)
+	}
+	variantWidths := assetVariantWidths(s.DB, avatarIDs)
 	items := make([]ResourceCardDTO, 0, len(rows))
 	for _, row := range rows {
 		categoryDTO := s.fetchCategory(row.Category)
 		tags := []string{}
 		_ = json.Unmarshal(row.Tags, &tags)
-		var avatarURL *string
-		if row.AvatarID != nil {
-			url := services.BuildAssetURL(*row.AvatarID)
-			avatarURL = &url
-		}
+		avatarURL := s.signedAssetURL(row.AvatarID)
 		var published *string
 		if row.Published != nil {
 			formatted := row.Published.UTC().Format(time.RFC3339)
@@ -68,16 +69,17 @@ FROM resource_entries
 		}
 		author := s.authorDisplayName(row.AuthorID)
 		items = append(items, ResourceCardDTO{
-			ID:          row.ID,
-			Title:       row.Title,
-			Slug:        row.Slug,
-			Summary:     row.Summary,
-			Category:    categoryDTO,
-			AvatarURL:   avatarURL,
-			Tags:        tags,
-			AuthorName:  author,
-			PublishedAt: published,
-			Status:      row.Status,
+			ID:           row.ID,
+			Title:        row.Title,
+			Slug:         row.Slug,
+			Summary:      row.Summary,
+			Category:     categoryDTO,
+			AvatarURL:    avatarURL,
+			AvatarSrcset: srcsetFor(avatarURL, variantWidths[ptrToString(row.AvatarID)]),
+			Tags:         tags,
+			AuthorName:   author,
+			PublishedAt:  published,
+			Status:       row.Status,
 		})
 	}
 	WriteJSON(w, http.StatusOK, map[string][]ResourceCardDTO{"items": items})
@@ -109,11 +111,7 @@ WHERE id = $1
 	categoryDTO := s.fetchCategory(row.Category)
 	tags := []string{}
 	_ = json.Unmarshal(row.Tags, &tags)
-	var avatarURL *string
-	if row.AvatarID != nil {
-		url := services.BuildAssetURL(*row.AvatarID)
-		avatarURL = &url
-	}
+	avatarURL := s.signedAssetURL(row.AvatarID)
 	var published *string
 	if row.Published != nil {
 		formatted := row.Published.UTC().Format(time.RFC3339)
@@ -127,12 +125,13 @@ WHERE id = $1
 		Summary:       row.Summary,
 		Category:      categoryDTO,
 		AvatarURL:     avatarURL,
+		AvatarSrcset:  assetSrcset(s.DB, row.AvatarID, avatarURL),
 		AvatarAssetID: row.AvatarID,
 		Tags:          tags,
 		AuthorName:    author,
 		PublishedAt:   published,
 		Status:        row.Status,
-		Blocks:        json.RawMessage(row.Content),
+		Blocks:        s.withPDFPreviews(services.HydrateBlocks(row.Content)),
 	})
 }
 
@@ -161,7 +160,17 @@ func (s *Server) CreateResource(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	resourceID := uuid.NewString()
+	req.AvatarID = nullIfEmpty(strings.TrimSpace(ptrToString(req.AvatarID)))
+	assetRefs := services.BlockAssetRefs(blocks)
+	if err := services.ValidateResourceAssets(s.DB, userID, resourceID, assetRefs, req.AvatarID); err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
 	blockJSON, _ := json.Marshal(blocks)
+	searchText := services.BlockSearchText(blocks)
 	tags := services.CleanTags(req.Tags)
 	tagsJSON, _ := json.Marshal(tags)
 	status := req.Status
@@ -169,7 +178,6 @@ func (s *Server) CreateResource(w http.ResponseWriter, r *http.Request) {
 		status = "PUBLISHED"
 	}
 	now := time.Now().UTC()
-	resourceID := uuid.NewString()
 	slug, err := services.ResolveResourceSlug(s.DB, title)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
@@ -179,14 +187,29 @@ func (s *Server) CreateResource(w http.ResponseWriter, r *http.Request) {
 	if status == "PUBLISHED" {
 		publishedAt = &now
 	}
-	_, err = s.DB.Exec(`
-INSERT INTO resource_entries (id, category_code, author_id, title, slug, summary, avatar_media_id, tags, content, status, published_at, created_at, updated_at)
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$12)
-`, resourceID, categoryCode, userID, title, slug, summary, req.AvatarID, tagsJSON, blockJSON, status, publishedAt, now)
+	tx, err := s.DB.Beginx()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+INSERT INTO resource_entries (id, category_code, author_id, title, slug, summary, avatar_media_id, tags, content, search_text, status, published_at, created_at, updated_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$13)
+`, resourceID, categoryCode, userID, title, slug, summary, req.AvatarID, tagsJSON, blockJSON, searchText, status, publishedAt, now)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	if err := services.SyncResourceAssetRefs(tx, resourceID, assetRefs, req.AvatarID); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	s.refreshResourceAssetAccess(resourceID, nil)
 	row := struct {
 		Published *time.Time `db:"published_at"`
 	}{}
@@ -198,11 +221,7 @@ VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$12)
 	}
 	categoryDTO := s.fetchCategory(categoryCode)
 	author := s.authorDisplayName(userID)
-	var avatarURL *string
-	if req.AvatarID != nil {
-		url := services.BuildAssetURL(*req.AvatarID)
-		avatarURL = &url
-	}
+	avatarURL := s.signedAssetURL(req.AvatarID)
 	WriteJSON(w, http.StatusOK, ResourceDetailDTO{
 		ID:            resourceID,
 		Title:         title,
@@ -210,12 +229,13 @@ VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$12)
 		Summary:       summary,
 		Category:      categoryDTO,
 		AvatarURL:     avatarURL,
+		AvatarSrcset:  assetSrcset(s.DB, req.AvatarID, avatarURL),
 		AvatarAssetID: req.AvatarID,
 		Tags:          tags,
 		AuthorName:    author,
 		PublishedAt:   published,
 		Status:        status,
-		Blocks:        blockJSON,
+		Blocks:        s.withPDFPreviews(blockJSON),
 	})
 }
 
@@ -260,7 +280,16 @@ func (s *Server) UpdateResource(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	req.AvatarID = nullIfEmpty(strings.TrimSpace(ptrToString(req.AvatarID)))
+	assetRefs := services.BlockAssetRefs(blocks)
+	if err := services.ValidateResourceAssets(s.DB, row.AuthorID, resourceID, assetRefs, req.AvatarID); err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
 	blockJSON, _ := json.Marshal(blocks)
+	searchText := services.BlockSearchText(blocks)
 	tags := services.CleanTags(req.Tags)
 	tagsJSON, _ := json.Marshal(tags)
 	status := req.Status
@@ -276,15 +305,31 @@ func (s *Server) UpdateResource(w http.ResponseWriter, r *http.Request) {
 	} else {
 		publishedAt = nil
 	}
-	_, err = s.DB.Exec(`
+	previousAssets, _ := services.ResourceAssetIDs(s.DB, resourceID)
+	tx, err := s.DB.Beginx()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
 UPDATE resource_entries
-SET category_code = $2, title = $3, summary = $4, avatar_media_id = $5, tags = $6, content = $7, status = $8, published_at = $9, updated_at = $10
+SET category_code = $2, title = $3, summary = $4, avatar_media_id = $5, tags = $6, content = $7, search_text = $8, status = $9, published_at = $10, updated_at = $11
 WHERE id = $1
-`, resourceID, categoryCode, title, summary, req.AvatarID, tagsJSON, blockJSON, status, publishedAt, now)
+`, resourceID, categoryCode, title, summary, req.AvatarID, tagsJSON, blockJSON, searchText, status, publishedAt, now)
 	if err != nil {
 		WriteError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
+	if err := services.SyncResourceAssetRefs(tx, resourceID, assetRefs, req.AvatarID); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	s.refreshResourceAssetAccess(resourceID, previousAssets)
 	categoryDTO := s.fetchCategory(categoryCode)
 	author := s.authorDisplayName(userID)
 	var published *string
@@ -292,11 +337,7 @@ WHERE id = $1
 		formatted := publishedAt.UTC().Format(time.RFC3339)
 		published = &formatted
 	}
-	var avatarURL *string
-	if req.AvatarID != nil {
-		url := services.BuildAssetURL(*req.AvatarID)
-		avatarURL = &url
-	}
+	avatarURL := s.signedAssetURL(req.AvatarID)
 	WriteJSON(w, http.StatusOK, ResourceDetailDTO{
 		ID:            resourceID,
 		Title:         title,
@@ -304,12 +345,13 @@ WHERE id = $1
 		Summary:       summary,
 		Category:      categoryDTO,
 		AvatarURL:     avatarURL,
+		AvatarSrcset:  assetSrcset(s.DB, req.AvatarID, avatarURL),
 		AvatarAssetID: req.AvatarID,
 		Tags:          tags,
 		AuthorName:    author,
 		PublishedAt:   published,
 		Status:        status,
-		Blocks:        blockJSON,
+		Blocks:        s.withPDFPreviews(blockJSON),
 	})
 }
 
@@ -328,10 +370,22 @@ func (s *Server) DeleteResource(w http.ResponseWriter, r *http.Request) {
 		WriteError(w, http.StatusNotFound, "Resursa nu a fost găsită.")
 		return
 	}
+	previousAssets, _ := services.ResourceAssetIDs(s.DB, resourceID)
 	_, _ = s.DB.Exec(`DELETE FROM resource_entries WHERE id = $1`, resourceID)
+	_ = services.RefreshAssetAccess(s.DB, previousAssets)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// refreshResourceAssetAccess recomputes the access policy of the assets the
+// resource uses now and of those it used before the write.
+func (s *Server) refreshResourceAssetAccess(resourceID string, previous []string) {
+	current, err := services.ResourceAssetIDs(s.DB, resourceID)
+	if err != nil {
+		return
+	}
+	_ = services.RefreshAssetAccess(s.DB, append(previous, current...))
+}
+
 func hasRole(roles []string, role string) bool {
 	role = strings.ToUpper(role)
 	for _, r := range roles {
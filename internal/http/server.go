@@ -18,9 +18,13 @@ type Server struct {
 	Config     config.Config
 	Tokens     services.TokenService
 	MetricsHub *services.MetricsHub
+	Storage    services.ObjectStorage
+	Signer     services.AssetSigner
+	Scanner    services.Scanner
+	Telemetry  *Telemetry
 }
 
-func NewServer(db *sqlx.DB, cfg config.Config, hub *services.MetricsHub) *Server {
+func NewServer(db *sqlx.DB, cfg config.Config, hub *services.MetricsHub, store services.ObjectStorage, scanner services.Scanner) *Server {
 	tokens := services.TokenService{
 		Secret:     []byte(cfg.JWTSecret),
 		Issuer:     cfg.JWTIssuer,
@@ -32,17 +36,32 @@ func NewServer(db *sqlx.DB, cfg config.Config, hub *services.MetricsHub) *Server
 		Config:     cfg,
 		Tokens:     tokens,
 		MetricsHub: hub,
+		Storage:    store,
+		Scanner:    scanner,
+		Telemetry:  NewTelemetry(db, hub),
+		Signer: services.AssetSigner{
+			Secret: []byte(cfg.MediaSigningSecret),
+			TTL:    time.Duration(cfg.MediaURLTTLSeconds) * time.Second,
+		},
 	}
 }
 
 func (s *Server) Router(ctx context.Context) http.Handler {
 	r := chi.NewRouter()
 	r.Use(RequestLogger)
+	r.Use(s.Telemetry.Instrument)
 	if len(s.Config.CorsOrigins) > 0 {
 		r.Use(cors.Handler(cors.Options{
-			AllowedOrigins:   s.Config.CorsOrigins,
-			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+			AllowedOrigins: s.Config.CorsOrigins,
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			AllowedHeaders: []string{
+				"Accept", "Authorization", "Content-Type",
+				"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Checksum",
+			},
+			ExposedHeaders: []string{
+				"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Tus-Checksum-Algorithm",
+				"Upload-Offset", "Upload-Length", "Upload-Expires", "Upload-Asset-Id", "Upload-Asset-Url", "Upload-Asset-Status",
+			},
 			AllowCredentials: false,
 			MaxAge:           300,
 		}))
@@ -61,6 +80,7 @@ func (s *Server) Router(ctx context.Context) http.Handler {
 			me.Put("/profile", s.UpdateProfile)
 			me.Delete("/", s.DeleteAccount)
 			me.Put("/password", s.ChangePassword)
+			me.Get("/storage", s.MyStorage)
 			me.Post("/ping", s.Ping)
 		})
 
@@ -68,6 +88,12 @@ func (s *Server) Router(ctx context.Context) http.Handler {
 			admin.Use(WithAuth(s.Tokens))
 			admin.Use(RequireRole("ADMIN"))
 			admin.Get("/metrics/history", s.MetricsHistory)
+			admin.Get("/metrics/instances", s.MetricsInstances)
+			admin.Post("/metrics/ticket", s.MetricsTicket)
+			admin.Get("/media/orphans", s.AdminOrphanedMedia)
+			admin.Post("/media/gc", s.AdminRunMediaGC)
+			admin.Get("/media/dedup", s.AdminMediaDedup)
+			admin.Get("/media/storage", s.AdminStorageConsumers)
 			admin.Route("/users", func(users chi.Router) {
 				users.Get("/", s.ListUsers)
 				users.Post("/", s.CreateUser)
@@ -84,11 +110,25 @@ func (s *Server) Router(ctx context.Context) http.Handler {
 				groups.Delete("/{groupId}/members/{userId}", s.AdminRemoveMember)
 				groups.Get("/{groupId}", s.AdminGetGroup)
 			})
+			admin.Route("/analytics", func(analytics chi.Router) {
+				analytics.Get("/visits", s.AdminAnalyticsVisits)
+				analytics.Get("/paths", s.AdminAnalyticsPaths)
+				analytics.Get("/referrers", s.AdminAnalyticsReferrers)
+				analytics.Get("/devices", s.AdminAnalyticsDevices)
+			})
+			admin.Route("/alerts", func(alerts chi.Router) {
+				alerts.Get("/", s.AdminListAlerts)
+				alerts.Get("/rules", s.AdminListAlertRules)
+				alerts.Post("/rules", s.AdminCreateAlertRule)
+				alerts.Put("/rules/{ruleId}", s.AdminUpdateAlertRule)
+				alerts.Delete("/rules/{ruleId}", s.AdminDeleteAlertRule)
+			})
 		})
 
 		api.Route("/teacher", func(teacher chi.Router) {
 			teacher.Use(WithAuth(s.Tokens))
 			teacher.Use(RequireAnyRole("TEACHER", "ADMIN"))
+			teacher.Get("/block-types", s.ListBlockTypes)
 
 			teacher.Route("/resources", func(resources chi.Router) {
 				resources.Get("/", s.TeacherListResources)
@@ -98,6 +138,14 @@ func (s *Server) Router(ctx context.Context) http.Handler {
 				resources.Delete("/{resourceId}", s.DeleteResource)
 			})
 
+			teacher.Route("/media", func(media chi.Router) {
+				media.Get("/", s.TeacherListMedia)
+				media.Get("/{assetId}", s.TeacherGetMedia)
+				media.Put("/{assetId}", s.TeacherUpdateMedia)
+				media.Delete("/{assetId}", s.TeacherDeleteMedia)
+				media.Get("/{assetId}/usages", s.TeacherMediaUsages)
+			})
+
 			teacher.Route("/resource-categories", func(categories chi.Router) {
 				categories.Get("/", s.ListCategories)
 				categories.Post("/", s.CreateCategory)
@@ -135,15 +183,35 @@ func (s *Server) Router(ctx context.Context) http.Handler {
 		})
 
 		api.Route("/media", func(media chi.Router) {
-			media.Get("/assets/{assetId}/content", s.MediaContent)
+			media.With(OptionalAuth(s.Tokens)).Get("/assets/{assetId}/content", s.MediaContent)
+			media.With(OptionalAuth(s.Tokens)).Head("/assets/{assetId}/content", s.MediaContent)
+			media.With(TusResumable).Options("/uploads", s.TusOptions)
+			media.Group(func(resumable chi.Router) {
+				resumable.Use(TusResumable)
+				resumable.Use(WithAuth(s.Tokens))
+				resumable.Use(RequireAnyRole("TEACHER", "ADMIN"))
+				resumable.Post("/uploads", s.CreateUpload)
+				resumable.Head("/uploads/{uploadId}", s.UploadOffset)
+				resumable.Patch("/uploads/{uploadId}", s.PatchUpload)
+				resumable.Delete("/uploads/{uploadId}", s.DeleteUpload)
+			})
 			media.Group(func(secured chi.Router) {
 				secured.Use(WithAuth(s.Tokens))
+				secured.Get("/assets/{assetId}/url", s.MediaSignedURL)
+				secured.Put("/assets/{assetId}/access", s.UpdateMediaAccess)
 				secured.Post("/uploads/avatar", s.UploadAvatar)
 				secured.With(RequireAnyRole("TEACHER", "ADMIN")).Post("/uploads/resource", s.UploadResource)
+				secured.With(RequireAnyRole("TEACHER", "ADMIN")).Get("/uploads/{uploadId}", s.UploadStatus)
 			})
 		})
 	})
 
 	r.Get("/ws/metrics", s.MetricsSocket)
+	r.Get("/sse/metrics", s.MetricsStream)
+	// Without a dedicated listener, /metrics is only exposed when a scrape
+	// token protects it.
+	if s.Config.MetricsListenAddr == "" && s.Config.MetricsToken != "" {
+		r.Method(http.MethodGet, "/metrics", s.Telemetry.Handler(s.Config.MetricsToken))
+	}
 	return r
 }
@@ -0,0 +1,236 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type MediaLibraryItemDTO struct {
+	ID           string  `json:"id"`
+	Filename     *string `json:"filename"`
+	Description  *string `json:"description"`
+	Type         string  `json:"type"`
+	ContentType  string  `json:"contentType"`
+	SizeBytes    int64   `json:"sizeBytes"`
+	AccessPolicy string  `json:"accessPolicy"`
+	Status       string  `json:"status"`
+	Retained     bool    `json:"retained"`
+	UsageCount   int     `json:"usageCount"`
+	URL          string  `json:"url"`
+	Srcset       *string `json:"srcset,omitempty"`
+	CreatedAt    string  `json:"createdAt"`
+	UpdatedAt    string  `json:"updatedAt"`
+}
+
+type MediaLibraryResponse struct {
+	Items    []MediaLibraryItemDTO `json:"items"`
+	Total    int                   `json:"total"`
+	Page     int                   `json:"page"`
+	PageSize int                   `json:"pageSize"`
+}
+
+type MediaDetailsRequest struct {
+	Filename    *string `json:"filename"`
+	Description *string `json:"description"`
+	Retained    *bool   `json:"retained"`
+}
+
+// TeacherListMedia lists the caller's uploads. Filters: type, search (in the
+// filename), from/to (YYYY-MM-DD or RFC 3339), unused=true for assets no
+// resource or profile uses and retained=true|false. unused=true&retained=false
+// selects the assets the media GC may delete.
+func (s *Server) TeacherListMedia(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page := parseInt(query.Get("page"), 1)
+	pageSize := parseInt(query.Get("pageSize"), 24)
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	from, err := parseDateParam(query.Get("from"), false)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Data de început este invalidă.")
+		return
+	}
+	to, err := parseDateParam(query.Get("to"), true)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Data de sfârșit este invalidă.")
+		return
+	}
+	var retained *bool
+	if value, err := strconv.ParseBool(query.Get("retained")); err == nil {
+		retained = &value
+	}
+	items, total, err := services.ListMediaLibrary(s.DB, services.MediaLibraryFilter{
+		OwnerID:  CurrentUserID(r),
+		Type:     strings.TrimSpace(query.Get("type")),
+		Search:   query.Get("search"),
+		From:     from,
+		To:       to,
+		Unused:   query.Get("unused") == "true",
+		Retained: retained,
+		Limit:    pageSize,
+		Offset:   (page - 1) * pageSize,
+	})
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	ids := make([]*string, 0, len(items))
+	for i := range items {
+		ids = append(ids, &items[i].ID)
+	}
+	variantWidths := assetVariantWidths(s.DB, ids)
+	dtos := make([]MediaLibraryItemDTO, 0, len(items))
+	for _, item := range items {
+		dtos = append(dtos, s.mediaLibraryItemDTO(item, variantWidths[item.ID]))
+	}
+	WriteJSON(w, http.StatusOK, MediaLibraryResponse{Items: dtos, Total: total, Page: page, PageSize: pageSize})
+}
+
+func (s *Server) TeacherGetMedia(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.managedMediaItem(w, r)
+	if !ok {
+		return
+	}
+	WriteJSON(w, http.StatusOK, s.mediaLibraryItemDTO(item, s.variantWidths(item.ID)))
+}
+
+func (s *Server) TeacherUpdateMedia(w http.ResponseWriter, r *http.Request) {
+	var req MediaDetailsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid payload")
+		return
+	}
+	item, ok := s.managedMediaItem(w, r)
+	if !ok {
+		return
+	}
+	if err := services.UpdateMediaDetails(s.DB, item.ID, req.Filename, req.Description, req.Retained); err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	updated, err := services.GetMediaLibraryItem(s.DB, item.ID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, s.mediaLibraryItemDTO(updated, s.variantWidths(updated.ID)))
+}
+
+// TeacherMediaUsages lists the resources that embed an asset.
+func (s *Server) TeacherMediaUsages(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.managedMediaItem(w, r)
+	if !ok {
+		return
+	}
+	usages, err := services.AssetUsages(s.DB, item.ID)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string][]services.AssetUsage{"items": usages})
+}
+
+// TeacherDeleteMedia deletes an unused asset. Assets still in use are kept
+// and the 409 response lists where they are used.
+func (s *Server) TeacherDeleteMedia(w http.ResponseWriter, r *http.Request) {
+	item, ok := s.managedMediaItem(w, r)
+	if !ok {
+		return
+	}
+	err := services.DeleteUnusedAsset(s.DB, s.Storage, item.ID)
+	if errors.Is(err, services.ErrAssetInUse) {
+		usages, _ := services.AssetUsages(s.DB, item.ID)
+		WriteJSON(w, http.StatusConflict, map[string]interface{}{
+			"message": services.ErrAssetInUse.Message,
+			"usages":  usages,
+		})
+		return
+	}
+	if err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// managedMediaItem loads the {assetId} asset if the caller owns it or is an
+// admin, writing the error response otherwise.
+func (s *Server) managedMediaItem(w http.ResponseWriter, r *http.Request) (services.MediaLibraryItem, bool) {
+	item, err := services.GetMediaLibraryItem(s.DB, chi.URLParam(r, "assetId"))
+	if err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return item, false
+	}
+	owned := item.OwnerID != nil && *item.OwnerID == CurrentUserID(r)
+	if !owned && !hasRole(CurrentRoles(r), "ADMIN") {
+		WriteError(w, http.StatusNotFound, "Fișierul nu a fost găsit.")
+		return item, false
+	}
+	return item, true
+}
+
+func (s *Server) variantWidths(assetID string) []int {
+	widths, _ := services.ImageVariantWidthsFor(s.DB, assetID)
+	return widths
+}
+
+// mediaLibraryItemDTO builds the DTO of item; widths are its image variant
+// widths, loaded by the caller so lists need one query for all items.
+func (s *Server) mediaLibraryItemDTO(item services.MediaLibraryItem, widths []int) MediaLibraryItemDTO {
+	url := s.Signer.SignedURL(item.ID)
+	var srcset *string
+	if strings.HasPrefix(item.ContentType, "image/") {
+		srcset = srcsetFor(&url, widths)
+	}
+	return MediaLibraryItemDTO{
+		ID:           item.ID,
+		Filename:     item.Filename,
+		Description:  item.Description,
+		Type:         item.Type,
+		ContentType:  item.ContentType,
+		SizeBytes:    item.SizeBytes,
+		AccessPolicy: item.AccessPolicy,
+		Status:       item.Status,
+		Retained:     item.Retained,
+		UsageCount:   item.UsageCount,
+		URL:          url,
+		Srcset:       srcset,
+		CreatedAt:    item.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:    item.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// parseDateParam accepts YYYY-MM-DD or RFC 3339. A bare date used as an upper
+// bound covers the whole day.
+func parseDateParam(raw string, endOfDay bool) (*time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	if day, err := time.Parse("2006-01-02", raw); err == nil {
+		if endOfDay {
+			day = day.AddDate(0, 0, 1)
+		}
+		return &day, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
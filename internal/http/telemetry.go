@@ -0,0 +1,135 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "fizicamd"
+
+// Telemetry holds the Prometheus registry exposed at /metrics: per-route
+// HTTP metrics, the database pool, the Go runtime and the host gauges
+// sampled by the metrics loop.
+type Telemetry struct {
+	Registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func NewTelemetry(db *sqlx.DB, hub *services.MetricsHub) *Telemetry {
+	registry := prometheus.NewRegistry()
+	t := &Telemetry{
+		Registry: registry,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+	registry.MustRegister(
+		t.requests,
+		t.duration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(db.DB, "postgres"),
+		sampleCollector{hub: hub},
+	)
+	return t
+}
+
+// Instrument records every request under its chi route pattern (e.g.
+// /api/teacher/media/{assetId}) so IDs in paths do not create new series.
+func (t *Telemetry) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		t.requests.WithLabelValues(r.Method, route, strconv.Itoa(recorder.status)).Inc()
+		t.duration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Handler serves the registry in the Prometheus text format. When token is
+// set, scrapers must send it as a bearer token.
+func (t *Telemetry) Handler(token string) http.Handler {
+	metrics := promhttp.HandlerFor(t.Registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return metrics
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			WriteError(w, http.StatusUnauthorized, "Authentication failed")
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
+
+var (
+	processRSSDesc  = prometheus.NewDesc(metricsNamespace+"_process_rss_bytes", "Resident memory of the server process.", nil, nil)
+	processCPUDesc  = prometheus.NewDesc(metricsNamespace+"_process_cpu_load", "CPU load of the server process (0-1 per core).", nil, nil)
+	systemCPUDesc   = prometheus.NewDesc(metricsNamespace+"_system_cpu_load", "CPU load of the host (0-1).", nil, nil)
+	memoryTotalDesc = prometheus.NewDesc(metricsNamespace+"_system_memory_total_bytes", "Total memory of the host.", nil, nil)
+	memoryUsedDesc  = prometheus.NewDesc(metricsNamespace+"_system_memory_used_bytes", "Used memory of the host.", nil, nil)
+	diskTotalDesc   = prometheus.NewDesc(metricsNamespace+"_disk_total_bytes", "Size of the media disk.", nil, nil)
+	diskUsedDesc    = prometheus.NewDesc(metricsNamespace+"_disk_used_bytes", "Used space on the media disk.", nil, nil)
+	sampleTimeDesc  = prometheus.NewDesc(metricsNamespace+"_metrics_sample_timestamp_seconds", "Time of the latest host sample.", nil, nil)
+	sampleDescs     = []*prometheus.Desc{processRSSDesc, processCPUDesc, systemCPUDesc, memoryTotalDesc, memoryUsedDesc, diskTotalDesc, diskUsedDesc, sampleTimeDesc}
+)
+
+// sampleCollector exposes the latest sample taken by the metrics loop rather
+// than measuring again on every scrape.
+type sampleCollector struct {
+	hub *services.MetricsHub
+}
+
+func (c sampleCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range sampleDescs {
+		ch <- desc
+	}
+}
+
+func (c sampleCollector) Collect(ch chan<- prometheus.Metric) {
+	sample, ok := c.hub.Latest()
+	if !ok {
+		return
+	}
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	gauge(processRSSDesc, float64(sample.HeapUsedBytes))
+	gauge(processCPUDesc, sample.ProcessCpuLoad)
+	gauge(systemCPUDesc, sample.SystemCpuLoad)
+	gauge(memoryTotalDesc, float64(sample.SystemMemoryTotal))
+	gauge(memoryUsedDesc, float64(sample.SystemMemoryUsed))
+	gauge(diskTotalDesc, float64(sample.DiskTotalBytes))
+	gauge(diskUsedDesc, float64(sample.DiskUsedBytes))
+	gauge(sampleTimeDesc, float64(sample.CapturedAt.UnixNano())/1e9)
+}
@@ -0,0 +1,205 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"fizicamd-backend-go/internal/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Resumable uploads follow the tus 1.0.0 protocol with the creation,
+// checksum, expiration and termination extensions.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,checksum,expiration,termination"
+)
+
+type UploadStatusDTO struct {
+	ID        string  `json:"id"`
+	Offset    int64   `json:"offset"`
+	Length    int64   `json:"length"`
+	ExpiresAt string  `json:"expiresAt"`
+	AssetID   *string `json:"assetId"`
+	URL       *string `json:"url"`
+}
+
+// TusResumable rejects requests for another protocol version and tags every
+// response with the supported one. OPTIONS requests are exempt.
+func TusResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			WriteError(w, http.StatusPreconditionFailed, "Versiune tus nesuportată.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) TusOptions(w http.ResponseWriter, r *http.Request) {
+	algorithms := make([]string, 0, len(services.UploadChecksumAlgorithms))
+	for name := range services.UploadChecksumAlgorithms {
+		algorithms = append(algorithms, name)
+	}
+	sort.Strings(algorithms)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(services.ResourceUploadPolicy(s.Config).MaxBytes(), 10))
+	w.Header().Set("Tus-Checksum-Algorithm", strings.Join(algorithms, ","))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "Antetul Upload-Length lipsește sau este invalid.")
+		return
+	}
+	metadata := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	policy, ok := s.resumablePolicy(w, CurrentUserID(r))
+	if !ok {
+		return
+	}
+	upload, err := services.CreateUpload(s.DB, s.Config.MediaUploadPath, policy, CurrentUserID(r),
+		metadata["filename"], metadata["filetype"], length, time.Duration(s.Config.MediaUploadTTLHours)*time.Hour)
+	if err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	w.Header().Set("Location", "/api/media/uploads/"+upload.ID)
+	w.Header().Set("Upload-Offset", "0")
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) UploadOffset(w http.ResponseWriter, r *http.Request) {
+	upload, err := services.GetUpload(s.DB, chi.URLParam(r, "uploadId"), CurrentUserID(r))
+	if err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	writeUploadHeaders(w, upload)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	upload, err := services.GetUpload(s.DB, chi.URLParam(r, "uploadId"), CurrentUserID(r))
+	if err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJSON(w, http.StatusOK, UploadStatusDTO{
+		ID:        upload.ID,
+		Offset:    upload.Offset,
+		Length:    upload.Length,
+		ExpiresAt: upload.ExpiresAt.UTC().Format(time.RFC3339),
+		AssetID:   upload.AssetID,
+		URL:       s.signedAssetURL(upload.AssetID),
+	})
+}
+
+func (s *Server) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		WriteError(w, http.StatusUnsupportedMediaType, "Content-Type trebuie să fie application/offset+octet-stream.")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		WriteError(w, http.StatusBadRequest, "Antetul Upload-Offset lipsește sau este invalid.")
+		return
+	}
+	algorithm, digest := "", []byte(nil)
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		if algorithm, digest, err = services.ParseUploadChecksum(header); err != nil {
+			mapServiceError(w, err)
+			return
+		}
+	}
+	policy, ok := s.resumablePolicy(w, CurrentUserID(r))
+	if !ok {
+		return
+	}
+	upload, err := services.AppendUploadChunk(s.DB, s.Storage, s.Config.MediaUploadPath, policy,
+		chi.URLParam(r, "uploadId"), CurrentUserID(r), offset, r.Body, algorithm, digest)
+	if err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	writeUploadHeaders(w, upload)
+	if upload.AssetID != nil {
+		status := s.scanUpload(*upload.AssetID)
+		switch status {
+		case services.MediaStatusQuarantined:
+			WriteError(w, http.StatusUnprocessableEntity, quarantinedMessage)
+			return
+		case services.MediaStatusScanFailed:
+			WriteError(w, http.StatusUnprocessableEntity, scanFailedMessage)
+			return
+		}
+		w.Header().Set("Upload-Asset-Id", *upload.AssetID)
+		w.Header().Set("Upload-Asset-Url", s.Signer.SignedURL(*upload.AssetID))
+		w.Header().Set("Upload-Asset-Status", status)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if err := services.DeleteUpload(s.DB, s.Config.MediaUploadPath, chi.URLParam(r, "uploadId"), CurrentUserID(r)); err != nil {
+		if !mapServiceError(w, err) {
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resumablePolicy is the resource upload policy bound to the caller's quota.
+func (s *Server) resumablePolicy(w http.ResponseWriter, userID string) (services.UploadPolicy, bool) {
+	policy := services.ResourceUploadPolicy(s.Config)
+	quota, ok := s.uploadQuota(w, userID, nil)
+	if !ok {
+		return policy, false
+	}
+	policy.Quota = quota
+	return policy, true
+}
+
+func writeUploadHeaders(w http.ResponseWriter, upload services.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2".
+func parseUploadMetadata(header string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			continue
+		}
+		values[key] = string(decoded)
+	}
+	return values
+}
@@ -9,15 +9,16 @@ import (
 )
 
 type ProfileDTO struct {
-	FirstName  *string `json:"firstName,omitempty"`
-	LastName   *string `json:"lastName,omitempty"`
-	BirthDate  *string `json:"birthDate,omitempty"`
-	Gender     *string `json:"gender,omitempty"`
-	Phone      *string `json:"phone,omitempty"`
-	School     *string `json:"school,omitempty"`
-	GradeLevel *string `json:"gradeLevel,omitempty"`
-	Bio        *string `json:"bio,omitempty"`
-	AvatarURL  *string `json:"avatarUrl,omitempty"`
+	FirstName    *string `json:"firstName,omitempty"`
+	LastName     *string `json:"lastName,omitempty"`
+	BirthDate    *string `json:"birthDate,omitempty"`
+	Gender       *string `json:"gender,omitempty"`
+	Phone        *string `json:"phone,omitempty"`
+	School       *string `json:"school,omitempty"`
+	GradeLevel   *string `json:"gradeLevel,omitempty"`
+	Bio          *string `json:"bio,omitempty"`
+	AvatarURL    *string `json:"avatarUrl,omitempty"`
+	AvatarSrcset *string `json:"avatarSrcset,omitempty"`
 }
 
 type UserDTO struct {
@@ -30,7 +31,7 @@ type UserDTO struct {
 	LastLoginAt *time.Time  `json:"lastLoginAt,omitempty"`
 }
 
-func buildUserDTO(db *sqlx.DB, userID string) (*UserDTO, error) {
+func buildUserDTO(db *sqlx.DB, signer services.AssetSigner, userID string) (*UserDTO, error) {
 	row := struct {
 		ID         string     `db:"id"`
 		Email      string     `db:"email"`
@@ -75,21 +76,22 @@ ORDER BY r.code
 	}
 	var avatarURL *string
 	if row.AvatarID != nil {
-		url := services.BuildAssetURL(*row.AvatarID)
+		url := signer.SignedURL(*row.AvatarID)
 		avatarURL = &url
 	}
 	profile := (*ProfileDTO)(nil)
 	if row.FirstName != nil || row.LastName != nil || row.Phone != nil || row.School != nil || row.GradeLevel != nil || row.Bio != nil || row.AvatarID != nil || row.Gender != nil || row.BirthDate != nil {
 		profile = &ProfileDTO{
-			FirstName:  row.FirstName,
-			LastName:   row.LastName,
-			BirthDate:  birthStr,
-			Gender:     row.Gender,
-			Phone:      row.Phone,
-			School:     row.School,
-			GradeLevel: row.GradeLevel,
-			Bio:        row.Bio,
-			AvatarURL:  avatarURL,
+			FirstName:    row.FirstName,
+			LastName:     row.LastName,
+			BirthDate:    birthStr,
+			Gender:       row.Gender,
+			Phone:        row.Phone,
+			School:       row.School,
+			GradeLevel:   row.GradeLevel,
+			Bio:          row.Bio,
+			AvatarURL:    avatarURL,
+			AvatarSrcset: assetSrcset(db, row.AvatarID, avatarURL),
 		}
 	}
 	return &UserDTO{
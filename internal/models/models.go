@@ -63,6 +63,7 @@ type ResourceEntry struct {
 	Summary      string     `db:"summary"`
 	AvatarMedia  *string    `db:"avatar_media_id"`
 	Content      []byte     `db:"content"`
+	SearchText   string     `db:"search_text"`
 	Tags         []byte     `db:"tags"`
 	Status       string     `db:"status"`
 	PublishedAt  *time.Time `db:"published_at"`
@@ -81,6 +82,13 @@ type ServerMetricSample struct {
 	DiskUsedBytes     int64     `db:"disk_used_bytes"`
 	ProcessCpuLoad    float64   `db:"process_cpu_load"`
 	SystemCpuLoad     float64   `db:"system_cpu_load"`
+	HeapAllocBytes    int64     `db:"heap_alloc_bytes"`
+	HeapGoalBytes     int64     `db:"heap_goal_bytes"`
+	GCCycles          int64     `db:"gc_cycles"`
+	GCPauseP99Seconds float64   `db:"gc_pause_p99_seconds"`
+	Goroutines        int64     `db:"goroutines"`
+	DBOpenConns       int       `db:"db_open_connections"`
+	DBInUseConns      int       `db:"db_in_use_connections"`
 }
 
 type Group struct {
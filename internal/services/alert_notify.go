@@ -0,0 +1,133 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"fizicamd-backend-go/internal/config"
+)
+
+// AlertNotifier delivers alerts that fired or resolved.
+type AlertNotifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// AlertNotifiersFor builds the configured notifiers: a webhook when
+// ALERT_WEBHOOK_URL is set and email when SMTP_HOST and ALERT_EMAIL_TO are.
+func AlertNotifiersFor(cfg config.Config) []AlertNotifier {
+	notifiers := []AlertNotifier{}
+	if cfg.AlertWebhookURL != "" {
+		notifiers = append(notifiers, WebhookNotifier{URL: cfg.AlertWebhookURL, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if cfg.SMTPHost != "" && len(cfg.AlertEmailTo) > 0 {
+		notifiers = append(notifiers, EmailNotifier{
+			Address:  net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)),
+			Host:     cfg.SMTPHost,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+			To:       cfg.AlertEmailTo,
+		})
+	}
+	return notifiers
+}
+
+// WebhookNotifier POSTs each alert as JSON. Any 2xx response counts as
+// delivered.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// EmailNotifier sends a plain-text email per alert. Without Username the
+// message is sent unauthenticated; net/smtp uses STARTTLS when offered.
+type EmailNotifier struct {
+	Address  string
+	Host     string
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (n EmailNotifier) Notify(ctx context.Context, alert Alert) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+	subject, body := alertEmail(alert)
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", n.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(n.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	// smtp.SendMail takes no context, so it runs aside and is abandoned
+	// when ctx ends first.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(n.Address, auth, n.From, n.To, message.Bytes())
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func alertEmail(alert Alert) (string, string) {
+	comparison := ">"
+	if alert.Comparison == AlertComparisonBelow {
+		comparison = "<"
+	}
+	var body strings.Builder
+	subject := "[FizicaMD] Alertă activă: " + alert.RuleName
+	if alert.Status == AlertStatusResolved {
+		subject = "[FizicaMD] Alertă rezolvată: " + alert.RuleName
+	}
+	fmt.Fprintf(&body, "Regula: %s\n", alert.RuleName)
+	fmt.Fprintf(&body, "Instanță: %s (%s)\n", alert.InstanceID, alert.Hostname)
+	fmt.Fprintf(&body, "Condiție: %s %s %g\n", alert.Metric, comparison, alert.Threshold)
+	fmt.Fprintf(&body, "Valoare la declanșare: %g\n", alert.Value)
+	fmt.Fprintf(&body, "Valoare de vârf: %g\n", alert.PeakValue)
+	fmt.Fprintf(&body, "Declanșată la: %s\n", alert.FiredAt.UTC().Format(time.RFC3339))
+	if alert.ResolvedAt != nil {
+		fmt.Fprintf(&body, "Rezolvată la: %s\n", alert.ResolvedAt.UTC().Format(time.RFC3339))
+	}
+	return subject, body.String()
+}
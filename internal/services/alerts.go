@@ -0,0 +1,404 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	AlertStatusFiring   = "FIRING"
+	AlertStatusResolved = "RESOLVED"
+
+	AlertComparisonAbove = "above"
+	AlertComparisonBelow = "below"
+
+	maxAlertRuleNameLength = 200
+)
+
+// alertMetrics are the values alert rules can watch. Ratios are skipped when
+// their total is unknown.
+var alertMetrics = map[string]func(sample MetricSample) (float64, bool){
+	"disk_used_ratio": func(s MetricSample) (float64, bool) {
+		return ratio(s.DiskUsedBytes, s.DiskTotalBytes)
+	},
+	"memory_used_ratio": func(s MetricSample) (float64, bool) {
+		return ratio(s.SystemMemoryUsed, s.SystemMemoryTotal)
+	},
+	"system_cpu_load":       func(s MetricSample) (float64, bool) { return s.SystemCpuLoad, true },
+	"process_cpu_load":      func(s MetricSample) (float64, bool) { return s.ProcessCpuLoad, true },
+	"process_rss_bytes":     func(s MetricSample) (float64, bool) { return float64(s.HeapUsedBytes), true },
+	"heap_alloc_bytes":      func(s MetricSample) (float64, bool) { return float64(s.HeapAllocBytes), true },
+	"goroutines":            func(s MetricSample) (float64, bool) { return float64(s.Goroutines), true },
+	"gc_pause_p99_seconds":  func(s MetricSample) (float64, bool) { return s.GCPauseP99Seconds, true },
+	"db_in_use_connections": func(s MetricSample) (float64, bool) { return float64(s.DBInUseConnections), true },
+}
+
+func ratio(used, total int64) (float64, bool) {
+	if total <= 0 {
+		return 0, false
+	}
+	return float64(used) / float64(total), true
+}
+
+// AlertMetricNames lists the metrics rules can use, sorted.
+func AlertMetricNames() []string {
+	names := make([]string, 0, len(alertMetrics))
+	for name := range alertMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AlertRule fires when its metric stays past Threshold for ForSeconds and
+// resolves once the metric is back past ClearThreshold. The gap between the
+// two thresholds keeps a value hovering around the limit from flapping.
+type AlertRule struct {
+	ID             string    `db:"id" json:"id"`
+	Name           string    `db:"name" json:"name"`
+	Metric         string    `db:"metric" json:"metric"`
+	Comparison     string    `db:"comparison" json:"comparison"`
+	Threshold      float64   `db:"threshold" json:"threshold"`
+	ClearThreshold float64   `db:"clear_threshold" json:"clearThreshold"`
+	ForSeconds     int       `db:"for_seconds" json:"forSeconds"`
+	Enabled        bool      `db:"enabled" json:"enabled"`
+	CreatedAt      time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+func (r AlertRule) breached(value float64) bool {
+	if r.Comparison == AlertComparisonBelow {
+		return value < r.Threshold
+	}
+	return value > r.Threshold
+}
+
+func (r AlertRule) cleared(value float64) bool {
+	if r.Comparison == AlertComparisonBelow {
+		return value > r.ClearThreshold
+	}
+	return value < r.ClearThreshold
+}
+
+// Alert is one firing period of a rule. The rule's name, metric and
+// threshold are copied so the history survives edits and deletion.
+type Alert struct {
+	ID         string     `db:"id" json:"id"`
+	InstanceID string     `db:"instance_id" json:"instanceId"`
+	Hostname   string     `db:"hostname" json:"hostname"`
+	RuleID     *string    `db:"rule_id" json:"ruleId"`
+	RuleName   string     `db:"rule_name" json:"ruleName"`
+	Metric     string     `db:"metric" json:"metric"`
+	Comparison string     `db:"comparison" json:"comparison"`
+	Threshold  float64    `db:"threshold" json:"threshold"`
+	Status     string     `db:"status" json:"status"`
+	Value      float64    `db:"value" json:"value"`
+	PeakValue  float64    `db:"peak_value" json:"peakValue"`
+	FiredAt    time.Time  `db:"fired_at" json:"firedAt"`
+	ResolvedAt *time.Time `db:"resolved_at" json:"resolvedAt"`
+}
+
+const alertRuleColumns = `id, name, metric, comparison, threshold, clear_threshold, for_seconds, enabled, created_at, updated_at`
+
+const alertColumns = `id, instance_id, hostname, rule_id, rule_name, metric, comparison, threshold, status, value, peak_value, fired_at, resolved_at`
+
+func ListAlertRules(db *sqlx.DB) ([]AlertRule, error) {
+	rules := []AlertRule{}
+	err := db.Select(&rules, `SELECT `+alertRuleColumns+` FROM alert_rules ORDER BY created_at, name`)
+	return rules, err
+}
+
+func GetAlertRule(db *sqlx.DB, ruleID string) (AlertRule, error) {
+	var rule AlertRule
+	if !validUUID(ruleID) {
+		return rule, ErrNotFound("Regula nu a fost găsită.")
+	}
+	err := db.Get(&rule, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE id = $1::uuid`, ruleID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return rule, ErrNotFound("Regula nu a fost găsită.")
+	}
+	return rule, err
+}
+
+// SaveAlertRule validates rule and inserts it, or updates it when it has an
+// ID. An empty comparison means "above".
+func SaveAlertRule(db *sqlx.DB, rule AlertRule) (AlertRule, error) {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Metric = strings.TrimSpace(rule.Metric)
+	rule.Comparison = strings.ToLower(strings.TrimSpace(rule.Comparison))
+	if rule.Comparison == "" {
+		rule.Comparison = AlertComparisonAbove
+	}
+	if err := validateAlertRule(rule); err != nil {
+		return rule, err
+	}
+	now := time.Now().UTC()
+	if rule.ID == "" {
+		rule.ID = uuid.NewString()
+		_, err := db.Exec(`
+INSERT INTO alert_rules (id, name, metric, comparison, threshold, clear_threshold, for_seconds, enabled, created_at, updated_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$9)
+`, rule.ID, rule.Name, rule.Metric, rule.Comparison, rule.Threshold, rule.ClearThreshold, rule.ForSeconds, rule.Enabled, now)
+		if err != nil {
+			return rule, err
+		}
+		return GetAlertRule(db, rule.ID)
+	}
+	if !validUUID(rule.ID) {
+		return rule, ErrNotFound("Regula nu a fost găsită.")
+	}
+	result, err := db.Exec(`
+UPDATE alert_rules
+SET name = $2, metric = $3, comparison = $4, threshold = $5, clear_threshold = $6,
+    for_seconds = $7, enabled = $8, updated_at = $9
+WHERE id = $1::uuid
+`, rule.ID, rule.Name, rule.Metric, rule.Comparison, rule.Threshold, rule.ClearThreshold, rule.ForSeconds, rule.Enabled, now)
+	if err != nil {
+		return rule, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return rule, ErrNotFound("Regula nu a fost găsită.")
+	}
+	return GetAlertRule(db, rule.ID)
+}
+
+func validateAlertRule(rule AlertRule) error {
+	if rule.Name == "" || len(rule.Name) > maxAlertRuleNameLength {
+		return ErrBadRequest("Numele regulii este invalid.")
+	}
+	if _, ok := alertMetrics[rule.Metric]; !ok {
+		return ErrBadRequest("Metrica trebuie să fie una dintre: " + strings.Join(AlertMetricNames(), ", ") + ".")
+	}
+	if math.IsNaN(rule.Threshold) || math.IsNaN(rule.ClearThreshold) || math.IsInf(rule.Threshold, 0) || math.IsInf(rule.ClearThreshold, 0) {
+		return ErrBadRequest("Pragurile sunt invalide.")
+	}
+	switch rule.Comparison {
+	case AlertComparisonAbove:
+		if rule.ClearThreshold > rule.Threshold {
+			return ErrBadRequest("Pragul de revenire trebuie să fie cel mult egal cu pragul de alertă.")
+		}
+	case AlertComparisonBelow:
+		if rule.ClearThreshold < rule.Threshold {
+			return ErrBadRequest("Pragul de revenire trebuie să fie cel puțin egal cu pragul de alertă.")
+		}
+	default:
+		return ErrBadRequest("Comparația trebuie să fie above sau below.")
+	}
+	if rule.ForSeconds < 0 || rule.ForSeconds > 7*24*3600 {
+		return ErrBadRequest("Durata este invalidă.")
+	}
+	return nil
+}
+
+// DeleteAlertRule deletes a rule and resolves its firing alert, if any.
+func DeleteAlertRule(db *sqlx.DB, ruleID string) error {
+	if !validUUID(ruleID) {
+		return ErrNotFound("Regula nu a fost găsită.")
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+UPDATE alerts SET status = $2, resolved_at = $3
+WHERE rule_id = $1::uuid AND status = $4
+`, ruleID, AlertStatusResolved, time.Now().UTC(), AlertStatusFiring); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`DELETE FROM alert_rules WHERE id = $1::uuid`, ruleID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrNotFound("Regula nu a fost găsită.")
+	}
+	return tx.Commit()
+}
+
+// ListAlerts returns alerts newest first, optionally only those with status,
+// and the total number of matches.
+func ListAlerts(db *sqlx.DB, status string, limit, offset int) ([]Alert, int, error) {
+	where := ""
+	args := []interface{}{}
+	if status != "" {
+		where = "WHERE status = $1"
+		args = append(args, status)
+	}
+	var total int
+	if err := db.Get(&total, `SELECT count(*) FROM alerts `+where, args...); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, limit, offset)
+	items := []Alert{}
+	query := fmt.Sprintf(`SELECT `+alertColumns+` FROM alerts %s ORDER BY fired_at DESC LIMIT $%d OFFSET $%d`,
+		where, len(args)-1, len(args))
+	if err := db.Select(&items, query, args...); err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+// AlertEvaluator checks one instance's samples against the rules. It only
+// remembers since when each rule has been breached; firing alerts are read
+// from the database so a restart neither loses nor duplicates them. Each
+// instance fires and resolves its own alerts.
+type AlertEvaluator struct {
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+func NewAlertEvaluator() *AlertEvaluator {
+	return &AlertEvaluator{pending: map[string]time.Time{}}
+}
+
+// Evaluate applies sample to every rule and returns the alerts that fired or
+// resolved because of it.
+func (e *AlertEvaluator) Evaluate(db *sqlx.DB, sample MetricSample) ([]Alert, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	rules, err := ListAlertRules(db)
+	if err != nil {
+		return nil, err
+	}
+	firing := []Alert{}
+	if err := db.Select(&firing, `
+SELECT `+alertColumns+` FROM alerts
+WHERE status = $1 AND rule_id IS NOT NULL AND instance_id = $2
+`, AlertStatusFiring, sample.InstanceID); err != nil {
+		return nil, err
+	}
+	firingByRule := map[string]Alert{}
+	for _, alert := range firing {
+		firingByRule[*alert.RuleID] = alert
+	}
+
+	changed := []Alert{}
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		seen[rule.ID] = true
+		read, ok := alertMetrics[rule.Metric]
+		if !ok {
+			continue
+		}
+		value, ok := read(sample)
+		alert, isFiring := firingByRule[rule.ID]
+		switch {
+		case isFiring && (!rule.Enabled || (ok && rule.cleared(value))):
+			resolved, err := resolveAlert(db, alert, sample.CapturedAt)
+			if err != nil {
+				return changed, err
+			}
+			changed = append(changed, resolved)
+		case isFiring:
+			if ok && rule.breached(value) && worse(rule, value, alert.PeakValue) {
+				_, err := db.Exec(`UPDATE alerts SET peak_value = $2 WHERE id = $1`, alert.ID, value)
+				if err != nil {
+					return changed, err
+				}
+			}
+		case !rule.Enabled || !ok || !rule.breached(value):
+			delete(e.pending, rule.ID)
+		default:
+			since, pending := e.pending[rule.ID]
+			if !pending {
+				since = sample.CapturedAt
+				e.pending[rule.ID] = since
+			}
+			if sample.CapturedAt.Sub(since) < time.Duration(rule.ForSeconds)*time.Second {
+				continue
+			}
+			fired, err := fireAlert(db, rule, sample, value)
+			if err != nil {
+				return changed, err
+			}
+			delete(e.pending, rule.ID)
+			if fired != nil {
+				changed = append(changed, *fired)
+			}
+		}
+	}
+	for ruleID := range e.pending {
+		if !seen[ruleID] {
+			delete(e.pending, ruleID)
+		}
+	}
+	return changed, nil
+}
+
+func worse(rule AlertRule, value, peak float64) bool {
+	if rule.Comparison == AlertComparisonBelow {
+		return value < peak
+	}
+	return value > peak
+}
+
+// fireAlert records a firing alert for the instance that took sample. It
+// returns nil if one was already recorded.
+func fireAlert(db *sqlx.DB, rule AlertRule, sample MetricSample, value float64) (*Alert, error) {
+	alert := Alert{
+		ID:         uuid.NewString(),
+		InstanceID: sample.InstanceID,
+		Hostname:   sample.Hostname,
+		RuleID:     &rule.ID,
+		RuleName:   rule.Name,
+		Metric:     rule.Metric,
+		Comparison: rule.Comparison,
+		Threshold:  rule.Threshold,
+		Status:     AlertStatusFiring,
+		Value:      value,
+		PeakValue:  value,
+		FiredAt:    sample.CapturedAt,
+	}
+	result, err := db.Exec(`
+INSERT INTO alerts (id, instance_id, hostname, rule_id, rule_name, metric, comparison, threshold, status, value, peak_value, fired_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$10,$11)
+ON CONFLICT (rule_id, instance_id) WHERE status = 'FIRING' DO NOTHING
+`, alert.ID, alert.InstanceID, alert.Hostname, rule.ID, alert.RuleName, alert.Metric, alert.Comparison, alert.Threshold,
+		alert.Status, value, alert.FiredAt)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, nil
+	}
+	return &alert, nil
+}
+
+// ResolveStaleAlerts resolves the firing alerts of instances that have not
+// recorded a sample since ttl before now. An instance that is gone, e.g. a
+// replaced container with a new hostname-based ID, never evaluates its own
+// alerts again, so any instance may close them. The UPDATE makes sure each is
+// returned by one instance only.
+func ResolveStaleAlerts(db *sqlx.DB, ttl time.Duration, now time.Time) ([]Alert, error) {
+	alerts := []Alert{}
+	if ttl <= 0 {
+		return alerts, nil
+	}
+	now = now.UTC()
+	err := db.Select(&alerts, `
+UPDATE alerts a SET status = $2, resolved_at = $3
+WHERE a.status = $1
+  AND NOT EXISTS (
+    SELECT 1 FROM server_metric_samples s
+    WHERE s.instance_id = a.instance_id AND s.captured_at >= $4
+  )
+RETURNING `+alertColumns, AlertStatusFiring, AlertStatusResolved, now, now.Add(-ttl))
+	return alerts, err
+}
+
+func resolveAlert(db *sqlx.DB, alert Alert, at time.Time) (Alert, error) {
+	_, err := db.Exec(`UPDATE alerts SET status = $2, resolved_at = $3 WHERE id = $1`, alert.ID, AlertStatusResolved, at)
+	alert.Status = AlertStatusResolved
+	alert.ResolvedAt = &at
+	return alert, err
+}
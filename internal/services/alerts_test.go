@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	alertRuleID = "99999999-9999-9999-9999-999999999999"
+	alertID     = "aaaaaaaa-0000-0000-0000-000000000001"
+)
+
+var diskRule = AlertRule{ID: alertRuleID, Name: "Disc plin", Metric: "disk_used_ratio", Comparison: AlertComparisonAbove, Threshold: 0.9, ClearThreshold: 0.8, ForSeconds: 60, Enabled: true}
+
+func TestAlertRuleHysteresis(t *testing.T) {
+	below := AlertRule{Comparison: AlertComparisonBelow, Threshold: 0.1, ClearThreshold: 0.2}
+	tests := []struct {
+		name         string
+		rule         AlertRule
+		value        float64
+		wantBreached bool
+		wantCleared  bool
+	}{
+		{"above: past the threshold", diskRule, 0.95, true, false},
+		{"above: between the thresholds", diskRule, 0.85, false, false},
+		{"above: at the threshold", diskRule, 0.9, false, false},
+		{"above: back under the clear threshold", diskRule, 0.75, false, true},
+		{"below: past the threshold", below, 0.05, true, false},
+		{"below: between the thresholds", below, 0.15, false, false},
+		{"below: back over the clear threshold", below, 0.25, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.breached(tt.value); got != tt.wantBreached {
+				t.Errorf("breached(%v) = %t, want %t", tt.value, got, tt.wantBreached)
+			}
+			if got := tt.rule.cleared(tt.value); got != tt.wantCleared {
+				t.Errorf("cleared(%v) = %t, want %t", tt.value, got, tt.wantCleared)
+			}
+		})
+	}
+}
+
+func diskSample(at time.Time, usedRatio float64) MetricSample {
+	return MetricSample{InstanceID: "api-1", Hostname: "api-1", CapturedAt: at, DiskTotalBytes: 1000, DiskUsedBytes: int64(usedRatio * 1000)}
+}
+
+func expectAlertRules(mock sqlmock.Sqlmock, rule AlertRule) {
+	mock.ExpectQuery(`FROM alert_rules ORDER BY created_at, name`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "metric", "comparison", "threshold", "clear_threshold", "for_seconds", "enabled", "created_at", "updated_at"}).
+			AddRow(rule.ID, rule.Name, rule.Metric, rule.Comparison, rule.Threshold, rule.ClearThreshold, rule.ForSeconds, rule.Enabled, time.Now(), time.Now()))
+}
+
+func expectFiringAlerts(mock sqlmock.Sqlmock, peak float64) {
+	rows := sqlmock.NewRows([]string{"id", "instance_id", "hostname", "rule_id", "rule_name", "metric", "comparison", "threshold", "status", "value", "peak_value", "fired_at", "resolved_at"})
+	if peak > 0 {
+		rows.AddRow(alertID, "api-1", "api-1", alertRuleID, diskRule.Name, diskRule.Metric, diskRule.Comparison, diskRule.Threshold, AlertStatusFiring, peak, peak, time.Now(), nil)
+	}
+	mock.ExpectQuery(`FROM alerts\s+WHERE status = \$1 AND rule_id IS NOT NULL AND instance_id = \$2`).WithArgs(AlertStatusFiring, "api-1").WillReturnRows(rows)
+}
+
+func TestAlertEvaluatorFiresAfterForSeconds(t *testing.T) {
+	db, mock := newMockDB(t)
+	evaluator := NewAlertEvaluator()
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	// First breach: pending, nothing fires yet.
+	expectAlertRules(mock, diskRule)
+	expectFiringAlerts(mock, 0)
+	if changed, err := evaluator.Evaluate(db, diskSample(start, 0.95)); err != nil || len(changed) != 0 {
+		t.Fatalf("first breach: Evaluate() = %v, %v, want nothing", changed, err)
+	}
+
+	// Still breached after ForSeconds: the alert fires.
+	expectAlertRules(mock, diskRule)
+	expectFiringAlerts(mock, 0)
+	mock.ExpectExec(`INSERT INTO alerts .*ON CONFLICT \(rule_id, instance_id\) WHERE status = 'FIRING' DO NOTHING`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	changed, err := evaluator.Evaluate(db, diskSample(start.Add(61*time.Second), 0.96))
+	if err != nil || len(changed) != 1 || changed[0].Status != AlertStatusFiring || changed[0].PeakValue != 0.96 {
+		t.Fatalf("sustained breach: Evaluate() = %+v, %v, want one firing alert", changed, err)
+	}
+}
+
+func TestAlertEvaluatorRecoveryResetsPending(t *testing.T) {
+	db, mock := newMockDB(t)
+	evaluator := NewAlertEvaluator()
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	// The dip at 30 s restarts the breach, so at 61 s it has lasted only
+	// 31 s and nothing fires.
+	samples := []MetricSample{diskSample(start, 0.95), diskSample(start.Add(30*time.Second), 0.5), diskSample(start.Add(61*time.Second), 0.95)}
+	for i, sample := range samples {
+		expectAlertRules(mock, diskRule)
+		expectFiringAlerts(mock, 0)
+		if changed, err := evaluator.Evaluate(db, sample); err != nil || len(changed) != 0 {
+			t.Fatalf("sample %d: Evaluate() = %v, %v, want nothing", i, changed, err)
+		}
+	}
+}
+
+func TestAlertEvaluatorFiringAlert(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		value        float64
+		rule         AlertRule
+		wantPeak     bool
+		wantResolved bool
+	}{
+		{"between the thresholds stays firing", 0.85, diskRule, false, false},
+		{"worse value raises the peak", 0.99, diskRule, true, false},
+		{"under the clear threshold resolves", 0.7, diskRule, false, true},
+		{"disabled rule resolves", 0.99, AlertRule{ID: alertRuleID, Metric: "disk_used_ratio", Comparison: AlertComparisonAbove, Threshold: 0.9, ClearThreshold: 0.8}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectAlertRules(mock, tt.rule)
+			expectFiringAlerts(mock, 0.95)
+			if tt.wantPeak {
+				mock.ExpectExec(`UPDATE alerts SET peak_value = \$2 WHERE id = \$1`).WithArgs(alertID, tt.value).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			if tt.wantResolved {
+				mock.ExpectExec(`UPDATE alerts SET status = \$2, resolved_at = \$3 WHERE id = \$1`).WithArgs(alertID, AlertStatusResolved, now).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			changed, err := NewAlertEvaluator().Evaluate(db, diskSample(now, tt.value))
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if resolved := len(changed) == 1 && changed[0].Status == AlertStatusResolved; resolved != tt.wantResolved || len(changed) > 1 {
+				t.Errorf("changed = %+v, want resolved %t", changed, tt.wantResolved)
+			}
+		})
+	}
+}
+
+func TestAlertEvaluatorSkipsUnknownTotals(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAlertRules(mock, AlertRule{ID: alertRuleID, Metric: "disk_used_ratio", Comparison: AlertComparisonAbove, Threshold: 0.9, Enabled: true})
+	expectFiringAlerts(mock, 0)
+	sample := MetricSample{InstanceID: "api-1", CapturedAt: time.Now(), DiskUsedBytes: 10}
+	if changed, err := NewAlertEvaluator().Evaluate(db, sample); err != nil || len(changed) != 0 {
+		t.Fatalf("Evaluate() = %v, %v, want nothing", changed, err)
+	}
+}
+
+func TestResolveStaleAlerts(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`UPDATE alerts a SET status = \$2, resolved_at = \$3\s+WHERE a.status = \$1\s+AND NOT EXISTS`).
+		WithArgs(AlertStatusFiring, AlertStatusResolved, now, now.Add(-10*time.Minute)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "instance_id", "status"}).AddRow(alertID, "api-old", AlertStatusResolved))
+
+	alerts, err := ResolveStaleAlerts(db, 10*time.Minute, now)
+	if err != nil || len(alerts) != 1 || alerts[0].InstanceID != "api-old" {
+		t.Fatalf("ResolveStaleAlerts() = %+v, %v", alerts, err)
+	}
+}
+
+func TestResolveStaleAlertsDisabled(t *testing.T) {
+	db, _ := newMockDB(t)
+	alerts, err := ResolveStaleAlerts(db, 0, time.Now())
+	if err != nil || alerts == nil || len(alerts) != 0 {
+		t.Fatalf("ResolveStaleAlerts() = %v, %v, want an empty list without querying", alerts, err)
+	}
+}
+
+func TestResolveStaleAlertsError(t *testing.T) {
+	db, mock := newMockDB(t)
+	boom := errors.New("connection reset")
+	mock.ExpectQuery(`UPDATE alerts a`).WillReturnError(boom)
+	if _, err := ResolveStaleAlerts(db, time.Minute, time.Now()); !errors.Is(err, boom) {
+		t.Fatalf("error = %v, want %v", err, boom)
+	}
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Visits are aggregated per UTC day into the analytics_daily* tables, which
+// the analytics API reads instead of site_visits. Visitors are identified by
+// an HMAC of their IP address and User-Agent keyed with a server secret.
+const (
+	AnalyticsIntervalDay   = "day"
+	AnalyticsIntervalWeek  = "week"
+	AnalyticsIntervalMonth = "month"
+
+	directReferrer = "(direct)"
+	dayLayout      = "2006-01-02"
+)
+
+type dailyVisits struct {
+	visits    int64
+	visitors  map[string]int64
+	paths     map[string]int64
+	referrers map[string]int64
+	agents    map[[2]string]int64
+}
+
+// RollupVisits aggregates every day from the last rolled-up one (which may
+// have been partial) through today, keying visitor hashes with secret. The
+// first run backfills all history. It returns the number of days written, or
+// ErrJobRunning when another instance is already rolling up.
+func RollupVisits(db *sqlx.DB, secret string, now time.Time) (int, error) {
+	days := 0
+	err := withAdvisoryLock(db, "analytics_rollup", ErrJobRunning, func() error {
+		var err error
+		days, err = rollupVisits(db, secret, now)
+		return err
+	})
+	return days, err
+}
+
+func rollupVisits(db *sqlx.DB, secret string, now time.Time) (int, error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	var start *time.Time
+	if err := db.Get(&start, `SELECT MAX(day)::timestamp AT TIME ZONE 'UTC' FROM analytics_daily`); err != nil {
+		return 0, err
+	}
+	if start == nil {
+		if err := db.Get(&start, `SELECT MIN(created_at) FROM site_visits`); err != nil {
+			return 0, err
+		}
+		if start == nil {
+			return 0, nil
+		}
+	}
+	days := 0
+	for day := start.UTC().Truncate(24 * time.Hour); !day.After(today); day = day.AddDate(0, 0, 1) {
+		if err := rollupVisitDay(db, secret, day); err != nil {
+			return days, err
+		}
+		days++
+	}
+	return days, nil
+}
+
+func rollupVisitDay(db *sqlx.DB, secret string, day time.Time) error {
+	stats := dailyVisits{
+		visitors:  map[string]int64{},
+		paths:     map[string]int64{},
+		referrers: map[string]int64{},
+		agents:    map[[2]string]int64{},
+	}
+	rows, err := db.Queryx(`
+SELECT COALESCE(ip_address, ''), COALESCE(user_agent, ''), COALESCE(path, ''), COALESCE(referrer, '')
+FROM site_visits
+WHERE created_at >= $1 AND created_at < $2
+`, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var ip, ua, path, referrer string
+		if err := rows.Scan(&ip, &ua, &path, &referrer); err != nil {
+			rows.Close()
+			return err
+		}
+		agent := ParseUserAgent(ua)
+		stats.visits++
+		stats.visitors[visitorHash(secret, ip, ua)]++
+		stats.paths[normalizeVisitPath(path)]++
+		stats.referrers[referrerDomain(referrer)]++
+		stats.agents[[2]string{"browser", agent.Browser}]++
+		stats.agents[[2]string{"os", agent.OS}]++
+		stats.agents[[2]string{"device", agent.Device}]++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"analytics_daily", "analytics_daily_visitors", "analytics_daily_paths", "analytics_daily_referrers", "analytics_daily_agents"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE day = $1::date`, day); err != nil {
+			return err
+		}
+	}
+	hashes, visitorVisits := countColumns(stats.visitors)
+	paths, pathVisits := countColumns(stats.paths)
+	domains, referrerVisits := countColumns(stats.referrers)
+	dimensions, values, agentVisits := []string{}, []string{}, []int64{}
+	for key, visits := range stats.agents {
+		dimensions, values, agentVisits = append(dimensions, key[0]), append(values, key[1]), append(agentVisits, visits)
+	}
+	inserts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO analytics_daily_visitors (day, visitor_hash, visits)
+SELECT $1::date, * FROM unnest($2::text[], $3::bigint[])`, []interface{}{day, hashes, visitorVisits}},
+		{`INSERT INTO analytics_visitors (visitor_hash, first_day)
+SELECT hash, $1::date FROM unnest($2::text[]) AS hash
+ON CONFLICT (visitor_hash) DO UPDATE SET first_day = LEAST(analytics_visitors.first_day, EXCLUDED.first_day)`, []interface{}{day, hashes}},
+		{`INSERT INTO analytics_daily_paths (day, path, visits)
+SELECT $1::date, * FROM unnest($2::text[], $3::bigint[])`, []interface{}{day, paths, pathVisits}},
+		{`INSERT INTO analytics_daily_referrers (day, domain, visits)
+SELECT $1::date, * FROM unnest($2::text[], $3::bigint[])`, []interface{}{day, domains, referrerVisits}},
+		{`INSERT INTO analytics_daily_agents (day, dimension, value, visits)
+SELECT $1::date, * FROM unnest($2::text[], $3::text[], $4::bigint[])`, []interface{}{day, dimensions, values, agentVisits}},
+	}
+	for _, insert := range inserts {
+		if _, err := tx.Exec(insert.query, insert.args...); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+INSERT INTO analytics_daily (day, visits, visitors, new_visitors)
+SELECT $1::date, $2::bigint, $3::bigint, COUNT(*)
+FROM analytics_daily_visitors d
+JOIN analytics_visitors v ON v.visitor_hash = d.visitor_hash AND v.first_day = d.day
+WHERE d.day = $1::date
+`, day, stats.visits, len(stats.visitors)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func countColumns(counts map[string]int64) ([]string, []int64) {
+	keys := make([]string, 0, len(counts))
+	values := make([]int64, 0, len(counts))
+	for key, count := range counts {
+		keys = append(keys, key)
+		values = append(values, count)
+	}
+	return keys, values
+}
+
+// visitorHash identifies a visitor in the aggregates. A plain hash of an IP
+// address is easily reversed by hashing the whole address space, so it is an
+// HMAC keyed with secret, which never leaves the server. site_visits itself
+// still stores the raw IP address. The port is dropped since RemoteAddr
+// changes with every connection.
+func visitorHash(secret, ip, ua string) string {
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ip + "|" + ua))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func normalizeVisitPath(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+// referrerDomain reduces a referrer to its host without "www.".
+func referrerDomain(referrer string) string {
+	if strings.TrimSpace(referrer) == "" {
+		return directReferrer
+	}
+	parsed, err := url.Parse(referrer)
+	if err != nil || parsed.Hostname() == "" {
+		return directReferrer
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+// AnalyticsPeriod is the traffic of one day, week or month. New visitors
+// came for the first time in the period; the others were returning.
+type AnalyticsPeriod struct {
+	Period            string `db:"period" json:"period"`
+	Visits            int64  `db:"visits" json:"visits"`
+	Visitors          int64  `db:"visitors" json:"visitors"`
+	NewVisitors       int64  `db:"new_visitors" json:"newVisitors"`
+	ReturningVisitors int64  `db:"returning_visitors" json:"returningVisitors"`
+}
+
+// VisitsByPeriod returns the traffic between the days from and to
+// (exclusive) grouped by interval, and the totals of the whole range.
+// Unique visitors are counted per period, not summed from the days.
+func VisitsByPeriod(db *sqlx.DB, interval string, from, to time.Time) ([]AnalyticsPeriod, AnalyticsPeriod, error) {
+	items := []AnalyticsPeriod{}
+	err := db.Select(&items, `
+WITH visitors AS (
+  SELECT date_trunc($1, d.day::timestamp)::date AS period, COUNT(DISTINCT d.visitor_hash) AS visitors,
+         COUNT(DISTINCT d.visitor_hash) FILTER (WHERE v.first_day >= date_trunc($1, d.day::timestamp)::date) AS new_visitors
+  FROM analytics_daily_visitors d
+  JOIN analytics_visitors v ON v.visitor_hash = d.visitor_hash
+  WHERE d.day >= $2 AND d.day < $3
+  GROUP BY 1
+), visits AS (
+  SELECT date_trunc($1, day::timestamp)::date AS period, SUM(visits) AS visits
+  FROM analytics_daily
+  WHERE day >= $2 AND day < $3
+  GROUP BY 1
+)
+SELECT to_char(visits.period, 'YYYY-MM-DD') AS period, visits.visits,
+       COALESCE(visitors.visitors, 0) AS visitors,
+       COALESCE(visitors.new_visitors, 0) AS new_visitors,
+       COALESCE(visitors.visitors - visitors.new_visitors, 0) AS returning_visitors
+FROM visits
+LEFT JOIN visitors ON visitors.period = visits.period
+ORDER BY visits.period
+`, interval, from, to)
+	if err != nil {
+		return nil, AnalyticsPeriod{}, err
+	}
+	totals := AnalyticsPeriod{Period: from.Format(dayLayout)}
+	err = db.Get(&totals, `
+SELECT $1::text AS period,
+       (SELECT COALESCE(SUM(visits), 0) FROM analytics_daily WHERE day >= $2 AND day < $3) AS visits,
+       COUNT(DISTINCT d.visitor_hash) AS visitors,
+       COUNT(DISTINCT d.visitor_hash) FILTER (WHERE v.first_day >= $2) AS new_visitors,
+       COUNT(DISTINCT d.visitor_hash) FILTER (WHERE v.first_day < $2) AS returning_visitors
+FROM analytics_daily_visitors d
+JOIN analytics_visitors v ON v.visitor_hash = d.visitor_hash
+WHERE d.day >= $2 AND d.day < $3
+`, from.Format(dayLayout), from, to)
+	return items, totals, err
+}
+
+// AnalyticsCount is a path, referrer domain or user-agent value with its
+// number of visits.
+type AnalyticsCount struct {
+	Name   string `db:"name" json:"name"`
+	Visits int64  `db:"visits" json:"visits"`
+}
+
+func TopVisitPaths(db *sqlx.DB, from, to time.Time, limit int) ([]AnalyticsCount, error) {
+	return topAnalytics(db, `SELECT path AS name, SUM(visits) AS visits FROM analytics_daily_paths
+WHERE day >= $1 AND day < $2 GROUP BY path ORDER BY visits DESC, name LIMIT $3`, from, to, limit)
+}
+
+func TopReferrers(db *sqlx.DB, from, to time.Time, limit int) ([]AnalyticsCount, error) {
+	return topAnalytics(db, `SELECT domain AS name, SUM(visits) AS visits FROM analytics_daily_referrers
+WHERE day >= $1 AND day < $2 GROUP BY domain ORDER BY visits DESC, name LIMIT $3`, from, to, limit)
+}
+
+// AgentBreakdown returns the visits per browser, OS or device class
+// (dimension "browser", "os" or "device").
+func AgentBreakdown(db *sqlx.DB, dimension string, from, to time.Time) ([]AnalyticsCount, error) {
+	return topAnalytics(db, `SELECT value AS name, SUM(visits) AS visits FROM analytics_daily_agents
+WHERE day >= $1 AND day < $2 AND dimension = $3 GROUP BY value ORDER BY visits DESC, name`, from, to, dimension)
+}
+
+func topAnalytics(db *sqlx.DB, query string, args ...interface{}) ([]AnalyticsCount, error) {
+	items := []AnalyticsCount{}
+	err := db.Select(&items, query, args...)
+	return items, err
+}
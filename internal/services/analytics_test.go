@@ -0,0 +1,31 @@
+package services
+
+import "testing"
+
+func TestVisitorHash(t *testing.T) {
+	base := visitorHash("secret", "203.0.113.7:51234", "Firefox")
+	tests := []struct {
+		name   string
+		secret string
+		ip     string
+		ua     string
+		same   bool
+	}{
+		{"other port", "secret", "203.0.113.7:40000", "Firefox", true},
+		{"no port", "secret", "203.0.113.7", "Firefox", true},
+		{"other address", "secret", "203.0.113.8:51234", "Firefox", false},
+		{"other user agent", "secret", "203.0.113.7:51234", "Chrome", false},
+		{"other secret", "rotated", "203.0.113.7:51234", "Firefox", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := visitorHash(tt.secret, tt.ip, tt.ua)
+			if (got == base) != tt.same {
+				t.Errorf("visitorHash(%q, %q, %q) = %s, base %s, want same %t", tt.secret, tt.ip, tt.ua, got, base, tt.same)
+			}
+			if len(got) != 32 {
+				t.Errorf("len = %d, want 32", len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	AssetUsageBlock  = "BLOCK"
+	AssetUsageAvatar = "AVATAR"
+)
+
+// ValidateResourceAssets checks that every asset referenced by a resource
+// exists, has the content type its block expects and may be used by the
+// resource author: the author owns it, it is not PRIVATE, or the resource
+// already referenced it before this edit. Quarantined files are refused.
+func ValidateResourceAssets(db *sqlx.DB, authorID, resourceID string, refs []AssetRef, avatarID *string) error {
+	if avatarID != nil && strings.TrimSpace(*avatarID) != "" {
+		refs = append(refs, AssetRef{AssetID: strings.TrimSpace(*avatarID), Accept: "image/*"})
+	}
+	for _, ref := range refs {
+		row := struct {
+			OwnerID      *string `db:"owner_user_id"`
+			ContentType  string  `db:"content_type"`
+			AccessPolicy string  `db:"access_policy"`
+			Status       string  `db:"status"`
+			Referenced   bool    `db:"referenced"`
+		}{}
+		if !validUUID(ref.AssetID) {
+			return ErrBadRequest("Fișierul atașat nu există: " + ref.AssetID)
+		}
+		err := db.Get(&row, `
+SELECT m.owner_user_id, m.content_type, m.access_policy, m.status,
+       EXISTS(SELECT 1 FROM resource_asset_refs r WHERE r.asset_id = m.id AND r.resource_id = $2::uuid) AS referenced
+FROM media_assets m
+WHERE m.id = $1::uuid
+`, ref.AssetID, resourceID)
+		if err != nil {
+			return ErrBadRequest("Fișierul atașat nu există: " + ref.AssetID)
+		}
+		if !matchesContentType(row.ContentType, ref.Accept) {
+			return ErrBadRequest("Fișierul atașat nu are tipul potrivit (" + ref.Accept + "): " + ref.AssetID)
+		}
+		if row.Status == MediaStatusQuarantined {
+			return ErrBadRequest("Fișierul atașat a fost blocat de scanarea antivirus: " + ref.AssetID)
+		}
+		if row.Status == MediaStatusScanFailed {
+			return ErrBadRequest("Fișierul atașat nu a putut fi scanat antivirus: " + ref.AssetID)
+		}
+		owned := row.OwnerID != nil && *row.OwnerID == authorID
+		if !owned && row.AccessPolicy == "PRIVATE" && !row.Referenced {
+			return ErrForbidden("Nu aveți acces la fișierul atașat: " + ref.AssetID)
+		}
+	}
+	return nil
+}
+
+// SyncResourceAssetRefs replaces the recorded asset references of a resource.
+// Run it in the transaction that writes the resource so the references never
+// disagree with the stored blocks.
+func SyncResourceAssetRefs(db sqlx.Execer, resourceID string, refs []AssetRef, avatarID *string) error {
+	if _, err := db.Exec(`DELETE FROM resource_asset_refs WHERE resource_id = $1`, resourceID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, ref := range refs {
+		if _, err := db.Exec(`
+INSERT INTO resource_asset_refs (resource_id, asset_id, usage, created_at)
+VALUES ($1,$2,$3,$4)
+ON CONFLICT DO NOTHING
+`, resourceID, ref.AssetID, AssetUsageBlock, now); err != nil {
+			return err
+		}
+	}
+	if avatarID != nil && strings.TrimSpace(*avatarID) != "" {
+		if _, err := db.Exec(`
+INSERT INTO resource_asset_refs (resource_id, asset_id, usage, created_at)
+VALUES ($1,$2,$3,$4)
+ON CONFLICT DO NOTHING
+`, resourceID, strings.TrimSpace(*avatarID), AssetUsageAvatar, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesContentType(contentType, accept string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	accept = strings.ToLower(accept)
+	if accept == "" || accept == "*/*" {
+		return true
+	}
+	if strings.HasSuffix(accept, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(accept, "*"))
+	}
+	return contentType == accept
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateResourceAssets(t *testing.T) {
+	const (
+		author   = "11111111-1111-1111-1111-111111111111"
+		other    = "22222222-2222-2222-2222-222222222222"
+		resource = "33333333-3333-3333-3333-333333333333"
+		asset    = "44444444-4444-4444-4444-444444444444"
+	)
+	columns := []string{"owner_user_id", "content_type", "access_policy", "status", "referenced"}
+	row := func(owner, contentType, policy, status string, referenced bool) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).AddRow(owner, contentType, policy, status, referenced)
+	}
+	tests := []struct {
+		name       string
+		refs       []AssetRef
+		avatar     *string
+		rows       *sqlmock.Rows
+		queryErr   error
+		wantStatus int
+	}{
+		{"own private image", []AssetRef{{asset, "image/*"}}, nil, row(author, MimePNG, AccessPrivate, MediaStatusReady, false), nil, 0},
+		{"public asset of another teacher", []AssetRef{{asset, MimePDF}}, nil, row(other, MimePDF, AccessPublic, MediaStatusReady, false), nil, 0},
+		{"private asset already referenced", []AssetRef{{asset, MimePDF}}, nil, row(other, MimePDF, AccessPrivate, MediaStatusReady, true), nil, 0},
+		{"private asset of another teacher", []AssetRef{{asset, MimePDF}}, nil, row(other, MimePDF, AccessPrivate, MediaStatusReady, false), nil, http.StatusForbidden},
+		{"wrong content type", []AssetRef{{asset, "image/*"}}, nil, row(author, MimePDF, AccessPrivate, MediaStatusReady, false), nil, http.StatusBadRequest},
+		{"quarantined", []AssetRef{{asset, MimePDF}}, nil, row(author, MimePDF, AccessPrivate, MediaStatusQuarantined, false), nil, http.StatusBadRequest},
+		{"missing asset", []AssetRef{{asset, MimePDF}}, nil, nil, sql.ErrNoRows, http.StatusBadRequest},
+		{"malformed id", []AssetRef{{"not-a-uuid", MimePDF}}, nil, nil, nil, http.StatusBadRequest},
+		{"avatar must be an image", nil, ptr(" " + asset + " "), row(author, MimePDF, AccessPrivate, MediaStatusReady, false), nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			switch {
+			case tt.rows != nil:
+				mock.ExpectQuery(`FROM media_assets m\s+WHERE m.id = \$1::uuid`).WithArgs(asset, resource).WillReturnRows(tt.rows)
+			case tt.queryErr != nil:
+				mock.ExpectQuery(`FROM media_assets m`).WillReturnError(tt.queryErr)
+			}
+			err := ValidateResourceAssets(db, author, resource, tt.refs, tt.avatar)
+			wantStatus(t, err, tt.wantStatus)
+		})
+	}
+}
+
+func TestSyncResourceAssetRefs(t *testing.T) {
+	const resource = "33333333-3333-3333-3333-333333333333"
+	db, mock := newMockDB(t)
+	mock.ExpectExec(`DELETE FROM resource_asset_refs WHERE resource_id = \$1`).WithArgs(resource).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO resource_asset_refs`).WithArgs(resource, "a", AssetUsageBlock, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO resource_asset_refs`).WithArgs(resource, "b", AssetUsageAvatar, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := SyncResourceAssetRefs(db, resource, []AssetRef{{AssetID: "a"}}, ptr(" b ")); err != nil {
+		t.Fatalf("SyncResourceAssetRefs() error = %v", err)
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,621 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// videoProviders lists the hosts accepted for VIDEO blocks that point to an
+// external URL instead of an uploaded asset.
+var videoProviders = map[string]bool{
+	"youtube.com":      true,
+	"www.youtube.com":  true,
+	"youtu.be":         true,
+	"vimeo.com":        true,
+	"player.vimeo.com": true,
+}
+
+// embedHosts lists the hosts that EMBED blocks may load in an iframe.
+var embedHosts = map[string]bool{
+	"phet.colorado.edu":        true,
+	"www.geogebra.org":         true,
+	"www.desmos.com":           true,
+	"docs.google.com":          true,
+	"www.youtube.com":          true,
+	"www.youtube-nocookie.com": true,
+	"player.vimeo.com":         true,
+}
+
+// linkSchemes lists the URL schemes accepted for LINK blocks.
+var linkSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+var calloutVariants = map[string]bool{
+	"NOTE":    true,
+	"WARNING": true,
+}
+
+const (
+	maxTextLength    = 100000
+	maxTableRows     = 100
+	maxTableColumns  = 20
+	maxGalleryItems  = 50
+	maxCodeLength    = 20000
+	maxCodeLanguage  = 32
+	maxCalloutLength = 5000
+	defaultEmbedSize = 480
+)
+
+func init() {
+	RegisterBlockHandler(textBlockHandler())
+	RegisterBlockHandler(linkBlockHandler())
+	RegisterBlockHandler(mediaBlockHandler("IMAGE", "image/*"))
+	RegisterBlockHandler(mediaBlockHandler("PDF", "application/pdf"))
+	RegisterBlockHandler(formulaBlockHandler())
+	RegisterBlockHandler(videoBlockHandler())
+	RegisterBlockHandler(embedBlockHandler())
+	RegisterBlockHandler(tableBlockHandler())
+	RegisterBlockHandler(codeBlockHandler())
+	RegisterBlockHandler(calloutBlockHandler())
+	RegisterBlockHandler(galleryBlockHandler())
+}
+
+type TextBlock struct {
+	Type   string  `json:"type"`
+	Format string  `json:"format"`
+	Text   string  `json:"text"`
+	HTML   string  `json:"html"`
+	Title  *string `json:"title,omitempty"`
+}
+
+func textBlockHandler() BlockHandler {
+	return BlockHandler{
+		Type: "TEXT",
+		Schema: objectSchema([]string{"type", "text"}, map[string]interface{}{
+			"type":   constProp("TEXT"),
+			"title":  stringProp("Titlu"),
+			"format": enumProp("Format", []string{TextFormatPlain, TextFormatMarkdown}),
+			"text":   textProp("Text", maxTextLength),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block TextBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			text := strings.TrimSpace(block.Text)
+			if text == "" {
+				return nil, nil
+			}
+			if len(text) > maxTextLength {
+				return nil, ErrBadRequest("Textul este prea lung.")
+			}
+			format := strings.ToLower(strings.TrimSpace(block.Format))
+			if format == "" {
+				format = TextFormatPlain
+			}
+			if format != TextFormatPlain && format != TextFormatMarkdown {
+				return nil, ErrBadRequest("Formatul textului trebuie să fie plain sau markdown.")
+			}
+			rendered, err := RenderTextHTML(text, format)
+			if err != nil {
+				return nil, ErrBadRequest(err.Error())
+			}
+			return TextBlock{Type: "TEXT", Format: format, Text: text, HTML: rendered, Title: block.Title}, nil
+		},
+		Hydrate: func(raw json.RawMessage) interface{} {
+			var block TextBlock
+			if err := json.Unmarshal(raw, &block); err != nil || block.HTML != "" {
+				return nil
+			}
+			if block.Format == "" {
+				block.Format = TextFormatPlain
+			}
+			rendered, err := RenderTextHTML(block.Text, block.Format)
+			if err != nil {
+				return nil
+			}
+			block.HTML = rendered
+			return block
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block TextBlock
+			_ = json.Unmarshal(raw, &block)
+			return joinNonEmpty(deref(block.Title), block.Text)
+		},
+	}
+}
+
+type LinkBlock struct {
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+func linkBlockHandler() BlockHandler {
+	return BlockHandler{
+		Type: "LINK",
+		Schema: objectSchema([]string{"type", "url"}, map[string]interface{}{
+			"type":  constProp("LINK"),
+			"title": stringProp("Titlu"),
+			"url":   urlProp("Link", nil),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block LinkBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			link := strings.TrimSpace(block.URL)
+			if link == "" {
+				return nil, nil
+			}
+			parsed, err := url.Parse(link)
+			if err != nil || !linkSchemes[parsed.Scheme] {
+				return nil, ErrBadRequest("Linkul trebuie să înceapă cu http://, https:// sau mailto:.")
+			}
+			if parsed.Scheme != "mailto" && parsed.Host == "" {
+				return nil, ErrBadRequest("Linkul este invalid.")
+			}
+			title := strings.TrimSpace(block.Title)
+			if title == "" {
+				title = "Link"
+			}
+			return LinkBlock{Type: "LINK", URL: link, Title: title}, nil
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block LinkBlock
+			_ = json.Unmarshal(raw, &block)
+			return block.Title
+		},
+	}
+}
+
+type MediaBlock struct {
+	Type    string  `json:"type"`
+	AssetID string  `json:"assetId"`
+	Caption *string `json:"caption,omitempty"`
+	Title   *string `json:"title,omitempty"`
+}
+
+func mediaBlockHandler(blockType, accept string) BlockHandler {
+	return BlockHandler{
+		Type: blockType,
+		Schema: objectSchema([]string{"type", "assetId"}, map[string]interface{}{
+			"type":    constProp(blockType),
+			"title":   stringProp("Titlu"),
+			"assetId": assetProp("Fișier", accept),
+			"caption": stringProp("Descriere"),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block MediaBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			asset := strings.TrimSpace(block.AssetID)
+			if asset == "" {
+				return nil, errors.New("Încărcarea fișierului pentru blocurile media este obligatorie.")
+			}
+			return MediaBlock{Type: blockType, AssetID: asset, Caption: block.Caption, Title: block.Title}, nil
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block MediaBlock
+			_ = json.Unmarshal(raw, &block)
+			return joinNonEmpty(deref(block.Title), deref(block.Caption))
+		},
+		AssetRefs: func(raw json.RawMessage) []AssetRef {
+			var block MediaBlock
+			_ = json.Unmarshal(raw, &block)
+			return []AssetRef{{AssetID: block.AssetID, Accept: accept}}
+		},
+	}
+}
+
+type FormulaBlock struct {
+	Type   string  `json:"type"`
+	Text   string  `json:"text"`
+	Title  *string `json:"title,omitempty"`
+	MathML string  `json:"mathml,omitempty"`
+}
+
+func formulaBlockHandler() BlockHandler {
+	return BlockHandler{
+		Type: "FORMULA",
+		Schema: objectSchema([]string{"type", "text"}, map[string]interface{}{
+			"type":  constProp("FORMULA"),
+			"title": stringProp("Titlu"),
+			"text":  textProp("Formula (LaTeX)", maxFormulaLength),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block FormulaBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			text := strings.TrimSpace(block.Text)
+			if text == "" {
+				return nil, errors.New("Formula nu poate fi goală.")
+			}
+			if len(text) > maxFormulaLength {
+				return nil, ErrBadRequest("Formula este prea lungă.")
+			}
+			mathML, err := RenderMathML(text, true)
+			if err != nil {
+				return nil, ErrBadRequest(err.Error())
+			}
+			return FormulaBlock{Type: "FORMULA", Text: text, Title: block.Title, MathML: mathML}, nil
+		},
+		Hydrate: func(raw json.RawMessage) interface{} {
+			var block FormulaBlock
+			if err := json.Unmarshal(raw, &block); err != nil || block.MathML != "" {
+				return nil
+			}
+			mathML, err := RenderMathML(block.Text, true)
+			if err != nil {
+				return nil
+			}
+			block.MathML = mathML
+			return block
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block FormulaBlock
+			_ = json.Unmarshal(raw, &block)
+			return deref(block.Title)
+		},
+	}
+}
+
+type VideoBlock struct {
+	Type    string  `json:"type"`
+	AssetID *string `json:"assetId,omitempty"`
+	URL     *string `json:"url,omitempty"`
+	Caption *string `json:"caption,omitempty"`
+	Title   *string `json:"title,omitempty"`
+}
+
+func videoBlockHandler() BlockHandler {
+	return BlockHandler{
+		Type: "VIDEO",
+		Schema: objectSchema([]string{"type"}, map[string]interface{}{
+			"type":    constProp("VIDEO"),
+			"title":   stringProp("Titlu"),
+			"assetId": assetProp("Fișier video", "video/*"),
+			"url":     urlProp("Link video", videoProviders),
+			"caption": stringProp("Descriere"),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block VideoBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			asset := trimmedOrEmpty(block.AssetID)
+			link := trimmedOrEmpty(block.URL)
+			switch {
+			case asset != "" && link != "":
+				return nil, ErrBadRequest("Blocul video trebuie să conțină fie un fișier, fie un link, nu ambele.")
+			case asset != "":
+				return VideoBlock{Type: "VIDEO", AssetID: &asset, Caption: block.Caption, Title: block.Title}, nil
+			case link != "":
+				if !allowedHost(link, videoProviders) {
+					return nil, ErrBadRequest("Sursa video nu este permisă. Folosiți YouTube sau Vimeo.")
+				}
+				return VideoBlock{Type: "VIDEO", URL: &link, Caption: block.Caption, Title: block.Title}, nil
+			default:
+				return nil, ErrBadRequest("Blocul video necesită un fișier sau un link.")
+			}
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block VideoBlock
+			_ = json.Unmarshal(raw, &block)
+			return joinNonEmpty(deref(block.Title), deref(block.Caption))
+		},
+		AssetRefs: func(raw json.RawMessage) []AssetRef {
+			var block VideoBlock
+			_ = json.Unmarshal(raw, &block)
+			if block.AssetID == nil {
+				return nil
+			}
+			return []AssetRef{{AssetID: *block.AssetID, Accept: "video/*"}}
+		},
+	}
+}
+
+type EmbedBlock struct {
+	Type    string  `json:"type"`
+	URL     string  `json:"url"`
+	Height  int     `json:"height"`
+	Caption *string `json:"caption,omitempty"`
+	Title   *string `json:"title,omitempty"`
+}
+
+func embedBlockHandler() BlockHandler {
+	return BlockHandler{
+		Type: "EMBED",
+		Schema: objectSchema([]string{"type", "url"}, map[string]interface{}{
+			"type":    constProp("EMBED"),
+			"title":   stringProp("Titlu"),
+			"url":     urlProp("Link", embedHosts),
+			"height":  map[string]interface{}{"type": "integer", "title": "Înălțime", "minimum": 1, "maximum": 2000, "default": defaultEmbedSize},
+			"caption": stringProp("Descriere"),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block EmbedBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			link := strings.TrimSpace(block.URL)
+			if link == "" {
+				return nil, ErrBadRequest("Blocul embed necesită un link.")
+			}
+			if !allowedHost(link, embedHosts) {
+				return nil, ErrBadRequest("Sursa pentru embed nu este permisă.")
+			}
+			height := defaultEmbedSize
+			if block.Height > 0 {
+				height = block.Height
+				if height > 2000 {
+					height = 2000
+				}
+			}
+			return EmbedBlock{Type: "EMBED", URL: link, Height: height, Caption: block.Caption, Title: block.Title}, nil
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block EmbedBlock
+			_ = json.Unmarshal(raw, &block)
+			return joinNonEmpty(deref(block.Title), deref(block.Caption))
+		},
+	}
+}
+
+type TableBlock struct {
+	Type    string     `json:"type"`
+	Header  []string   `json:"header,omitempty"`
+	Rows    [][]string `json:"rows"`
+	Caption *string    `json:"caption,omitempty"`
+	Title   *string    `json:"title,omitempty"`
+}
+
+func tableBlockHandler() BlockHandler {
+	cells := map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "maxItems": maxTableColumns}
+	return BlockHandler{
+		Type: "TABLE",
+		Schema: objectSchema([]string{"type", "rows"}, map[string]interface{}{
+			"type":    constProp("TABLE"),
+			"title":   stringProp("Titlu"),
+			"header":  cells,
+			"rows":    map[string]interface{}{"type": "array", "items": cells, "minItems": 1, "maxItems": maxTableRows},
+			"caption": stringProp("Descriere"),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block TableBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			if len(block.Rows) == 0 {
+				return nil, ErrBadRequest("Tabelul trebuie să aibă cel puțin un rând.")
+			}
+			if len(block.Rows) > maxTableRows {
+				return nil, ErrBadRequest("Tabelul are prea multe rânduri.")
+			}
+			columns := len(block.Header)
+			if columns == 0 {
+				columns = len(block.Rows[0])
+			}
+			if columns == 0 {
+				return nil, ErrBadRequest("Tabelul trebuie să aibă cel puțin o coloană.")
+			}
+			if columns > maxTableColumns {
+				return nil, ErrBadRequest("Tabelul are prea multe coloane.")
+			}
+			var header []string
+			if len(block.Header) > 0 {
+				header = trimCells(block.Header)
+			}
+			rows := make([][]string, 0, len(block.Rows))
+			for _, row := range block.Rows {
+				if len(row) != columns {
+					return nil, ErrBadRequest("Toate rândurile tabelului trebuie să aibă același număr de coloane.")
+				}
+				rows = append(rows, trimCells(row))
+			}
+			return TableBlock{Type: "TABLE", Header: header, Rows: rows, Caption: block.Caption, Title: block.Title}, nil
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block TableBlock
+			_ = json.Unmarshal(raw, &block)
+			parts := []string{deref(block.Title), deref(block.Caption), strings.Join(block.Header, " ")}
+			for _, row := range block.Rows {
+				parts = append(parts, strings.Join(row, " "))
+			}
+			return joinNonEmpty(parts...)
+		},
+	}
+}
+
+type CodeBlock struct {
+	Type     string  `json:"type"`
+	Language string  `json:"language"`
+	Text     string  `json:"text"`
+	Title    *string `json:"title,omitempty"`
+}
+
+func codeBlockHandler() BlockHandler {
+	return BlockHandler{
+		Type: "CODE",
+		Schema: objectSchema([]string{"type", "text"}, map[string]interface{}{
+			"type":     constProp("CODE"),
+			"title":    stringProp("Titlu"),
+			"language": map[string]interface{}{"type": "string", "title": "Limbaj", "maxLength": maxCodeLanguage, "default": "text"},
+			"text":     textProp("Cod sursă", maxCodeLength),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block CodeBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			if strings.TrimSpace(block.Text) == "" {
+				return nil, ErrBadRequest("Codul nu poate fi gol.")
+			}
+			source := strings.TrimRight(block.Text, " \t\r\n")
+			if len(source) > maxCodeLength {
+				return nil, ErrBadRequest("Codul este prea lung.")
+			}
+			language := strings.ToLower(strings.TrimSpace(block.Language))
+			if language == "" {
+				language = "text"
+			}
+			if len(language) > maxCodeLanguage {
+				return nil, ErrBadRequest("Limbajul codului este invalid.")
+			}
+			return CodeBlock{Type: "CODE", Language: language, Text: source, Title: block.Title}, nil
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block CodeBlock
+			_ = json.Unmarshal(raw, &block)
+			return deref(block.Title)
+		},
+	}
+}
+
+type CalloutBlock struct {
+	Type    string  `json:"type"`
+	Variant string  `json:"variant"`
+	Text    string  `json:"text"`
+	Title   *string `json:"title,omitempty"`
+}
+
+func calloutBlockHandler() BlockHandler {
+	return BlockHandler{
+		Type: "CALLOUT",
+		Schema: objectSchema([]string{"type", "text"}, map[string]interface{}{
+			"type":    constProp("CALLOUT"),
+			"title":   stringProp("Titlu"),
+			"variant": enumProp("Tip", []string{"NOTE", "WARNING"}),
+			"text":    textProp("Text", maxCalloutLength),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block CalloutBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			text := strings.TrimSpace(block.Text)
+			if text == "" {
+				return nil, ErrBadRequest("Nota nu poate fi goală.")
+			}
+			if len(text) > maxCalloutLength {
+				return nil, ErrBadRequest("Nota este prea lungă.")
+			}
+			variant := strings.ToUpper(strings.TrimSpace(block.Variant))
+			if variant == "" {
+				variant = "NOTE"
+			}
+			if !calloutVariants[variant] {
+				return nil, ErrBadRequest("Tipul notei trebuie să fie NOTE sau WARNING.")
+			}
+			return CalloutBlock{Type: "CALLOUT", Variant: variant, Text: text, Title: block.Title}, nil
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block CalloutBlock
+			_ = json.Unmarshal(raw, &block)
+			return joinNonEmpty(deref(block.Title), block.Text)
+		},
+	}
+}
+
+type GalleryItem struct {
+	AssetID string  `json:"assetId"`
+	Caption *string `json:"caption,omitempty"`
+}
+
+type GalleryBlock struct {
+	Type    string        `json:"type"`
+	Items   []GalleryItem `json:"items"`
+	Caption *string       `json:"caption,omitempty"`
+	Title   *string       `json:"title,omitempty"`
+}
+
+func galleryBlockHandler() BlockHandler {
+	return BlockHandler{
+		Type: "GALLERY",
+		Schema: objectSchema([]string{"type", "items"}, map[string]interface{}{
+			"type":  constProp("GALLERY"),
+			"title": stringProp("Titlu"),
+			"items": map[string]interface{}{
+				"type":     "array",
+				"minItems": 1,
+				"maxItems": maxGalleryItems,
+				"items": objectSchema([]string{"assetId"}, map[string]interface{}{
+					"assetId": assetProp("Imagine", "image/*"),
+					"caption": stringProp("Descriere"),
+				}),
+			},
+			"caption": stringProp("Descriere"),
+		}),
+		Normalize: func(raw json.RawMessage) (interface{}, error) {
+			var block GalleryBlock
+			if err := decodeBlock(raw, &block); err != nil {
+				return nil, err
+			}
+			if len(block.Items) == 0 {
+				return nil, ErrBadRequest("Galeria trebuie să conțină cel puțin o imagine.")
+			}
+			if len(block.Items) > maxGalleryItems {
+				return nil, ErrBadRequest("Galeria conține prea multe imagini.")
+			}
+			items := make([]GalleryItem, 0, len(block.Items))
+			for _, item := range block.Items {
+				asset := strings.TrimSpace(item.AssetID)
+				if asset == "" {
+					return nil, errors.New("Încărcarea fișierului pentru blocurile media este obligatorie.")
+				}
+				items = append(items, GalleryItem{AssetID: asset, Caption: item.Caption})
+			}
+			return GalleryBlock{Type: "GALLERY", Items: items, Caption: block.Caption, Title: block.Title}, nil
+		},
+		SearchText: func(raw json.RawMessage) string {
+			var block GalleryBlock
+			_ = json.Unmarshal(raw, &block)
+			parts := []string{deref(block.Title), deref(block.Caption)}
+			for _, item := range block.Items {
+				parts = append(parts, deref(item.Caption))
+			}
+			return joinNonEmpty(parts...)
+		},
+		AssetRefs: func(raw json.RawMessage) []AssetRef {
+			var block GalleryBlock
+			_ = json.Unmarshal(raw, &block)
+			refs := make([]AssetRef, 0, len(block.Items))
+			for _, item := range block.Items {
+				refs = append(refs, AssetRef{AssetID: item.AssetID, Accept: "image/*"})
+			}
+			return refs
+		},
+	}
+}
+
+func allowedHost(raw string, hosts map[string]bool) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme != "https" {
+		return false
+	}
+	return hosts[strings.ToLower(parsed.Hostname())]
+}
+
+func joinNonEmpty(values ...string) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		if trimmed := strings.TrimSpace(value); trimmed != "" {
+			parts = append(parts, trimmed)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func deref(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
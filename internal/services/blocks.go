@@ -0,0 +1,258 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// BlockHandler describes one resource block type. Normalize receives the raw
+// block JSON and returns the value to store, or nil when the block should be
+// dropped (for example an empty TEXT block). SearchText, AssetRefs and Hydrate
+// are optional and operate on the normalized JSON. Hydrate fills derived
+// fields for blocks stored before those fields existed and returns nil when
+// the block is already complete.
+type BlockHandler struct {
+	Type       string
+	Schema     map[string]interface{}
+	Normalize  func(raw json.RawMessage) (interface{}, error)
+	SearchText func(raw json.RawMessage) string
+	AssetRefs  func(raw json.RawMessage) []AssetRef
+	Hydrate    func(raw json.RawMessage) interface{}
+}
+
+type blockRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]BlockHandler
+}
+
+var blocks = &blockRegistry{handlers: map[string]BlockHandler{}}
+
+// RegisterBlockHandler adds or replaces the handler for handler.Type.
+func RegisterBlockHandler(handler BlockHandler) {
+	blockType := strings.ToUpper(strings.TrimSpace(handler.Type))
+	if blockType == "" || handler.Normalize == nil {
+		panic("block handler requires a type and a normalizer")
+	}
+	handler.Type = blockType
+	blocks.mu.Lock()
+	blocks.handlers[blockType] = handler
+	blocks.mu.Unlock()
+}
+
+// LookupBlockHandler returns the handler registered for blockType.
+func LookupBlockHandler(blockType string) (BlockHandler, bool) {
+	blocks.mu.RLock()
+	defer blocks.mu.RUnlock()
+	handler, ok := blocks.handlers[strings.ToUpper(strings.TrimSpace(blockType))]
+	return handler, ok
+}
+
+// BlockHandlers returns every registered handler ordered by type.
+func BlockHandlers() []BlockHandler {
+	blocks.mu.RLock()
+	items := make([]BlockHandler, 0, len(blocks.handlers))
+	for _, handler := range blocks.handlers {
+		items = append(items, handler)
+	}
+	blocks.mu.RUnlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].Type < items[j].Type })
+	return items
+}
+
+type blockEnvelope struct {
+	Type string `json:"type"`
+}
+
+func ValidateBlocks(raw json.RawMessage) ([]json.RawMessage, error) {
+	if len(raw) == 0 {
+		return []json.RawMessage{}, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+	cleaned := make([]json.RawMessage, 0, len(items))
+	for _, item := range items {
+		var envelope blockEnvelope
+		if err := json.Unmarshal(item, &envelope); err != nil {
+			return nil, ErrBadRequest("Bloc invalid.")
+		}
+		handler, ok := LookupBlockHandler(envelope.Type)
+		if !ok {
+			return nil, ErrBadRequest("Tip de bloc necunoscut: " + envelope.Type)
+		}
+		normalized, err := handler.Normalize(item)
+		if err != nil {
+			return nil, err
+		}
+		if normalized == nil {
+			continue
+		}
+		encoded, err := json.Marshal(normalized)
+		if err != nil {
+			return nil, err
+		}
+		cleaned = append(cleaned, encoded)
+	}
+	return cleaned, nil
+}
+
+// BlockSearchText concatenates the searchable text of already normalized
+// blocks.
+func BlockSearchText(items []json.RawMessage) string {
+	parts := []string{}
+	for _, item := range items {
+		handler, ok := handlerFor(item)
+		if !ok || handler.SearchText == nil {
+			continue
+		}
+		if text := strings.TrimSpace(handler.SearchText(item)); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// AssetRef is a media asset referenced by a block together with the content
+// type it must have, e.g. "image/*" or "application/pdf".
+type AssetRef struct {
+	AssetID string
+	Accept  string
+}
+
+// BlockAssetRefs returns the distinct media assets referenced by already
+// normalized blocks.
+func BlockAssetRefs(items []json.RawMessage) []AssetRef {
+	seen := map[AssetRef]bool{}
+	refs := []AssetRef{}
+	for _, item := range items {
+		handler, ok := handlerFor(item)
+		if !ok || handler.AssetRefs == nil {
+			continue
+		}
+		for _, ref := range handler.AssetRefs(item) {
+			if ref.AssetID == "" || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// HydrateBlocks prepares stored resource content for output, filling derived
+// fields that older rows may be missing. Content that cannot be parsed is
+// returned unchanged.
+func HydrateBlocks(content []byte) json.RawMessage {
+	var items []json.RawMessage
+	if err := json.Unmarshal(content, &items); err != nil {
+		return json.RawMessage(content)
+	}
+	changed := false
+	for i, item := range items {
+		handler, ok := handlerFor(item)
+		if !ok || handler.Hydrate == nil {
+			continue
+		}
+		hydrated := handler.Hydrate(item)
+		if hydrated == nil {
+			continue
+		}
+		encoded, err := json.Marshal(hydrated)
+		if err != nil {
+			continue
+		}
+		items[i] = encoded
+		changed = true
+	}
+	if !changed {
+		return json.RawMessage(content)
+	}
+	encoded, err := json.Marshal(items)
+	if err != nil {
+		return json.RawMessage(content)
+	}
+	return encoded
+}
+
+func handlerFor(item json.RawMessage) (BlockHandler, bool) {
+	var envelope blockEnvelope
+	if err := json.Unmarshal(item, &envelope); err != nil {
+		return BlockHandler{}, false
+	}
+	return LookupBlockHandler(envelope.Type)
+}
+
+func decodeBlock(raw json.RawMessage, target interface{}) error {
+	if err := json.Unmarshal(raw, target); err != nil {
+		return errors.New("Bloc invalid.")
+	}
+	return nil
+}
+
+func trimCells(cells []string) []string {
+	trimmed := make([]string, 0, len(cells))
+	for _, cell := range cells {
+		trimmed = append(trimmed, strings.TrimSpace(cell))
+	}
+	return trimmed
+}
+
+func trimmedOrEmpty(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return strings.TrimSpace(*value)
+}
+
+func objectSchema(required []string, properties map[string]interface{}) map[string]interface{} {
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func constProp(value string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "const": value}
+}
+
+func stringProp(title string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "title": title}
+}
+
+func textProp(title string, maxLength int) map[string]interface{} {
+	prop := map[string]interface{}{"type": "string", "title": title, "format": "textarea"}
+	if maxLength > 0 {
+		prop["maxLength"] = maxLength
+	}
+	return prop
+}
+
+func enumProp(title string, values []string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "title": title, "enum": values}
+}
+
+func assetProp(title, accept string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "title": title, "format": "asset", "accept": accept}
+}
+
+func urlProp(title string, hosts map[string]bool) map[string]interface{} {
+	prop := map[string]interface{}{"type": "string", "title": title, "format": "uri"}
+	if len(hosts) > 0 {
+		allowed := make([]string, 0, len(hosts))
+		for host := range hosts {
+			allowed = append(allowed, host)
+		}
+		sort.Strings(allowed)
+		prop["allowedHosts"] = allowed
+	}
+	return prop
+}
@@ -27,6 +27,14 @@ func ErrUnauthorized(msg string) error {
 	return ServiceError{Status: 401, Message: msg}
 }
 
+func ErrTooLarge(msg string) error {
+	return ServiceError{Status: 413, Message: msg}
+}
+
+func ErrUnsupportedMediaType(msg string) error {
+	return ServiceError{Status: 415, Message: msg}
+}
+
 func WrapError(err error, msg string) error {
 	if err == nil {
 		return nil
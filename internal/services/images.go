@@ -0,0 +1,451 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ImageVariantWidths are the responsive widths generated for uploaded images.
+var ImageVariantWidths = []int{160, 480, 1200}
+
+// Decoding and rotating an image takes up to about 12 bytes per pixel (the
+// decoded image plus two RGBA copies), so maxImagePixels and
+// maxConcurrentImageJobs together bound the memory spent on uploads to roughly
+// 600 MB. 25 MP covers phone and most camera photos.
+const (
+	variantJPEGQuality     = 82
+	reencodeJPEGQuality    = 92
+	maxImagePixels         = 25_000_000
+	maxConcurrentImageJobs = 2
+)
+
+// imageJobs limits how many uploads decode and re-encode images at once.
+var imageJobs = make(chan struct{}, maxConcurrentImageJobs)
+
+type ImageVariant struct {
+	Width       int    `db:"width"`
+	Height      int    `db:"height"`
+	ContentType string `db:"content_type"`
+	StorageKey  string `db:"storage_key"`
+	SizeBytes   int64  `db:"size_bytes"`
+}
+
+type encodedVariant struct {
+	width  int
+	height int
+	data   []byte
+}
+
+// errImageStructure is returned by the metadata strippers when a file does
+// not have the container layout they expect, so its metadata cannot be
+// removed safely in place.
+var errImageStructure = errors.New("unexpected image structure")
+
+// processImage strips EXIF and other embedded metadata (including GPS) from an
+// uploaded image and renders its resized JPEG variants. JPEG files with a
+// non-default EXIF orientation are re-encoded upright so that dropping the
+// orientation tag does not rotate them. JPEG and PNG files whose structure
+// the strippers do not understand are re-encoded from their pixels instead;
+// such WebP files are refused, since they cannot be re-encoded.
+func processImage(data []byte, contentType string) ([]byte, image.Config, []encodedVariant, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, image.Config{}, nil, ErrBadRequest("Imaginea nu poate fi citită.")
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, image.Config{}, nil, ErrBadRequest("Imaginea are dimensiuni prea mari.")
+	}
+	imageJobs <- struct{}{}
+	defer func() { <-imageJobs }()
+	var img image.Image
+	switch contentType {
+	case MimeJPEG:
+		if orientation := jpegOrientation(data); orientation > 1 {
+			if img, err = decodeImage(data); err != nil {
+				return nil, image.Config{}, nil, err
+			}
+			img = applyOrientation(img, orientation)
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: reencodeJPEGQuality}); err != nil {
+				return nil, image.Config{}, nil, err
+			}
+			data = buf.Bytes()
+			cfg.Width, cfg.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		} else if stripped, err := stripJPEGMetadata(data); err == nil {
+			data = stripped
+		} else if data, img, err = reencodeImage(data, MimeJPEG); err != nil {
+			return nil, image.Config{}, nil, err
+		}
+	case MimePNG:
+		if stripped, err := stripPNGMetadata(data); err == nil {
+			data = stripped
+		} else if data, img, err = reencodeImage(data, MimePNG); err != nil {
+			return nil, image.Config{}, nil, err
+		}
+	case MimeWEBP:
+		if data, err = stripWebPMetadata(data); err != nil {
+			return nil, image.Config{}, nil, ErrBadRequest("Imaginea nu poate fi citită.")
+		}
+	case MimeGIF:
+		// GIF has no EXIF block; animated files are kept without variants.
+		return data, cfg, nil, nil
+	}
+	if img == nil {
+		if img, err = decodeImage(data); err != nil {
+			// The original is still usable; it is just served without variants.
+			return data, cfg, nil, nil
+		}
+	}
+	variants, err := renderVariants(img)
+	if err != nil {
+		return nil, image.Config{}, nil, err
+	}
+	return data, cfg, variants, nil
+}
+
+// reencodeImage decodes data and encodes its pixels again as contentType,
+// which leaves every metadata block behind.
+func reencodeImage(data []byte, contentType string) ([]byte, image.Image, error) {
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	if contentType == MimePNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: reencodeJPEGQuality})
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), img, nil
+}
+
+func decodeImage(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrBadRequest("Imaginea nu poate fi citită.")
+	}
+	return img, nil
+}
+
+// renderVariants scales img down to each width in ImageVariantWidths that is
+// smaller than the original. Transparent areas are flattened onto white.
+// Variants are JPEG only: golang.org/x/image decodes WebP but cannot encode
+// it, and the pure Go encoders write lossless WebP, which is larger than JPEG
+// for photos. Lossy WebP would need cgo and libwebp in every build.
+func renderVariants(img image.Image) ([]encodedVariant, error) {
+	bounds := img.Bounds()
+	variants := []encodedVariant{}
+	for _, width := range ImageVariantWidths {
+		if width >= bounds.Dx() {
+			break
+		}
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: variantJPEGQuality}); err != nil {
+			return nil, err
+		}
+		variants = append(variants, encodedVariant{width: width, height: height, data: buf.Bytes()})
+	}
+	return variants, nil
+}
+
+// storeImageVariants uploads rendered variants next to the parent asset and
+// records them in media_asset_variants.
+func storeImageVariants(db *sqlx.DB, store ObjectStorage, bucket, assetID string, variants []encodedVariant) error {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for _, variant := range variants {
+		key := fmt.Sprintf("%s_w%d.jpg", assetID, variant.width)
+		if err := store.Put(ctx, bucket, key, bytes.NewReader(variant.data), int64(len(variant.data)), MimeJPEG); err != nil {
+			return err
+		}
+		_, err := db.Exec(`
+INSERT INTO media_asset_variants (asset_id, width, height, content_type, storage_key, size_bytes, created_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7)
+ON CONFLICT (asset_id, width) DO NOTHING
+`, assetID, variant.width, variant.height, MimeJPEG, key, len(variant.data), now)
+		if err != nil {
+			_ = store.Delete(ctx, bucket, key)
+			return err
+		}
+	}
+	return nil
+}
+
+// PickImageVariant returns the smallest variant at least width pixels wide, or
+// nil when the original should be served.
+func PickImageVariant(db *sqlx.DB, assetID string, width int) (*ImageVariant, error) {
+	variants := []ImageVariant{}
+	if !validUUID(assetID) {
+		return nil, nil
+	}
+	err := db.Select(&variants, `
+SELECT width, height, content_type, storage_key, size_bytes
+FROM media_asset_variants
+WHERE asset_id = $1::uuid AND width >= $2
+ORDER BY width
+LIMIT 1
+`, assetID, width)
+	if err != nil || len(variants) == 0 {
+		return nil, err
+	}
+	return &variants[0], nil
+}
+
+// ImageVariantWidthsFor lists the variant widths stored for an asset.
+func ImageVariantWidthsFor(db *sqlx.DB, assetID string) ([]int, error) {
+	widths := []int{}
+	if !validUUID(assetID) {
+		return widths, nil
+	}
+	err := db.Select(&widths, `SELECT width FROM media_asset_variants WHERE asset_id = $1::uuid ORDER BY width`, assetID)
+	return widths, err
+}
+
+// ImageVariantWidthsForAssets lists the variant widths of several assets in
+// one query, keyed by asset ID. Assets without variants are left out.
+func ImageVariantWidthsForAssets(db *sqlx.DB, assetIDs []string) (map[string][]int, error) {
+	widths := map[string][]int{}
+	ids := make([]string, 0, len(assetIDs))
+	for _, id := range assetIDs {
+		if validUUID(id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return widths, nil
+	}
+	rows := []struct {
+		AssetID string `db:"asset_id"`
+		Width   int    `db:"width"`
+	}{}
+	err := db.Select(&rows, `
+SELECT asset_id, width FROM media_asset_variants
+WHERE asset_id = ANY($1::uuid[])
+ORDER BY asset_id, width
+`, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		widths[row.AssetID] = append(widths[row.AssetID], row.Width)
+	}
+	return widths, nil
+}
+
+func imageVariantKeys(db *sqlx.DB, assetID string) []string {
+	keys := []string{}
+	_ = db.Select(&keys, `SELECT storage_key FROM media_asset_variants WHERE asset_id = $1`, assetID)
+	return keys
+}
+
+// stripJPEGMetadata drops APP1 (EXIF/XMP) and APP13 (IPTC) segments.
+func stripJPEGMetadata(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errImageStructure
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, errImageStructure
+		}
+		marker := data[pos+1]
+		if marker == 0xDA {
+			// Start of scan: the rest is entropy-coded image data.
+			return append(out, data[pos:]...), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errImageStructure
+		}
+		if marker != 0xE1 && marker != 0xED {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return nil, errImageStructure
+}
+
+// jpegOrientation reads the EXIF orientation tag, returning 1 when absent.
+func jpegOrientation(data []byte) int {
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if marker == 0xDA || length < 2 || end > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			if orientation, err := exifOrientation(segment[6:]); err == nil {
+				return orientation
+			}
+			return 1
+		}
+		pos = end
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) (int, error) {
+	if len(tiff) < 8 {
+		return 0, errors.New("short exif")
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, errors.New("bad byte order")
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0, errors.New("bad ifd offset")
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value >= 1 && value <= 8 {
+				return value, nil
+			}
+			break
+		}
+	}
+	return 1, nil
+}
+
+// applyOrientation rotates/flips img according to an EXIF orientation value.
+// The image is converted to RGBA once and then moved four bytes per pixel.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		row := src.Pix[y*src.Stride : y*src.Stride+w*4]
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+			offset := dy*dst.Stride + dx*4
+			copy(dst.Pix[offset:offset+4], row[x*4:x*4+4])
+		}
+	}
+	return dst
+}
+
+// stripPNGMetadata drops eXIf and textual chunks.
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, errImageStructure
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, signature...)
+	pos := len(signature)
+	for pos+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		end := pos + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errImageStructure
+		}
+		chunkType := string(data[pos+4 : pos+8])
+		switch chunkType {
+		case "eXIf", "tEXt", "iTXt", "zTXt":
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+		if chunkType == "IEND" {
+			return out, nil
+		}
+	}
+	return nil, errImageStructure
+}
+
+// stripWebPMetadata drops the EXIF and XMP chunks of an extended WebP file and
+// clears the matching VP8X flags.
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errImageStructure
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	pos := 12
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := pos + 8 + size + size%2
+		if end > len(data) {
+			return nil, errImageStructure
+		}
+		chunk := data[pos:end]
+		switch string(chunk[:4]) {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			copied := append([]byte(nil), chunk...)
+			if len(copied) > 8 {
+				copied[8] &^= 0x08 | 0x04
+			}
+			out = append(out, copied...)
+		default:
+			out = append(out, chunk...)
+		}
+		pos = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
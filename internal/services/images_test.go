@@ -0,0 +1,156 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestApplyOrientation(t *testing.T) {
+	// src is 3x2: the top-left pixel is red and the top-right one is blue.
+	src := image.NewNRGBA(image.Rect(10, 20, 13, 22))
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	src.Set(10, 20, red)
+	src.Set(12, 20, blue)
+	tests := []struct {
+		orientation   int
+		width, height int
+		red, blue     image.Point
+	}{
+		{2, 3, 2, image.Pt(2, 0), image.Pt(0, 0)},
+		{3, 3, 2, image.Pt(2, 1), image.Pt(0, 1)},
+		{4, 3, 2, image.Pt(0, 1), image.Pt(2, 1)},
+		{5, 2, 3, image.Pt(0, 0), image.Pt(0, 2)},
+		{6, 2, 3, image.Pt(1, 0), image.Pt(1, 2)},
+		{7, 2, 3, image.Pt(1, 2), image.Pt(1, 0)},
+		{8, 2, 3, image.Pt(0, 2), image.Pt(0, 0)},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		if got.Bounds() != image.Rect(0, 0, tt.width, tt.height) {
+			t.Errorf("orientation %d: bounds = %v, want %dx%d", tt.orientation, got.Bounds(), tt.width, tt.height)
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.red.X, tt.red.Y)); c != red {
+			t.Errorf("orientation %d: pixel at %v = %v, want red", tt.orientation, tt.red, c)
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.blue.X, tt.blue.Y)); c != blue {
+			t.Errorf("orientation %d: pixel at %v = %v, want blue", tt.orientation, tt.blue, c)
+		}
+	}
+}
+
+func TestProcessImageStripsOrRejects(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	var jpegBuf, pngBuf bytes.Buffer
+	if err := jpeg.Encode(&jpegBuf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngBuf, img); err != nil {
+		t.Fatal(err)
+	}
+	plainJPEG, plainPNG := jpegBuf.Bytes(), pngBuf.Bytes()
+	secret := []byte("GPS 47.0N 28.8E")
+
+	exifSegment := append([]byte{0xFF, 0xE1, 0x00, byte(2 + len(secret))}, secret...)
+	withExif := append(append(append([]byte{}, plainJPEG[:2]...), exifSegment...), plainJPEG[2:]...)
+	// A stray byte between segments breaks the marker walk.
+	brokenJPEG := append(append(append([]byte{}, withExif[:2+len(exifSegment)]...), 0x00), withExif[2+len(exifSegment):]...)
+
+	// A tEXt chunk after IEND is outside the chunk walk.
+	textChunk := append(append([]byte{0, 0, 0, byte(len(secret)), 't', 'E', 'X', 't'}, secret...), 0, 0, 0, 0)
+	trailingPNG := append(append([]byte{}, plainPNG...), textChunk...)
+	truncatedPNG := append(append([]byte{}, plainPNG[:len(plainPNG)-12]...), textChunk...)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		wantErr     bool
+	}{
+		{"jpeg with exif", withExif, MimeJPEG, false},
+		{"jpeg with unexpected bytes is re-encoded", brokenJPEG, MimeJPEG, false},
+		{"png with data after IEND", trailingPNG, MimePNG, false},
+		{"png without IEND is rejected", truncatedPNG, MimePNG, true},
+		{"webp that cannot be walked", []byte("RIFF\x10\x00\x00\x00WEBPVP8X\xff\xff\xff\x00"), MimeWEBP, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _, _, err := processImage(tt.data, tt.contentType)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("processImage() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("processImage() error = %v", err)
+			}
+			if bytes.Contains(data, secret) {
+				t.Error("processImage() kept the metadata")
+			}
+			if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+				t.Errorf("processed image does not decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestProcessImageRejectsHugeImages(t *testing.T) {
+	// Only the header is read, so a PNG claiming 6000x5000 pixels is refused
+	// before anything is decoded.
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 6000)
+	binary.BigEndian.PutUint32(data[20:], 5000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, _, _, err := processImage(data, MimePNG)
+	wantStatus(t, err, http.StatusBadRequest)
+	if !strings.Contains(err.Error(), "dimensiuni prea mari") {
+		t.Errorf("error = %v, want the size error", err)
+	}
+	if len(imageJobs) != 0 {
+		t.Errorf("%d image job slots still taken", len(imageJobs))
+	}
+}
+
+func TestImageVariantWidthsForAssets(t *testing.T) {
+	const (
+		first  = "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"
+		second = "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb"
+	)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`WHERE asset_id = ANY\(\$1::uuid\[\]\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"asset_id", "width"}).
+			AddRow(first, 160).AddRow(first, 480).AddRow(second, 160))
+
+	widths, err := ImageVariantWidthsForAssets(db, []string{first, "avatar.png", second})
+	if err != nil {
+		t.Fatalf("ImageVariantWidthsForAssets() error = %v", err)
+	}
+	want := map[string][]int{first: {160, 480}, second: {160}}
+	if !reflect.DeepEqual(widths, want) {
+		t.Errorf("widths = %v, want %v", widths, want)
+	}
+
+	// Without valid IDs there is nothing to query.
+	widths, err = ImageVariantWidthsForAssets(db, []string{"", "avatar.png"})
+	if err != nil || len(widths) != 0 {
+		t.Errorf("ImageVariantWidthsForAssets(invalid) = %v, %v; want empty", widths, err)
+	}
+}
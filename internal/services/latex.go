@@ -0,0 +1,627 @@
+package services
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+const (
+	maxFormulaLength = 4000
+	maxFormulaDepth  = 64
+)
+
+// LatexError reports a LaTeX syntax error at a 1-based character position.
+type LatexError struct {
+	Pos     int
+	Message string
+}
+
+func (e LatexError) Error() string {
+	return fmt.Sprintf("Formula este invalidă la poziția %d: %s", e.Pos, e.Message)
+}
+
+// ValidateLatex parses src and returns the first syntax error, if any.
+func ValidateLatex(src string) error {
+	_, err := parseLatex(src)
+	return err
+}
+
+// RenderMathML converts a LaTeX math expression to a MathML <math> element.
+func RenderMathML(src string, display bool) (string, error) {
+	root, err := parseLatex(src)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(`<math xmlns="http://www.w3.org/1998/Math/MathML"`)
+	if display {
+		b.WriteString(` display="block"`)
+	}
+	b.WriteString(`><semantics>`)
+	root.writeMathML(&b)
+	b.WriteString(`<annotation encoding="application/x-tex">`)
+	b.WriteString(html.EscapeString(src))
+	b.WriteString(`</annotation></semantics></math>`)
+	return b.String(), nil
+}
+
+type mathKind int
+
+const (
+	mathRow mathKind = iota
+	mathIdent
+	mathNumber
+	mathOperator
+	mathText
+	mathSpace
+	mathFrac
+	mathSqrt
+	mathScripts
+	mathUnderOver
+	mathAccent
+	mathStyled
+)
+
+type mathNode struct {
+	kind     mathKind
+	value    string
+	variant  string
+	children []*mathNode
+}
+
+func (n *mathNode) writeMathML(b *strings.Builder) {
+	switch n.kind {
+	case mathRow:
+		b.WriteString("<mrow>")
+		for _, child := range n.children {
+			child.writeMathML(b)
+		}
+		b.WriteString("</mrow>")
+	case mathIdent:
+		writeToken(b, "mi", n.value, n.variant)
+	case mathNumber:
+		writeToken(b, "mn", n.value, "")
+	case mathOperator:
+		writeToken(b, "mo", n.value, "")
+	case mathText:
+		writeToken(b, "mtext", n.value, n.variant)
+	case mathSpace:
+		b.WriteString(`<mspace width="` + n.value + `"/>`)
+	case mathFrac:
+		writeElement(b, "mfrac", n.children)
+	case mathSqrt:
+		if len(n.children) == 2 {
+			writeElement(b, "mroot", n.children)
+		} else {
+			writeElement(b, "msqrt", n.children)
+		}
+	case mathScripts, mathUnderOver:
+		base, sub, sup := n.children[0], n.children[1], n.children[2]
+		tags := [3]string{"msub", "msup", "msubsup"}
+		if n.kind == mathUnderOver {
+			tags = [3]string{"munder", "mover", "munderover"}
+		}
+		switch {
+		case sub != nil && sup != nil:
+			writeElement(b, tags[2], []*mathNode{base, sub, sup})
+		case sub != nil:
+			writeElement(b, tags[0], []*mathNode{base, sub})
+		default:
+			writeElement(b, tags[1], []*mathNode{base, sup})
+		}
+	case mathAccent:
+		b.WriteString(`<mover accent="true">`)
+		n.children[0].writeMathML(b)
+		writeToken(b, "mo", n.value, "")
+		b.WriteString("</mover>")
+	case mathStyled:
+		b.WriteString(`<mstyle mathvariant="` + n.variant + `">`)
+		for _, child := range n.children {
+			child.writeMathML(b)
+		}
+		b.WriteString("</mstyle>")
+	}
+}
+
+func writeToken(b *strings.Builder, tag, value, variant string) {
+	b.WriteString("<" + tag)
+	if variant != "" {
+		b.WriteString(` mathvariant="` + variant + `"`)
+	}
+	b.WriteString(">")
+	b.WriteString(html.EscapeString(value))
+	b.WriteString("</" + tag + ">")
+}
+
+func writeElement(b *strings.Builder, tag string, children []*mathNode) {
+	b.WriteString("<" + tag + ">")
+	for _, child := range children {
+		child.writeMathML(b)
+	}
+	b.WriteString("</" + tag + ">")
+}
+
+var latexGreek = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+	"varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+	"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+}
+
+var latexIdentSymbols = map[string]string{
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ", "emptyset": "∅",
+	"degree": "°", "angle": "∠", "triangle": "△",
+}
+
+var latexOperators = map[string]string{
+	"cdot": "⋅", "times": "×", "div": "÷", "pm": "±", "mp": "∓", "ast": "∗", "circ": "∘",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈",
+	"equiv": "≡", "sim": "∼", "simeq": "≃", "propto": "∝", "ll": "≪", "gg": "≫",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺",
+	"in": "∈", "notin": "∉", "subset": "⊂", "subseteq": "⊆", "cup": "∪", "cap": "∩",
+	"forall": "∀", "exists": "∃", "perp": "⊥", "parallel": "∥", "mid": "∣",
+	"ldots": "…", "cdots": "⋯", "dots": "…", "vdots": "⋮", "ddots": "⋱",
+	"langle": "⟨", "rangle": "⟩", "lbrace": "{", "rbrace": "}", "vert": "|", "Vert": "‖",
+	"{": "{", "}": "}", "|": "‖",
+}
+
+var latexLargeOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+}
+
+var latexFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "tg": true, "cot": true, "ctg": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "arctg": true, "sinh": true, "cosh": true, "tanh": true,
+	"log": true, "ln": true, "lg": true, "exp": true, "lim": true, "max": true, "min": true,
+	"sup": true, "inf": true, "det": true, "dim": true, "deg": true, "gcd": true,
+}
+
+var latexLimitFunctions = map[string]bool{"lim": true, "max": true, "min": true, "sup": true, "inf": true}
+
+var latexAccents = map[string]string{
+	"vec": "→", "hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "dot": "˙",
+	"ddot": "¨", "tilde": "~", "widetilde": "~",
+}
+
+var latexStyles = map[string]string{
+	"mathrm": "normal", "mathbf": "bold", "mathit": "italic", "mathcal": "script",
+	"mathbb": "double-struck", "boldsymbol": "bold-italic",
+}
+
+var latexTextCommands = map[string]bool{"text": true, "textrm": true, "mbox": true, "operatorname": true}
+
+var latexSpaces = map[string]string{
+	",": "0.1667em", ":": "0.2222em", ";": "0.2778em", " ": "0.25em", "!": "-0.1667em",
+	"quad": "1em", "qquad": "2em",
+}
+
+var latexFracs = map[string]bool{"frac": true, "dfrac": true, "tfrac": true}
+
+var latexDelimiters = map[string]string{
+	"(": "(", ")": ")", "[": "[", "]": "]", "|": "|", "/": "/", ".": "",
+	`\{`: "{", `\}`: "}", `\|`: "‖", `\langle`: "⟨", `\rangle`: "⟩", `\lbrace`: "{", `\rbrace`: "}",
+	`\vert`: "|", `\Vert`: "‖",
+}
+
+type latexParser struct {
+	src   []rune
+	pos   int
+	depth int
+}
+
+func parseLatex(src string) (*mathNode, error) {
+	runes := []rune(strings.TrimSpace(src))
+	if len(runes) == 0 {
+		return nil, LatexError{Pos: 1, Message: "formula este goală"}
+	}
+	if len(runes) > maxFormulaLength {
+		return nil, LatexError{Pos: maxFormulaLength, Message: "formula este prea lungă"}
+	}
+	p := &latexParser{src: runes}
+	root, err := p.parseRow(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.atCommand("right") {
+		return nil, p.errorf("\\right fără \\left corespunzător")
+	}
+	if p.pos < len(p.src) {
+		return nil, p.errorf("acoladă „}” fără pereche")
+	}
+	return root, nil
+}
+
+func (p *latexParser) errorf(format string, args ...interface{}) error {
+	return LatexError{Pos: p.pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *latexParser) peek() rune {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *latexParser) skipSpaces() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// parseRow reads atoms until the end of input, a closing brace, a closing
+// bracket when stop is ']', or a \right command.
+func (p *latexParser) parseRow(stop rune) (*mathNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxFormulaDepth {
+		return nil, p.errorf("formula este imbricată prea adânc")
+	}
+	row := &mathNode{kind: mathRow}
+	for {
+		p.skipSpaces()
+		ch := p.peek()
+		if ch == 0 || ch == '}' || (stop != 0 && ch == stop) || p.atCommand("right") {
+			return row, nil
+		}
+		atom, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		if atom != nil {
+			row.children = append(row.children, atom)
+		}
+	}
+}
+
+func (p *latexParser) parseAtom() (*mathNode, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	// Primes fold into the superscript as in TeX: x'' is x with ″ and x'^2
+	// is x with ′2. Only a prime after an explicit ^ is a second exponent.
+	var sub, sup *mathNode
+	primes := 0
+	for {
+		p.skipSpaces()
+		ch := p.peek()
+		if ch != '^' && ch != '_' && ch != '\'' {
+			break
+		}
+		if ch == '\'' {
+			if sup != nil {
+				return nil, p.errorf("exponent dublu")
+			}
+			p.pos++
+			primes++
+			continue
+		}
+		if ch == '^' && sup != nil {
+			return nil, p.errorf("exponent dublu; folosiți acolade")
+		}
+		if ch == '_' && sub != nil {
+			return nil, p.errorf("indice dublu; folosiți acolade")
+		}
+		p.pos++
+		arg, err := p.parseArgument()
+		if err != nil {
+			return nil, err
+		}
+		if ch == '^' {
+			sup = arg
+		} else {
+			sub = arg
+		}
+	}
+	if primes > 0 {
+		prime := &mathNode{kind: mathOperator, value: strings.Repeat("′", primes)}
+		if sup == nil {
+			sup = prime
+		} else {
+			sup = &mathNode{kind: mathRow, children: []*mathNode{prime, sup}}
+		}
+	}
+	if sub == nil && sup == nil {
+		return base, nil
+	}
+	if base == nil {
+		base = &mathNode{kind: mathRow}
+	}
+	kind := mathScripts
+	if base.kind == mathOperator && base.variant == "limits" {
+		kind = mathUnderOver
+	}
+	return &mathNode{kind: kind, children: []*mathNode{base, sub, sup}}, nil
+}
+
+// parseArgument reads a single-token or braced argument, as used by
+// superscripts, subscripts and command parameters.
+func (p *latexParser) parseArgument() (*mathNode, error) {
+	p.skipSpaces()
+	ch := p.peek()
+	switch {
+	case ch == 0:
+		return nil, p.errorf("lipsește argumentul")
+	case ch == '{':
+		return p.parseGroup()
+	case ch == '}':
+		return nil, p.errorf("lipsește argumentul")
+	case ch == '^' || ch == '_':
+		return nil, p.errorf("argument invalid „%c”", ch)
+	case unicode.IsDigit(ch):
+		p.pos++
+		return &mathNode{kind: mathNumber, value: string(ch)}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *latexParser) parseGroup() (*mathNode, error) {
+	open := p.pos
+	p.pos++
+	row, err := p.parseRow(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != '}' {
+		if p.atCommand("right") {
+			return nil, p.errorf("\\right fără \\left corespunzător")
+		}
+		return nil, LatexError{Pos: open + 1, Message: "acoladă „{” neînchisă"}
+	}
+	p.pos++
+	return row, nil
+}
+
+func (p *latexParser) parsePrimary() (*mathNode, error) {
+	p.skipSpaces()
+	ch := p.peek()
+	switch {
+	case ch == '{':
+		return p.parseGroup()
+	case ch == '\\':
+		return p.parseCommand()
+	case ch == '^' || ch == '_':
+		return nil, nil
+	case unicode.IsDigit(ch) || ch == '.' && p.pos+1 < len(p.src) && unicode.IsDigit(p.src[p.pos+1]):
+		start := p.pos
+		for p.pos < len(p.src) && (unicode.IsDigit(p.src[p.pos]) || p.src[p.pos] == '.' || p.src[p.pos] == ',' && p.pos+1 < len(p.src) && unicode.IsDigit(p.src[p.pos+1])) {
+			p.pos++
+		}
+		return &mathNode{kind: mathNumber, value: string(p.src[start:p.pos])}, nil
+	case unicode.IsLetter(ch):
+		p.pos++
+		return &mathNode{kind: mathIdent, value: string(ch)}, nil
+	case strings.ContainsRune("+-=<>,;:!?()[]|/*.", ch):
+		p.pos++
+		value := string(ch)
+		if ch == '-' {
+			value = "−"
+		}
+		return &mathNode{kind: mathOperator, value: value}, nil
+	case ch == '~':
+		p.pos++
+		return &mathNode{kind: mathSpace, value: "0.25em"}, nil
+	case ch == '$':
+		return nil, p.errorf("nu includeți delimitatorii „$” în formulă")
+	case ch == '&' || ch == '#' || ch == '%':
+		return nil, p.errorf("caracter nepermis „%c”", ch)
+	}
+	return nil, p.errorf("caracter neașteptat „%c”", ch)
+}
+
+func (p *latexParser) atCommand(name string) bool {
+	if p.peek() != '\\' {
+		return false
+	}
+	end := p.pos + 1
+	for end < len(p.src) && isLatexLetter(p.src[end]) {
+		end++
+	}
+	return string(p.src[p.pos+1:end]) == name
+}
+
+func (p *latexParser) readCommandName() string {
+	p.pos++
+	if p.pos >= len(p.src) {
+		return ""
+	}
+	if !isLatexLetter(p.src[p.pos]) {
+		name := string(p.src[p.pos])
+		p.pos++
+		return name
+	}
+	start := p.pos
+	for p.pos < len(p.src) && isLatexLetter(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *latexParser) parseCommand() (*mathNode, error) {
+	start := p.pos
+	name := p.readCommandName()
+	if name == "" {
+		return nil, LatexError{Pos: start + 1, Message: "comandă incompletă „\\”"}
+	}
+	if value, ok := latexGreek[name]; ok {
+		variant := ""
+		if unicode.IsUpper([]rune(value)[0]) {
+			variant = "normal"
+		}
+		return &mathNode{kind: mathIdent, value: value, variant: variant}, nil
+	}
+	if value, ok := latexIdentSymbols[name]; ok {
+		return &mathNode{kind: mathIdent, value: value, variant: "normal"}, nil
+	}
+	if value, ok := latexOperators[name]; ok {
+		return &mathNode{kind: mathOperator, value: value}, nil
+	}
+	if value, ok := latexLargeOperators[name]; ok {
+		variant := ""
+		if !strings.Contains(name, "int") {
+			variant = "limits"
+		}
+		return &mathNode{kind: mathOperator, value: value, variant: variant}, nil
+	}
+	if latexFunctions[name] {
+		if latexLimitFunctions[name] {
+			return &mathNode{kind: mathOperator, value: name, variant: "limits"}, nil
+		}
+		return &mathNode{kind: mathIdent, value: name}, nil
+	}
+	if width, ok := latexSpaces[name]; ok {
+		return &mathNode{kind: mathSpace, value: width}, nil
+	}
+	switch {
+	case latexFracs[name]:
+		num, err := p.parseArgument()
+		if err != nil {
+			return nil, err
+		}
+		den, err := p.parseArgument()
+		if err != nil {
+			return nil, err
+		}
+		return &mathNode{kind: mathFrac, children: []*mathNode{num, den}}, nil
+	case name == "sqrt":
+		return p.parseSqrt()
+	case latexTextCommands[name]:
+		text, err := p.parseRawText()
+		if err != nil {
+			return nil, err
+		}
+		if name == "operatorname" {
+			return &mathNode{kind: mathOperator, value: text}, nil
+		}
+		return &mathNode{kind: mathText, value: text}, nil
+	case latexAccents[name] != "":
+		body, err := p.parseArgument()
+		if err != nil {
+			return nil, err
+		}
+		return &mathNode{kind: mathAccent, value: latexAccents[name], children: []*mathNode{body}}, nil
+	case latexStyles[name] != "":
+		body, err := p.parseArgument()
+		if err != nil {
+			return nil, err
+		}
+		return &mathNode{kind: mathStyled, variant: latexStyles[name], children: []*mathNode{body}}, nil
+	case name == "left":
+		return p.parseFenced(start)
+	case name == "right":
+		return nil, LatexError{Pos: start + 1, Message: "\\right fără \\left corespunzător"}
+	case name == "%" || name == "$" || name == "#" || name == "&" || name == "_":
+		return &mathNode{kind: mathOperator, value: name}, nil
+	}
+	return nil, LatexError{Pos: start + 1, Message: "comandă necunoscută „\\" + name + "”"}
+}
+
+func (p *latexParser) parseSqrt() (*mathNode, error) {
+	p.skipSpaces()
+	var index *mathNode
+	if p.peek() == '[' {
+		open := p.pos
+		p.pos++
+		row, err := p.parseRow(']')
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ']' {
+			return nil, LatexError{Pos: open + 1, Message: "paranteză „[” neînchisă"}
+		}
+		p.pos++
+		index = row
+	}
+	body, err := p.parseArgument()
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		return &mathNode{kind: mathSqrt, children: []*mathNode{body, index}}, nil
+	}
+	return &mathNode{kind: mathSqrt, children: []*mathNode{body}}, nil
+}
+
+func (p *latexParser) parseRawText() (string, error) {
+	p.skipSpaces()
+	if p.peek() != '{' {
+		return "", p.errorf("textul trebuie încadrat în acolade")
+	}
+	open := p.pos
+	p.pos++
+	depth := 1
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		ch := p.src[p.pos]
+		switch ch {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return b.String(), nil
+			}
+		}
+		b.WriteRune(ch)
+		p.pos++
+	}
+	return "", LatexError{Pos: open + 1, Message: "acoladă „{” neînchisă"}
+}
+
+func (p *latexParser) parseFenced(start int) (*mathNode, error) {
+	left, err := p.parseDelimiter()
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseRow(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.atCommand("right") {
+		return nil, LatexError{Pos: start + 1, Message: "\\left fără \\right corespunzător"}
+	}
+	p.readCommandName()
+	right, err := p.parseDelimiter()
+	if err != nil {
+		return nil, err
+	}
+	row := &mathNode{kind: mathRow}
+	if left != "" {
+		row.children = append(row.children, &mathNode{kind: mathOperator, value: left})
+	}
+	row.children = append(row.children, body)
+	if right != "" {
+		row.children = append(row.children, &mathNode{kind: mathOperator, value: right})
+	}
+	return row, nil
+}
+
+func (p *latexParser) parseDelimiter() (string, error) {
+	p.skipSpaces()
+	start := p.pos
+	if p.peek() == 0 {
+		return "", p.errorf("lipsește delimitatorul")
+	}
+	token := string(p.peek())
+	if p.peek() == '\\' {
+		token = `\` + p.readCommandName()
+	} else {
+		p.pos++
+	}
+	value, ok := latexDelimiters[token]
+	if !ok {
+		return "", LatexError{Pos: start + 1, Message: "delimitator invalid „" + token + "”"}
+	}
+	return value, nil
+}
+
+func isLatexLetter(r rune) bool {
+	return r < unicode.MaxASCII && unicode.IsLetter(r)
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRenderMathMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		pos     int
+		message string
+	}{
+		{"empty", "   ", 1, "formula este goală"},
+		{"unclosed brace", "x^{2", 3, "acoladă „{” neînchisă"},
+		{"unmatched closing brace", "a}", 2, "acoladă „}” fără pereche"},
+		{"position ignores surrounding spaces", "  x}", 2, "acoladă „}” fără pereche"},
+		{"positions count characters not bytes", "ă}", 2, "acoladă „}” fără pereche"},
+		{"unknown command", `x + \foo`, 5, "comandă necunoscută „\\foo”"},
+		{"double superscript", "x^2^3", 4, "exponent dublu; folosiți acolade"},
+		{"double subscript", "x_1_2", 4, "indice dublu; folosiți acolade"},
+		{"prime after exponent", "f^2'", 4, "exponent dublu"},
+		{"prime after folded exponent", "x'^2'", 5, "exponent dublu"},
+		{"exponent after folded exponent", "x'^2^3", 5, "exponent dublu; folosiți acolade"},
+		{"left without right", `\left( x`, 1, "\\left fără \\right corespunzător"},
+		{"right without left", `x \right)`, 3, "\\right fără \\left corespunzător"},
+		{"missing fraction argument", `\frac{a}`, 9, "lipsește argumentul"},
+		{"unclosed root index", `\sqrt[3 x`, 6, "paranteză „[” neînchisă"},
+		{"trailing backslash", `a \`, 3, "comandă incompletă „\\”"},
+		{"invalid delimiter", `\left< x \right)`, 6, "delimitator invalid „<”"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RenderMathML(tt.src, false)
+			var latexErr LatexError
+			if !errors.As(err, &latexErr) {
+				t.Fatalf("RenderMathML(%q) error = %v, want LatexError", tt.src, err)
+			}
+			if latexErr.Pos != tt.pos || latexErr.Message != tt.message {
+				t.Errorf("RenderMathML(%q) = {%d %q}, want {%d %q}", tt.src, latexErr.Pos, latexErr.Message, tt.pos, tt.message)
+			}
+		})
+	}
+}
+
+func TestRenderMathMLLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"longest formula", strings.Repeat("x", maxFormulaLength), ""},
+		{"too long", strings.Repeat("x", maxFormulaLength+1), "formula este prea lungă"},
+		{"deepest nesting", nested(maxFormulaDepth - 1), ""},
+		{"too deep", nested(maxFormulaDepth), "formula este imbricată prea adânc"},
+		{"too deep through fractions", strings.Repeat(`\frac{1}{`, maxFormulaDepth) + "x" + strings.Repeat("}", maxFormulaDepth), "formula este imbricată prea adânc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RenderMathML(tt.src, true)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("RenderMathML() error = %v, want nil", err)
+				}
+				return
+			}
+			var latexErr LatexError
+			if !errors.As(err, &latexErr) || latexErr.Message != tt.wantErr {
+				t.Fatalf("RenderMathML() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderMathMLOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		display bool
+		want    []string
+	}{
+		{"inline", "x^2", false, []string{`<math xmlns="http://www.w3.org/1998/Math/MathML">`, "<msup><mi>x</mi><mn>2</mn></msup>"}},
+		{"display", "x", true, []string{`display="block"`}},
+		{"fraction", `\frac{a}{b}`, false, []string{"<mfrac><mrow><mi>a</mi></mrow><mrow><mi>b</mi></mrow></mfrac>"}},
+		{"escapes text", `\text{a<b}`, false, []string{"a&lt;b"}},
+		{"prime", "f'", false, []string{"<msup><mi>f</mi><mo>′</mo></msup>"}},
+		{"double prime", "x''", false, []string{"<msup><mi>x</mi><mo>′′</mo></msup>"}},
+		{"prime and exponent", "x'^2", false, []string{"<msup><mi>x</mi><mrow><mo>′</mo><mn>2</mn></mrow></msup>"}},
+		{"prime and subscript", "x_1'", false, []string{"<msubsup><mi>x</mi><mn>1</mn><mo>′</mo></msubsup>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderMathML(tt.src, tt.display)
+			if err != nil {
+				t.Fatalf("RenderMathML(%q) error = %v", tt.src, err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("RenderMathML(%q) = %s, want it to contain %s", tt.src, got, want)
+				}
+			}
+		})
+	}
+}
+
+// nested wraps x in depth pairs of braces.
+func nested(depth int) string {
+	return strings.Repeat("{", depth) + "x" + strings.Repeat("}", depth)
+}
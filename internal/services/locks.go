@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrJobRunning is returned by background jobs that must run on one replica
+// at a time when another instance already holds their lock.
+var ErrJobRunning = ServiceError{Status: 409, Message: "Operația rulează deja pe altă instanță."}
+
+// withAdvisoryLock runs fn while holding the session-level Postgres advisory
+// lock for name on a dedicated pool connection. It returns busy without
+// running fn when another session, on any instance, holds the lock. If the
+// unlock fails the connection is discarded, which ends the session and with
+// it the lock, instead of returning it to the pool still locked.
+func withAdvisoryLock(db *sqlx.DB, name string, busy error, fn func() error) error {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, `SELECT pg_try_advisory_lock(hashtext($1))`, name); err != nil {
+		return err
+	}
+	if !locked {
+		return busy
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock(hashtext($1))`, name); err != nil {
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	gast "github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	ghtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+const (
+	TextFormatPlain    = "plain"
+	TextFormatMarkdown = "markdown"
+)
+
+var markdownConverter = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.Strikethrough, extension.Linkify),
+	goldmark.WithParserOptions(
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+	),
+	goldmark.WithRendererOptions(
+		// Raw HTML pasted by teachers is kept here and filtered by htmlPolicy.
+		ghtml.WithUnsafe(),
+		ghtml.WithHardWraps(),
+		renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 150)),
+	),
+)
+
+var htmlPolicy = newHTMLPolicy()
+
+var paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+
+func newHTMLPolicy() *bluemonday.Policy {
+	policy := bluemonday.NewPolicy()
+	policy.AllowElements(
+		"p", "br", "hr", "strong", "b", "em", "i", "u", "del", "s", "sup", "sub", "code", "pre",
+		"blockquote", "ul", "ol", "li", "h2", "h3", "h4", "h5", "h6",
+		"table", "thead", "tbody", "tr", "th", "td",
+	)
+	policy.AllowStandardURLs()
+	policy.AllowAttrs("href").OnElements("a")
+	policy.RequireNoFollowOnLinks(true)
+	policy.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	policy.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+
+	mathML := []string{
+		"math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "mtext", "mspace",
+		"mfrac", "msqrt", "mroot", "msub", "msup", "msubsup", "munder", "mover", "munderover", "mstyle",
+	}
+	policy.AllowElements(mathML...)
+	policy.AllowNoAttrs().OnElements(mathML...)
+	policy.AllowAttrs("xmlns").Matching(regexp.MustCompile(`^http://www\.w3\.org/1998/Math/MathML$`)).OnElements("math")
+	policy.AllowAttrs("display").Matching(regexp.MustCompile(`^(block|inline)$`)).OnElements("math")
+	policy.AllowAttrs("mathvariant").Matching(regexp.MustCompile(`^[a-z-]+$`)).OnElements("mi", "mtext", "mstyle")
+	policy.AllowAttrs("width").Matching(regexp.MustCompile(`^-?[0-9.]+em$`)).OnElements("mspace")
+	policy.AllowAttrs("accent").Matching(regexp.MustCompile(`^true$`)).OnElements("mover")
+	policy.AllowAttrs("encoding").Matching(regexp.MustCompile(`^application/x-tex$`)).OnElements("annotation")
+	return policy
+}
+
+// RenderTextHTML converts a TEXT block body to sanitized HTML. Plain text is
+// escaped and split into paragraphs; markdown is rendered with inline
+// ($...$) and display ($$...$$) LaTeX math.
+func RenderTextHTML(source, format string) (string, error) {
+	if format != TextFormatMarkdown {
+		return plainTextHTML(source), nil
+	}
+	var buf bytes.Buffer
+	if err := markdownConverter.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(htmlPolicy.Sanitize(buf.String())), nil
+}
+
+func plainTextHTML(source string) string {
+	paragraphs := paragraphBreak.Split(strings.ReplaceAll(source, "\r\n", "\n"), -1)
+	var b strings.Builder
+	for _, paragraph := range paragraphs {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>"))
+		b.WriteString("</p>")
+	}
+	return b.String()
+}
+
+var kindMath = gast.NewNodeKind("Math")
+
+type mathInline struct {
+	gast.BaseInline
+	Source  string
+	Display bool
+}
+
+func (n *mathInline) Kind() gast.NodeKind {
+	return kindMath
+}
+
+func (n *mathInline) Dump(source []byte, level int) {
+	gast.DumpHelper(n, source, level, map[string]string{"Source": n.Source}, nil)
+}
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+// Parse follows the pandoc rules: the opening $ must not be followed by a
+// space and the closing $ must not be preceded by one, so prices such as
+// "5$ și 10$" stay plain text.
+func (mathInlineParser) Parse(parent gast.Node, block text.Reader, pc parser.Context) gast.Node {
+	line, _ := block.PeekLine()
+	delim := 1
+	if len(line) > 1 && line[1] == '$' {
+		delim = 2
+	}
+	body := line[delim:]
+	if len(body) == 0 || body[0] == ' ' || body[0] == '\t' {
+		return nil
+	}
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '\\':
+			i++
+		case '$':
+			if delim == 2 && (i+1 >= len(body) || body[i+1] != '$') {
+				continue
+			}
+			if i == 0 || body[i-1] == ' ' || body[i-1] == '\t' {
+				return nil
+			}
+			block.Advance(delim + i + delim)
+			return &mathInline{Source: string(body[:i]), Display: delim == 2}
+		}
+	}
+	return nil
+}
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, func(w util.BufWriter, source []byte, node gast.Node, entering bool) (gast.WalkStatus, error) {
+		if !entering {
+			return gast.WalkContinue, nil
+		}
+		math := node.(*mathInline)
+		out, err := RenderMathML(math.Source, math.Display)
+		if err != nil {
+			return gast.WalkStop, err
+		}
+		_, _ = w.WriteString(out)
+		return gast.WalkSkipChildren, nil
+	})
+}
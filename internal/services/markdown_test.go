@@ -0,0 +1,130 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderTextHTMLMath(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "inline math",
+			src:     "x $y$ z",
+			want:    []string{"<p>x <math", "<mi>y</mi>", "</math> z</p>"},
+			notWant: []string{`display="block"`},
+		},
+		{
+			name: "display math",
+			src:  "$$x^2$$",
+			want: []string{`display="block"`, "<msup><mi>x</mi><mn>2</mn></msup>"},
+		},
+		{
+			name:    "prices are not math",
+			src:     "Costă 5$ și 10$.",
+			want:    []string{"<p>Costă 5$ și 10$.</p>"},
+			notWant: []string{"<math"},
+		},
+		{
+			name:    "opening delimiter followed by a space",
+			src:     "$ x$",
+			want:    []string{"<p>$ x$</p>"},
+			notWant: []string{"<math"},
+		},
+		{
+			name:    "escaped dollars",
+			src:     `\$x\$`,
+			want:    []string{"<p>$x$</p>"},
+			notWant: []string{"<math"},
+		},
+		{
+			name: "escaped dollar inside math",
+			src:  `$a\$b$`,
+			want: []string{"<mo>$</mo>"},
+		},
+		{
+			name:    "dollars in code spans",
+			src:     "`$x$`",
+			want:    []string{"<code>$x$</code>"},
+			notWant: []string{"<math"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTextHTML(tt.src, TextFormatMarkdown)
+			if err != nil {
+				t.Fatalf("RenderTextHTML(%q) error = %v", tt.src, err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("RenderTextHTML(%q) = %s, want it to contain %s", tt.src, got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("RenderTextHTML(%q) = %s, want it not to contain %s", tt.src, got, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderTextHTMLInvalidMath(t *testing.T) {
+	if _, err := RenderTextHTML(`$\foo$`, TextFormatMarkdown); err == nil {
+		t.Fatal("RenderTextHTML() error = nil, want LaTeX error")
+	}
+}
+
+func TestRenderTextHTMLSanitizes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"javascript link", "[x](javascript:alert(1))", "<p>x</p>"},
+		{"mixed case javascript link", "[x](JaVaScRiPt:alert(1))", "<p>x</p>"},
+		{"raw javascript anchor", `<a href="javascript:alert(1)">x</a>`, "<p>x</p>"},
+		{"script element", "<script>alert(1)</script>ok", "ok"},
+		{"event handler", `<img src=x onerror="alert(1)">`, ""},
+		{"https link", "[x](https://example.com)", `<p><a href="https://example.com" rel="nofollow">x</a></p>`},
+		{"autolink", "<https://example.com>", `<p><a href="https://example.com" rel="nofollow">https://example.com</a></p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTextHTML(tt.src, TextFormatMarkdown)
+			if err != nil {
+				t.Fatalf("RenderTextHTML(%q) error = %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderTextHTML(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTextHTMLPlain(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"paragraphs and line breaks", "a\r\nb\n\n\nc", "<p>a<br>b</p><p>c</p>"},
+		{"escapes html", "<b>x</b> & $y$", "<p>&lt;b&gt;x&lt;/b&gt; &amp; $y$</p>"},
+		{"blank", " \n\n ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderTextHTML(tt.src, TextFormatPlain)
+			if err != nil {
+				t.Fatalf("RenderTextHTML(%q) error = %v", tt.src, err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderTextHTML(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
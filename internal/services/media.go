@@ -1,11 +1,15 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,50 +21,102 @@ const (
 	BucketResources = "resources"
 )
 
-func EnsureStoragePath(base string, bucket string) (string, error) {
-	path := filepath.Join(base, bucket)
-	if err := os.MkdirAll(path, 0755); err != nil {
-		return "", err
-	}
-	return path, nil
-}
-
-func SaveMediaAsset(db *sqlx.DB, basePath, bucket, contentType, filename, mediaType, ownerID string, body io.Reader) (string, string, error) {
+// SaveMediaAsset spools body to a temporary file while hashing it, checks the
+// sniffed content type and size against policy, stores it in the configured
+// backend and records a media_assets row.
+func SaveMediaAsset(db *sqlx.DB, store ObjectStorage, policy UploadPolicy, declaredType, filename, ownerID string, body io.Reader) (string, string, error) {
 	assetID := uuid.NewString()
-	storageKey := assetID
-	bucketPath, err := EnsureStoragePath(basePath, bucket)
-	if err != nil {
-		return "", "", err
-	}
-	targetPath := filepath.Join(bucketPath, storageKey)
+	bucket := policy.Bucket
 
-	file, err := os.Create(targetPath)
+	spool, err := os.CreateTemp("", "media-*")
 	if err != nil {
 		return "", "", err
 	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
 	hasher := sha256.New()
-	writer := io.MultiWriter(file, hasher)
-	size, err := io.Copy(writer, body)
-	_ = file.Close()
+	writer := io.MultiWriter(spool, hasher)
+	maxBytes := policy.MaxBytes()
+	readLimit := maxBytes
+	if policy.Quota != nil {
+		if available, limited := policy.Quota.Available(); limited && available < readLimit {
+			readLimit = available
+		}
+	}
+	// Reading stops one byte past the limit so oversized streams are never
+	// spooled in full.
+	size, err := io.Copy(writer, io.LimitReader(body, readLimit+1))
 	if err != nil {
 		return "", "", err
 	}
 	if size == 0 {
-		_ = os.Remove(targetPath)
 		return "", "", ErrBadRequest("Fișierul este gol.")
 	}
+	if size > maxBytes {
+		return "", "", ErrTooLarge(fmt.Sprintf("Fișierul depășește limita de %d MB.", maxBytes>>20))
+	}
+	if size > readLimit {
+		return "", "", policy.Quota.Check(size)
+	}
+	contentType := SniffContentType(spool, size)
+	kind, err := checkUpload(policy, contentType, declaredType, size)
+	if err != nil {
+		return "", "", err
+	}
+	meta := map[string]interface{}{"declaredContentType": declaredType}
 	sha := hex.EncodeToString(hasher.Sum(nil))
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	var content io.Reader = spool
+	var variants []encodedVariant
+	if strings.HasPrefix(contentType, "image/") {
+		data, err := io.ReadAll(spool)
+		if err != nil {
+			return "", "", err
+		}
+		cleaned, cfg, rendered, err := processImage(data, contentType)
+		if err != nil {
+			return "", "", err
+		}
+		digest := sha256.Sum256(cleaned)
+		sha = hex.EncodeToString(digest[:])
+		size = int64(len(cleaned))
+		content = bytes.NewReader(cleaned)
+		variants = rendered
+		meta["width"] = cfg.Width
+		meta["height"] = cfg.Height
+	}
+	if contentType == MimePDF {
+		if info, ok := readPDFInfo(spool, size); ok {
+			meta["pdf"] = info
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return "", "", err
+		}
+	}
+	metadata, _ := json.Marshal(meta)
+
+	blob, err := storeBlob(db, store, bucket, assetID, sha, size, contentType, content)
+	if err != nil {
+		return "", "", err
+	}
 
 	_, err = db.Exec(`
 INSERT INTO media_assets (
-  id, owner_user_id, bucket, storage_key, filename, description, type,
+  id, owner_user_id, bucket, storage_key, blob_id, filename, description, type,
   content_type, size_bytes, sha256, access_policy, status, metadata, created_at, updated_at
-) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,'{}', $13, $13)
-`, assetID, ownerID, bucket, storageKey, filename, nil, mediaType, contentType, size, sha, "PRIVATE", "READY", time.Now().UTC())
+) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$15)
+`, assetID, ownerID, bucket, blob.StorageKey, blob.ID, filename, nil, kind.MediaType, contentType, size, sha, AccessPrivate, MediaStatusPendingScan, metadata, time.Now().UTC())
 	if err != nil {
-		_ = os.Remove(targetPath)
+		releaseBlob(db, store, &blob.ID, bucket, blob.StorageKey)
 		return "", "", err
 	}
+	// Variants and thumbnails are an optimisation; without them the original is
+	// served.
+	_ = storeImageVariants(db, store, bucket, assetID, variants)
 	return assetID, BuildAssetURL(assetID), nil
 }
 
@@ -68,19 +124,24 @@ func BuildAssetURL(assetID string) string {
 	return "/media/assets/" + assetID + "/content"
 }
 
-func DeleteAsset(db *sqlx.DB, basePath string, assetID string) error {
-	var bucket string
-	var storageKey string
-	err := db.Get(&bucket, `SELECT bucket FROM media_assets WHERE id = $1`, assetID)
-	if err != nil {
-		return nil
-	}
-	err = db.Get(&storageKey, `SELECT storage_key FROM media_assets WHERE id = $1`, assetID)
-	if err != nil {
+func DeleteAsset(db *sqlx.DB, store ObjectStorage, assetID string) error {
+	row := struct {
+		Bucket     string  `db:"bucket"`
+		StorageKey string  `db:"storage_key"`
+		BlobID     *string `db:"blob_id"`
+	}{}
+	if err := db.Get(&row, `SELECT bucket, storage_key, blob_id FROM media_assets WHERE id = $1`, assetID); err != nil {
 		return nil
 	}
+	variantKeys := imageVariantKeys(db, assetID)
 	_, _ = db.Exec(`DELETE FROM media_assets WHERE id = $1`, assetID)
-	path := filepath.Join(basePath, bucket, storageKey)
-	_ = os.Remove(path)
+	deleteStoredObjects(store, row.Bucket, variantKeys)
+	releaseBlob(db, store, row.BlobID, row.Bucket, row.StorageKey)
 	return nil
 }
+
+func deleteStoredObjects(store ObjectStorage, bucket string, keys []string) {
+	for _, key := range keys {
+		_ = store.Delete(context.Background(), bucket, key)
+	}
+}
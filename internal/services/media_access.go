@@ -0,0 +1,187 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	AccessPublic  = "PUBLIC"
+	AccessPrivate = "PRIVATE"
+	AccessGroup   = "GROUP"
+	// AccessAuto hands the policy back to RefreshAssetAccess.
+	AccessAuto = "AUTO"
+)
+
+// signedURLWindow is the granularity of signed URL expiry times. URLs signed
+// within the same window are identical, so browsers and proxies can cache
+// them; each stays valid for between TTL and TTL plus one window.
+const signedURLWindow = 5 * time.Minute
+
+// AssetSigner issues and verifies short-lived HMAC-signed media URLs so that
+// private assets can be used in <img> tags without an Authorization header.
+type AssetSigner struct {
+	Secret []byte
+	TTL    time.Duration
+}
+
+// SignedURL returns BuildAssetURL(assetID) with expires and sig parameters.
+func (s AssetSigner) SignedURL(assetID string) string {
+	window := int64(signedURLWindow / time.Second)
+	expires := time.Now().Add(s.TTL).Unix()
+	expires += window - expires%window
+	return BuildAssetURL(assetID) + "?expires=" + strconv.FormatInt(expires, 10) + "&sig=" + s.signature(assetID, expires)
+}
+
+// Verify reports whether sig is a valid, unexpired signature for assetID.
+func (s AssetSigner) Verify(assetID, expires, sig string) bool {
+	if expires == "" || sig == "" {
+		return false
+	}
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.signature(assetID, exp)))
+}
+
+func (s AssetSigner) signature(assetID string, expires int64) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(assetID + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+type AssetAccess struct {
+	ID           string  `db:"id"`
+	OwnerID      *string `db:"owner_user_id"`
+	AccessPolicy string  `db:"access_policy"`
+	GroupID      *string `db:"group_id"`
+}
+
+func GetAssetAccess(db *sqlx.DB, assetID string) (AssetAccess, error) {
+	var access AssetAccess
+	if !validUUID(assetID) {
+		return access, sql.ErrNoRows
+	}
+	err := db.Get(&access, `SELECT id, owner_user_id, access_policy, group_id FROM media_assets WHERE id = $1::uuid`, assetID)
+	return access, err
+}
+
+// CanReadAsset applies the access policy of an asset to an authenticated
+// user. PUBLIC assets are readable by anyone; PRIVATE ones by the owner and
+// admins; GROUP ones additionally by members of the asset group.
+func CanReadAsset(db *sqlx.DB, access AssetAccess, userID string, isAdmin bool) (bool, error) {
+	if access.AccessPolicy == AccessPublic {
+		return true, nil
+	}
+	if userID == "" {
+		return false, nil
+	}
+	if isAdmin || (access.OwnerID != nil && *access.OwnerID == userID) {
+		return true, nil
+	}
+	if access.AccessPolicy != AccessGroup || access.GroupID == nil {
+		return false, nil
+	}
+	var member bool
+	err := db.Get(&member, `
+SELECT EXISTS(
+  SELECT 1 FROM group_members WHERE group_id = $1 AND user_id = $2 AND status = 'ACTIVE'
+)`, *access.GroupID, userID)
+	return member, err
+}
+
+// SetAssetAccess changes the policy of an asset on behalf of userID. GROUP
+// requires groupID, which must name a group the user teaches or is an active
+// member of, unless isAdmin. An explicit policy is kept until it is set back
+// to AUTO, which derives it from the resources using the asset again.
+func SetAssetAccess(db *sqlx.DB, assetID, policy string, groupID *string, userID string, isAdmin bool) error {
+	switch policy {
+	case AccessAuto:
+		_, err := db.Exec(`
+UPDATE media_assets SET access_policy = 'PRIVATE', group_id = NULL, access_policy_manual = false, updated_at = $2
+WHERE id = $1`, assetID, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		return RefreshAssetAccess(db, []string{assetID})
+	case AccessPrivate, AccessPublic:
+		groupID = nil
+	case AccessGroup:
+		if groupID == nil || *groupID == "" {
+			return ErrBadRequest("Selectați grupul care are acces la fișier.")
+		}
+		if !validUUID(*groupID) {
+			return ErrBadRequest("Grupul selectat nu există.")
+		}
+		row := struct {
+			Exists  bool `db:"group_exists"`
+			Allowed bool `db:"allowed"`
+		}{}
+		err := db.Get(&row, `
+SELECT EXISTS(SELECT 1 FROM groups g WHERE g.id = $1::uuid AND g.deleted_at IS NULL) AS group_exists,
+       EXISTS(
+         SELECT 1 FROM groups g WHERE g.id = $1::uuid AND g.teacher_id = $2::uuid
+         UNION ALL
+         SELECT 1 FROM group_members gm WHERE gm.group_id = $1::uuid AND gm.user_id = $2::uuid AND gm.status = 'ACTIVE'
+       ) AS allowed
+`, *groupID, userID)
+		if err != nil {
+			return err
+		}
+		if !row.Exists {
+			return ErrBadRequest("Grupul selectat nu există.")
+		}
+		if !row.Allowed && !isAdmin {
+			return ErrForbidden("Puteți partaja fișierul doar cu grupurile din care faceți parte.")
+		}
+	default:
+		return ErrBadRequest("Politica de acces trebuie să fie PUBLIC, PRIVATE, GROUP sau AUTO.")
+	}
+	_, err := db.Exec(`UPDATE media_assets SET access_policy = $2, group_id = $3, access_policy_manual = true, updated_at = $4 WHERE id = $1`,
+		assetID, policy, groupID, time.Now().UTC())
+	return err
+}
+
+// ResourceAssetIDs lists the assets currently referenced by a resource.
+func ResourceAssetIDs(db *sqlx.DB, resourceID string) ([]string, error) {
+	ids := []string{}
+	err := db.Select(&ids, `SELECT DISTINCT asset_id::text FROM resource_asset_refs WHERE resource_id = $1`, resourceID)
+	return ids, err
+}
+
+// RefreshAssetAccess makes assets used by a published resource PUBLIC and
+// returns PUBLIC assets that are no longer used by one to PRIVATE. Policies
+// set explicitly with SetAssetAccess, including every GROUP one, are kept.
+func RefreshAssetAccess(db *sqlx.DB, assetIDs []string) error {
+	now := time.Now().UTC()
+	for _, assetID := range assetIDs {
+		_, err := db.Exec(`
+UPDATE media_assets m
+SET access_policy = CASE WHEN published.used THEN 'PUBLIC' ELSE 'PRIVATE' END,
+    updated_at = $2
+FROM (
+  SELECT EXISTS(
+    SELECT 1 FROM resource_asset_refs r
+    JOIN resource_entries e ON e.id = r.resource_id
+    WHERE r.asset_id = $1 AND e.status = 'PUBLISHED'
+  ) AS used
+) published
+WHERE m.id = $1
+  AND NOT m.access_policy_manual
+  AND m.access_policy <> 'GROUP'
+  AND (published.used OR m.access_policy = 'PUBLIC')
+  AND m.access_policy <> CASE WHEN published.used THEN 'PUBLIC' ELSE 'PRIVATE' END
+`, assetID, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
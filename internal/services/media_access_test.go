@@ -0,0 +1,53 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSetAssetAccessGroup(t *testing.T) {
+	const (
+		asset = "44444444-4444-4444-4444-444444444444"
+		group = "55555555-5555-5555-5555-555555555555"
+		user  = "11111111-1111-1111-1111-111111111111"
+	)
+	tests := []struct {
+		name       string
+		groupID    *string
+		exists     bool
+		allowed    bool
+		isAdmin    bool
+		queried    bool
+		wantStatus int
+	}{
+		{"member", ptr(group), true, true, false, true, 0},
+		{"not a member", ptr(group), true, false, false, true, http.StatusForbidden},
+		{"admin outside the group", ptr(group), true, false, true, true, 0},
+		{"deleted or missing group", ptr(group), false, false, true, true, http.StatusBadRequest},
+		{"no group", nil, false, false, false, false, http.StatusBadRequest},
+		{"malformed group", ptr("group-1"), false, false, false, false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.queried {
+				mock.ExpectQuery(`AS group_exists`).WithArgs(group, user).
+					WillReturnRows(sqlmock.NewRows([]string{"group_exists", "allowed"}).AddRow(tt.exists, tt.allowed))
+			}
+			if tt.wantStatus == 0 {
+				mock.ExpectExec(`UPDATE media_assets SET access_policy = \$2, group_id = \$3, access_policy_manual = true`).
+					WithArgs(asset, AccessGroup, group, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			err := SetAssetAccess(db, asset, AccessGroup, tt.groupID, user, tt.isAdmin)
+			wantStatus(t, err, tt.wantStatus)
+		})
+	}
+}
+
+func TestSetAssetAccessRejectsUnknownPolicy(t *testing.T) {
+	db, _ := newMockDB(t)
+	err := SetAssetAccess(db, "44444444-4444-4444-4444-444444444444", "SHARED", nil, "", false)
+	wantStatus(t, err, http.StatusBadRequest)
+}
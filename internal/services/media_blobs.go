@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Media files are stored once per distinct content. A media_blobs row owns
+// the physical object and counts the media_assets rows pointing at it; the
+// asset rows keep a copy of the bucket and storage key for readers.
+
+type mediaBlob struct {
+	ID         string `db:"id"`
+	StorageKey string `db:"storage_key"`
+}
+
+// storeBlob returns a blob holding content, reusing an existing blob with the
+// same hash and size in bucket when there is one. A new blob takes newID as
+// both its ID and storage key.
+func storeBlob(db *sqlx.DB, store ObjectStorage, bucket, newID, sha string, size int64, contentType string, content io.Reader) (mediaBlob, error) {
+	var blob mediaBlob
+	err := db.Get(&blob, `
+UPDATE media_blobs SET ref_count = ref_count + 1
+WHERE id = (
+  SELECT id FROM media_blobs
+  WHERE sha256 = $1 AND bucket = $2 AND size_bytes = $3 AND ref_count > 0
+  ORDER BY created_at
+  LIMIT 1
+)
+RETURNING id, storage_key
+`, sha, bucket, size)
+	if err == nil {
+		return blob, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return mediaBlob{}, err
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, bucket, newID, content, size, contentType); err != nil {
+		return mediaBlob{}, err
+	}
+	_, err = db.Exec(`
+INSERT INTO media_blobs (id, bucket, storage_key, sha256, content_type, size_bytes, ref_count, created_at)
+VALUES ($1,$2,$1,$3,$4,$5,1,$6)
+`, newID, bucket, sha, contentType, size, time.Now().UTC())
+	if err != nil {
+		_ = store.Delete(ctx, bucket, newID)
+		return mediaBlob{}, err
+	}
+	return mediaBlob{ID: newID, StorageKey: newID}, nil
+}
+
+// releaseBlob drops one reference to a blob and deletes the blob and its
+// object once nothing points at it. Assets created before blobs existed have
+// no blob and own their object directly.
+func releaseBlob(db *sqlx.DB, store ObjectStorage, blobID *string, bucket, storageKey string) {
+	if blobID == nil {
+		_ = store.Delete(context.Background(), bucket, storageKey)
+		return
+	}
+	var remaining int
+	if err := db.Get(&remaining, `UPDATE media_blobs SET ref_count = ref_count - 1 WHERE id = $1 RETURNING ref_count`, *blobID); err != nil {
+		return
+	}
+	if remaining > 0 {
+		return
+	}
+	result, err := db.Exec(`DELETE FROM media_blobs WHERE id = $1 AND ref_count <= 0`, *blobID)
+	if err != nil {
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		_ = store.Delete(context.Background(), bucket, storageKey)
+	}
+}
+
+type DedupBlob struct {
+	SHA256     string `db:"sha256" json:"sha256"`
+	Bucket     string `db:"bucket" json:"bucket"`
+	Filename   string `db:"filename" json:"filename"`
+	SizeBytes  int64  `db:"size_bytes" json:"sizeBytes"`
+	RefCount   int    `db:"ref_count" json:"refCount"`
+	SavedBytes int64  `db:"saved_bytes" json:"savedBytes"`
+}
+
+type MediaDedupReport struct {
+	Assets        int64       `db:"assets" json:"assets"`
+	Blobs         int64       `db:"blobs" json:"blobs"`
+	SharedBlobs   int64       `db:"shared_blobs" json:"sharedBlobs"`
+	LogicalBytes  int64       `db:"logical_bytes" json:"logicalBytes"`
+	PhysicalBytes int64       `db:"physical_bytes" json:"physicalBytes"`
+	SavedBytes    int64       `db:"saved_bytes" json:"savedBytes"`
+	TopShared     []DedupBlob `db:"-" json:"topShared"`
+}
+
+// BuildMediaDedupReport summarises the space saved by sharing blobs.
+func BuildMediaDedupReport(db *sqlx.DB, limit int) (MediaDedupReport, error) {
+	var report MediaDedupReport
+	err := db.Get(&report, `
+SELECT
+  (SELECT COUNT(*) FROM media_assets) AS assets,
+  COUNT(*) AS blobs,
+  COUNT(*) FILTER (WHERE ref_count > 1) AS shared_blobs,
+  COALESCE((SELECT SUM(size_bytes) FROM media_assets), 0) AS logical_bytes,
+  COALESCE(SUM(size_bytes), 0) AS physical_bytes,
+  COALESCE(SUM(size_bytes * GREATEST(ref_count - 1, 0)), 0) AS saved_bytes
+FROM media_blobs
+`)
+	if err != nil {
+		return report, err
+	}
+	report.TopShared = []DedupBlob{}
+	err = db.Select(&report.TopShared, `
+SELECT COALESCE(b.sha256, '') AS sha256, b.bucket, b.size_bytes, b.ref_count,
+       b.size_bytes * (b.ref_count - 1) AS saved_bytes,
+       COALESCE((SELECT m.filename FROM media_assets m WHERE m.blob_id = b.id AND m.filename IS NOT NULL ORDER BY m.created_at LIMIT 1), '') AS filename
+FROM media_blobs b
+WHERE b.ref_count > 1
+ORDER BY saved_bytes DESC
+LIMIT $1
+`, limit)
+	return report, err
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const reuseBlobQuery = `UPDATE media_blobs SET ref_count = ref_count \+ 1\s+WHERE id = \(\s+SELECT id FROM media_blobs\s+WHERE sha256 = \$1 AND bucket = \$2 AND size_bytes = \$3 AND ref_count > 0`
+
+func TestStoreBlobReusesExisting(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := newMemoryStorage()
+	mock.ExpectQuery(reuseBlobQuery).WithArgs("sha", "media", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "storage_key"}).AddRow("blob-1", "key-1"))
+
+	blob, err := storeBlob(db, store, "media", "new-id", "sha", 3, MimePDF, strings.NewReader("abc"))
+	if err != nil {
+		t.Fatalf("storeBlob() error = %v", err)
+	}
+	if blob.ID != "blob-1" || blob.StorageKey != "key-1" {
+		t.Errorf("blob = %+v, want the existing blob", blob)
+	}
+	if len(store.objects) != 0 {
+		t.Errorf("a duplicate was uploaded: %v", store.objects)
+	}
+}
+
+func TestStoreBlobCreatesNew(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := newMemoryStorage()
+	mock.ExpectQuery(reuseBlobQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "storage_key"}))
+	mock.ExpectExec(`INSERT INTO media_blobs .*VALUES \(\$1,\$2,\$1,\$3,\$4,\$5,1,\$6\)`).
+		WithArgs("new-id", "media", "sha", MimePDF, int64(3), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	blob, err := storeBlob(db, store, "media", "new-id", "sha", 3, MimePDF, strings.NewReader("abc"))
+	if err != nil {
+		t.Fatalf("storeBlob() error = %v", err)
+	}
+	if blob.ID != "new-id" || blob.StorageKey != "new-id" || !store.has("media", "new-id") {
+		t.Errorf("blob = %+v, objects = %v", blob, store.objects)
+	}
+}
+
+func TestStoreBlobInsertFailureDeletesObject(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := newMemoryStorage()
+	boom := errors.New("unique violation")
+	mock.ExpectQuery(reuseBlobQuery).WillReturnRows(sqlmock.NewRows([]string{"id", "storage_key"}))
+	mock.ExpectExec(`INSERT INTO media_blobs`).WillReturnError(boom)
+
+	if _, err := storeBlob(db, store, "media", "new-id", "sha", 3, MimePDF, strings.NewReader("abc")); !errors.Is(err, boom) {
+		t.Fatalf("error = %v, want %v", err, boom)
+	}
+	if store.has("media", "new-id") {
+		t.Error("object of the failed blob was left behind")
+	}
+}
+
+func TestReleaseBlob(t *testing.T) {
+	tests := []struct {
+		name       string
+		blobID     *string
+		remaining  int
+		deleted    int64
+		wantObject bool
+	}{
+		{"legacy asset without a blob", nil, 0, 0, false},
+		{"still shared", ptr("blob-1"), 1, 0, true},
+		{"last reference", ptr("blob-1"), 0, 1, false},
+		{"reacquired before the delete", ptr("blob-1"), 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			store := newMemoryStorage()
+			store.objects["media/key-1"] = []byte("abc")
+			if tt.blobID != nil {
+				mock.ExpectQuery(`UPDATE media_blobs SET ref_count = ref_count - 1 WHERE id = \$1 RETURNING ref_count`).
+					WithArgs(*tt.blobID).WillReturnRows(sqlmock.NewRows([]string{"ref_count"}).AddRow(tt.remaining))
+				if tt.remaining == 0 {
+					mock.ExpectExec(`DELETE FROM media_blobs WHERE id = \$1 AND ref_count <= 0`).
+						WithArgs(*tt.blobID).WillReturnResult(sqlmock.NewResult(0, tt.deleted))
+				}
+			}
+			releaseBlob(db, store, tt.blobID, "media", "key-1")
+			if got := store.has("media", "key-1"); got != tt.wantObject {
+				t.Errorf("object present = %t, want %t", got, tt.wantObject)
+			}
+		})
+	}
+}
+
+func TestReleaseBlobKeepsObjectOnError(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := newMemoryStorage()
+	store.objects["media/key-1"] = []byte("abc")
+	mock.ExpectQuery(`UPDATE media_blobs SET ref_count = ref_count - 1`).WillReturnError(errors.New("connection reset"))
+
+	releaseBlob(db, store, ptr("blob-1"), "media", "key-1")
+	if !store.has("media", "key-1") {
+		t.Error("object deleted although the reference count was not updated")
+	}
+}
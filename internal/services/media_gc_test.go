@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	orphanA = "aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"
+	orphanB = "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb"
+	orphanC = "cccccccc-cccc-cccc-cccc-cccccccccccc"
+)
+
+// orphanQuery is the sweep's SELECT; it must skip retained and referenced
+// assets.
+const orphanQuery = `FROM media_assets m\s+WHERE m.created_at < \$1 AND\s+NOT m.retained AND\s+NOT EXISTS \(SELECT 1 FROM resource_asset_refs r WHERE r.asset_id = m.id\)\s+AND NOT EXISTS \(SELECT 1 FROM resource_entries e WHERE e.avatar_media_id = m.id\)\s+AND NOT EXISTS \(SELECT 1 FROM user_profiles p WHERE p.avatar_media_id = m.id\)`
+
+func orphanRows() *sqlmock.Rows {
+	created := time.Now().Add(-30 * 24 * time.Hour)
+	return sqlmock.NewRows([]string{"id", "bucket", "storage_key", "blob_id", "filename", "size_bytes", "created_at"}).
+		AddRow(orphanA, "media", "a.png", nil, "a.png", 100, created).
+		AddRow(orphanB, "media", "b.pdf", "blob-b", "b.pdf", 200, created).
+		AddRow(orphanC, "media", "c.pdf", "blob-c", "c.pdf", 300, created)
+}
+
+func seededStorage() *memoryStorage {
+	store := newMemoryStorage()
+	for _, key := range []string{"a.png", "a-w480.jpg", "b.pdf", "c.pdf"} {
+		store.objects["media/"+key] = []byte(key)
+	}
+	return store
+}
+
+func TestCollectOrphanedMediaDryRun(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := seededStorage()
+	mock.ExpectQuery(orphanQuery).WillReturnRows(orphanRows())
+
+	report, err := CollectOrphanedMedia(db, store, 24*time.Hour, true)
+	if err != nil {
+		t.Fatalf("CollectOrphanedMedia() error = %v", err)
+	}
+	if !report.DryRun || report.Found != 3 || report.Deleted != 0 || report.TotalBytes != 600 {
+		t.Errorf("report = %+v, want 3 found, 600 bytes and nothing deleted", report)
+	}
+	if len(store.objects) != 4 {
+		t.Errorf("dry run deleted objects: %v", store.objects)
+	}
+}
+
+func TestCollectOrphanedMediaDeletes(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := seededStorage()
+	mock.ExpectQuery(`pg_try_advisory_lock`).WithArgs("media_gc").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectQuery(orphanQuery).WillReturnRows(orphanRows())
+	// A has no blob: its file and variants go with the row.
+	mock.ExpectQuery(`SELECT storage_key FROM media_asset_variants`).WithArgs(orphanA).WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("a-w480.jpg"))
+	mock.ExpectExec(`DELETE FROM media_assets m WHERE m.id = \$1 AND\s+NOT m.retained`).WithArgs(orphanA).WillReturnResult(sqlmock.NewResult(0, 1))
+	// B was attached to a resource after the scan: the DELETE matches nothing.
+	mock.ExpectQuery(`SELECT storage_key FROM media_asset_variants`).WithArgs(orphanB).WillReturnRows(sqlmock.NewRows([]string{"storage_key"}))
+	mock.ExpectExec(`DELETE FROM media_assets m`).WithArgs(orphanB).WillReturnResult(sqlmock.NewResult(0, 0))
+	// C shares its blob with the last remaining reference: the blob goes too.
+	mock.ExpectQuery(`SELECT storage_key FROM media_asset_variants`).WithArgs(orphanC).WillReturnRows(sqlmock.NewRows([]string{"storage_key"}))
+	mock.ExpectExec(`DELETE FROM media_assets m`).WithArgs(orphanC).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE media_blobs SET ref_count = ref_count - 1`).WithArgs("blob-c").WillReturnRows(sqlmock.NewRows([]string{"ref_count"}).AddRow(0))
+	mock.ExpectExec(`DELETE FROM media_blobs WHERE id = \$1 AND ref_count <= 0`).WithArgs("blob-c").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`pg_advisory_unlock`).WithArgs("media_gc").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	report, err := CollectOrphanedMedia(db, store, 24*time.Hour, false)
+	if err != nil {
+		t.Fatalf("CollectOrphanedMedia() error = %v", err)
+	}
+	if report.DryRun || report.Found != 3 || report.Deleted != 2 {
+		t.Errorf("report = %+v, want 3 found and 2 deleted", report)
+	}
+	for key, want := range map[string]bool{"a.png": false, "a-w480.jpg": false, "b.pdf": true, "c.pdf": false} {
+		if got := store.has("media", key); got != want {
+			t.Errorf("object %s present = %t, want %t", key, got, want)
+		}
+	}
+}
+
+func TestCollectOrphanedMediaBusy(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`pg_try_advisory_lock`).WithArgs("media_gc").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+
+	_, err := CollectOrphanedMedia(db, newMemoryStorage(), time.Hour, false)
+	if !errors.Is(err, ErrJobRunning) {
+		t.Fatalf("error = %v, want ErrJobRunning", err)
+	}
+}
+
+func TestCollectOrphanedMediaQueryError(t *testing.T) {
+	db, mock := newMockDB(t)
+	boom := errors.New("connection reset")
+	mock.ExpectQuery(orphanQuery).WillReturnError(boom)
+
+	if _, err := CollectOrphanedMedia(db, newMemoryStorage(), time.Hour, true); !errors.Is(err, boom) {
+		t.Fatalf("error = %v, want %v", err, boom)
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const maxMediaDescriptionLength = 1000
+
+// MediaLibraryFilter selects a page of one owner's media assets. Empty fields
+// do not filter.
+type MediaLibraryFilter struct {
+	OwnerID  string
+	Type     string
+	Search   string
+	From     *time.Time
+	To       *time.Time
+	Unused   bool
+	Retained *bool
+	Limit    int
+	Offset   int
+}
+
+type MediaLibraryItem struct {
+	ID           string    `db:"id"`
+	OwnerID      *string   `db:"owner_user_id"`
+	Filename     *string   `db:"filename"`
+	Description  *string   `db:"description"`
+	Type         string    `db:"type"`
+	ContentType  string    `db:"content_type"`
+	SizeBytes    int64     `db:"size_bytes"`
+	AccessPolicy string    `db:"access_policy"`
+	Status       string    `db:"status"`
+	Retained     bool      `db:"retained"`
+	UsageCount   int       `db:"usage_count"`
+	CreatedAt    time.Time `db:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at"`
+}
+
+const mediaLibraryColumns = `
+m.id, m.owner_user_id, m.filename, m.description, m.type, m.content_type, m.size_bytes,
+m.access_policy, m.status, m.retained, m.created_at, m.updated_at,
+(SELECT COUNT(DISTINCT r.resource_id) FROM resource_asset_refs r WHERE r.asset_id = m.id) AS usage_count`
+
+// ListMediaLibrary returns the assets matching filter, newest first, and the
+// total number of matches.
+func ListMediaLibrary(db *sqlx.DB, filter MediaLibraryFilter) ([]MediaLibraryItem, int, error) {
+	conditions := []string{"m.owner_user_id = $1"}
+	args := []interface{}{filter.OwnerID}
+	if filter.Type != "" {
+		args = append(args, strings.ToUpper(filter.Type))
+		conditions = append(conditions, fmt.Sprintf("m.type = $%d", len(args)))
+	}
+	if search := strings.TrimSpace(filter.Search); search != "" {
+		args = append(args, "%"+escapeLike(strings.ToLower(search))+"%")
+		conditions = append(conditions, fmt.Sprintf("lower(COALESCE(m.filename, '')) LIKE $%d", len(args)))
+	}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		conditions = append(conditions, fmt.Sprintf("m.created_at >= $%d", len(args)))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		conditions = append(conditions, fmt.Sprintf("m.created_at < $%d", len(args)))
+	}
+	if filter.Unused {
+		conditions = append(conditions, orphanCondition)
+	}
+	if filter.Retained != nil {
+		args = append(args, *filter.Retained)
+		conditions = append(conditions, fmt.Sprintf("m.retained = $%d", len(args)))
+	}
+	where := "WHERE " + strings.Join(conditions, " AND ")
+
+	var total int
+	if err := db.Get(&total, "SELECT count(*) FROM media_assets m "+where, args...); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query := fmt.Sprintf(`
+SELECT`+mediaLibraryColumns+`
+FROM media_assets m
+%s
+ORDER BY m.created_at DESC
+LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+	items := []MediaLibraryItem{}
+	if err := db.Select(&items, query, args...); err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+// GetMediaLibraryItem loads one asset with its usage count.
+func GetMediaLibraryItem(db *sqlx.DB, assetID string) (MediaLibraryItem, error) {
+	var item MediaLibraryItem
+	if !validUUID(assetID) {
+		return item, ErrNotFound("Fișierul nu a fost găsit.")
+	}
+	err := db.Get(&item, `SELECT`+mediaLibraryColumns+`
+FROM media_assets m
+WHERE m.id = $1::uuid`, assetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return item, ErrNotFound("Fișierul nu a fost găsit.")
+	}
+	return item, err
+}
+
+// UpdateMediaDetails renames an asset, changes its description and/or marks
+// it retained so the GC keeps it while unused. Nil fields are left unchanged;
+// an empty description clears it.
+func UpdateMediaDetails(db *sqlx.DB, assetID string, filename, description *string, retained *bool) error {
+	if !validUUID(assetID) {
+		return ErrNotFound("Fișierul nu a fost găsit.")
+	}
+	if filename != nil {
+		name := strings.TrimSpace(*filename)
+		if name == "" {
+			return ErrBadRequest("Numele fișierului nu poate fi gol.")
+		}
+		if len(name) > 255 || strings.ContainsAny(name, "/\\") {
+			return ErrBadRequest("Numele fișierului este invalid.")
+		}
+		filename = &name
+	}
+	if description != nil {
+		text := strings.TrimSpace(*description)
+		if len(text) > maxMediaDescriptionLength {
+			return ErrBadRequest("Descrierea este prea lungă.")
+		}
+		description = &text
+	}
+	_, err := db.Exec(`
+UPDATE media_assets
+SET filename = COALESCE($2, filename),
+    description = CASE WHEN $3::text IS NULL THEN description ELSE NULLIF($3::text, '') END,
+    retained = COALESCE($5, retained),
+    updated_at = $4
+WHERE id = $1::uuid
+`, assetID, filename, description, time.Now().UTC(), retained)
+	return err
+}
+
+// AssetUsage is a resource that embeds an asset.
+type AssetUsage struct {
+	ResourceID string `db:"resource_id" json:"resourceId"`
+	Title      string `db:"title" json:"title"`
+	Slug       string `db:"slug" json:"slug"`
+	Status     string `db:"status" json:"status"`
+	Usage      string `db:"usage" json:"usage"`
+}
+
+// AssetUsages lists the resources referencing an asset as a block or avatar.
+func AssetUsages(db *sqlx.DB, assetID string) ([]AssetUsage, error) {
+	items := []AssetUsage{}
+	if !validUUID(assetID) {
+		return items, nil
+	}
+	err := db.Select(&items, `
+SELECT e.id AS resource_id, e.title, e.slug, e.status, r.usage
+FROM resource_asset_refs r
+JOIN resource_entries e ON e.id = r.resource_id
+WHERE r.asset_id = $1::uuid
+ORDER BY e.title, r.usage
+`, assetID)
+	return items, err
+}
+
+// ErrAssetInUse is returned when deleting an asset that is still referenced.
+var ErrAssetInUse = ServiceError{Status: 409, Message: "Fișierul este folosit și nu poate fi șters."}
+
+// DeleteUnusedAsset deletes an asset unless a resource or profile still
+// points to it. The check is repeated in the DELETE so an asset attached in
+// the meantime is kept.
+func DeleteUnusedAsset(db *sqlx.DB, store ObjectStorage, assetID string) error {
+	row := struct {
+		ID         string  `db:"id"`
+		Bucket     string  `db:"bucket"`
+		StorageKey string  `db:"storage_key"`
+		BlobID     *string `db:"blob_id"`
+	}{}
+	if !validUUID(assetID) {
+		return ErrNotFound("Fișierul nu a fost găsit.")
+	}
+	err := db.Get(&row, `SELECT id, bucket, storage_key, blob_id FROM media_assets WHERE id = $1::uuid`, assetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound("Fișierul nu a fost găsit.")
+	}
+	if err != nil {
+		return err
+	}
+	variantKeys := imageVariantKeys(db, row.ID)
+	result, err := db.Exec(`DELETE FROM media_assets m WHERE m.id = $1 AND`+orphanCondition, row.ID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrAssetInUse
+	}
+	deleteStoredObjects(store, row.Bucket, variantKeys)
+	releaseBlob(db, store, row.BlobID, row.Bucket, row.StorageKey)
+	return nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListMediaLibraryUnusedAndRetained(t *testing.T) {
+	const owner = "11111111-1111-1111-1111-111111111111"
+	tests := []struct {
+		name   string
+		filter MediaLibraryFilter
+		where  string
+		args   []driver.Value
+	}{
+		{
+			// Retained files are unused too; only the GC skips them.
+			name:   "unused includes retained files",
+			filter: MediaLibraryFilter{OwnerID: owner, Unused: true},
+			where:  `WHERE m.owner_user_id = \$1 AND\s+NOT EXISTS \(SELECT 1 FROM resource_asset_refs`,
+			args:   []driver.Value{owner},
+		},
+		{
+			name:   "unused and not retained",
+			filter: MediaLibraryFilter{OwnerID: owner, Unused: true, Retained: ptr(false)},
+			where:  `WHERE m.owner_user_id = \$1 AND.*NOT EXISTS \(SELECT 1 FROM user_profiles p WHERE p.avatar_media_id = m.id\)\s+AND m.retained = \$2`,
+			args:   []driver.Value{owner, false},
+		},
+		{
+			name:   "retained only",
+			filter: MediaLibraryFilter{OwnerID: owner, Retained: ptr(true)},
+			where:  `WHERE m.owner_user_id = \$1 AND m.retained = \$2$`,
+			args:   []driver.Value{owner, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`SELECT count\(\*\) FROM media_assets m ` + tt.where).WithArgs(tt.args...).
+				WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectQuery(`ORDER BY m.created_at DESC`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+			if _, _, err := ListMediaLibrary(db, tt.filter); err != nil {
+				t.Fatalf("ListMediaLibrary() error = %v", err)
+			}
+		})
+	}
+}
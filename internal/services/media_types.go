@@ -0,0 +1,160 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"fizicamd-backend-go/internal/config"
+)
+
+const (
+	MimePNG  = "image/png"
+	MimeJPEG = "image/jpeg"
+	MimeWEBP = "image/webp"
+	MimeGIF  = "image/gif"
+	MimePDF  = "application/pdf"
+	MimeZIP  = "application/zip"
+	MimeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MimePPTX = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	MimeMP4  = "video/mp4"
+	MimeWEBM = "video/webm"
+)
+
+// UploadKind is one accepted file type of an upload policy.
+type UploadKind struct {
+	MediaType string
+	MaxBytes  int64
+}
+
+// UploadPolicy lists the sniffed content types accepted for a bucket and the
+// size limit of each. Quota, when set, also caps the upload to the owner's
+// remaining storage.
+type UploadPolicy struct {
+	Bucket string
+	Kinds  map[string]UploadKind
+	Quota  *StorageQuota
+}
+
+// MaxBytes is the largest size accepted for any kind in the policy.
+func (p UploadPolicy) MaxBytes() int64 {
+	var max int64
+	for _, kind := range p.Kinds {
+		if kind.MaxBytes > max {
+			max = kind.MaxBytes
+		}
+	}
+	return max
+}
+
+func AvatarUploadPolicy(cfg config.Config) UploadPolicy {
+	limit := megabytes(cfg.MediaMaxAvatarMB)
+	return capToScanLimit(cfg, UploadPolicy{
+		Bucket: BucketUsers,
+		Kinds: map[string]UploadKind{
+			MimePNG:  {MediaType: "AVATAR", MaxBytes: limit},
+			MimeJPEG: {MediaType: "AVATAR", MaxBytes: limit},
+			MimeWEBP: {MediaType: "AVATAR", MaxBytes: limit},
+		},
+	})
+}
+
+func ResourceUploadPolicy(cfg config.Config) UploadPolicy {
+	image := megabytes(cfg.MediaMaxImageMB)
+	document := megabytes(cfg.MediaMaxDocumentMB)
+	video := megabytes(cfg.MediaMaxVideoMB)
+	return capToScanLimit(cfg, UploadPolicy{
+		Bucket: BucketResources,
+		Kinds: map[string]UploadKind{
+			MimePNG:  {MediaType: "IMAGE", MaxBytes: image},
+			MimeJPEG: {MediaType: "IMAGE", MaxBytes: image},
+			MimeWEBP: {MediaType: "IMAGE", MaxBytes: image},
+			MimeGIF:  {MediaType: "IMAGE", MaxBytes: image},
+			MimePDF:  {MediaType: "DOCUMENT", MaxBytes: document},
+			MimeDOCX: {MediaType: "DOCUMENT", MaxBytes: document},
+			MimePPTX: {MediaType: "DOCUMENT", MaxBytes: document},
+			MimeZIP:  {MediaType: "OTHER", MaxBytes: megabytes(cfg.MediaMaxArchiveMB)},
+			MimeMP4:  {MediaType: "VIDEO", MaxBytes: video},
+			MimeWEBM: {MediaType: "VIDEO", MaxBytes: video},
+		},
+	})
+}
+
+// capToScanLimit lowers the size limits of policy to SCAN_MAX_MB when files
+// are scanned with clamd, which rejects streams above its StreamMaxLength.
+// Larger files could never be scanned and so never served.
+func capToScanLimit(cfg config.Config, policy UploadPolicy) UploadPolicy {
+	limit := megabytes(cfg.ScanMaxMB)
+	if !strings.EqualFold(cfg.ScannerBackend, ScannerBackendClamd) || limit <= 0 {
+		return policy
+	}
+	for contentType, kind := range policy.Kinds {
+		if kind.MaxBytes > limit {
+			kind.MaxBytes = limit
+			policy.Kinds[contentType] = kind
+		}
+	}
+	return policy
+}
+
+func megabytes(value int) int64 {
+	return int64(value) << 20
+}
+
+// SniffContentType detects the type of a spooled upload from its magic bytes.
+// ZIP containers are inspected further to recognise DOCX and PPTX files.
+func SniffContentType(file io.ReaderAt, size int64) string {
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if detected != MimeZIP {
+		return detected
+	}
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return detected
+	}
+	for _, entry := range archive.File {
+		switch {
+		case strings.HasPrefix(entry.Name, "word/"):
+			return MimeDOCX
+		case strings.HasPrefix(entry.Name, "ppt/"):
+			return MimePPTX
+		}
+	}
+	return detected
+}
+
+// checkUpload validates the sniffed type against the policy, the declared
+// Content-Type header and the per-kind size limit.
+func checkUpload(policy UploadPolicy, sniffed, declared string, size int64) (UploadKind, error) {
+	kind, ok := policy.Kinds[sniffed]
+	if !ok {
+		return UploadKind{}, ErrUnsupportedMediaType(fmt.Sprintf("Tipul fișierului (%s) nu este acceptat.", sniffed))
+	}
+	declared = canonicalContentType(declared)
+	if declared != "" && declared != "application/octet-stream" && declared != sniffed {
+		return UploadKind{}, ErrUnsupportedMediaType(fmt.Sprintf("Tipul declarat (%s) nu corespunde conținutului fișierului (%s).", declared, sniffed))
+	}
+	if size > kind.MaxBytes {
+		return UploadKind{}, ErrTooLarge(fmt.Sprintf("Fișierul depășește limita de %d MB pentru acest tip.", kind.MaxBytes>>20))
+	}
+	return kind, nil
+}
+
+func canonicalContentType(value string) string {
+	parsed, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	switch parsed {
+	case "image/jpg", "image/pjpeg":
+		return MimeJPEG
+	case "application/x-zip-compressed", "application/x-zip":
+		return MimeZIP
+	}
+	return parsed
+}
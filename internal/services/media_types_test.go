@@ -0,0 +1,98 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"docx", zipArchive(t, "[Content_Types].xml", "_rels/.rels", "word/document.xml"), MimeDOCX},
+		{"pptx", zipArchive(t, "[Content_Types].xml", "_rels/.rels", "ppt/presentation.xml", "ppt/slides/slide1.xml"), MimePPTX},
+		{"plain zip", zipArchive(t, "notes.txt", "img/figure.png"), MimeZIP},
+		{"zip with a word folder not at the root", zipArchive(t, "backup/word/document.xml"), MimeZIP},
+		{"truncated zip", zipArchive(t, "word/document.xml")[:40], MimeZIP},
+		{"pdf", []byte("%PDF-1.7\n1 0 obj\n"), MimePDF},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), MimePNG},
+		{"jpeg", []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"), MimeJPEG},
+		{"html disguised as an image", []byte("<html><script>alert(1)</script>"), "text/html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SniffContentType(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if got != tt.want {
+				t.Errorf("SniffContentType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUpload(t *testing.T) {
+	policy := UploadPolicy{
+		Bucket: BucketResources,
+		Kinds: map[string]UploadKind{
+			MimePNG:  {MediaType: "IMAGE", MaxBytes: 1 << 20},
+			MimeDOCX: {MediaType: "DOCUMENT", MaxBytes: 2 << 20},
+			MimeZIP:  {MediaType: "OTHER", MaxBytes: 2 << 20},
+		},
+	}
+	tests := []struct {
+		name       string
+		sniffed    string
+		declared   string
+		size       int64
+		wantKind   string
+		wantStatus int
+	}{
+		{"accepted", MimePNG, MimePNG, 100, "IMAGE", 0},
+		{"no declared type", MimePNG, "", 100, "IMAGE", 0},
+		{"octet stream", MimeDOCX, "application/octet-stream", 100, "DOCUMENT", 0},
+		{"declared alias", MimeZIP, "application/x-zip-compressed", 100, "OTHER", 0},
+		{"declared with parameters", MimePNG, "image/png; charset=binary", 100, "IMAGE", 0},
+		{"type not in policy", MimePDF, MimePDF, 100, "", http.StatusUnsupportedMediaType},
+		{"declared type mismatch", MimeZIP, MimeDOCX, 100, "", http.StatusUnsupportedMediaType},
+		{"at the limit", MimePNG, MimePNG, 1 << 20, "IMAGE", 0},
+		{"over the limit", MimePNG, MimePNG, 1<<20 + 1, "", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, err := checkUpload(policy, tt.sniffed, tt.declared, tt.size)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("checkUpload() error = %v", err)
+				}
+				if kind.MediaType != tt.wantKind {
+					t.Errorf("checkUpload() kind = %q, want %q", kind.MediaType, tt.wantKind)
+				}
+				return
+			}
+			var serviceErr ServiceError
+			if !errors.As(err, &serviceErr) || serviceErr.Status != tt.wantStatus {
+				t.Fatalf("checkUpload() error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// zipArchive builds a ZIP file with an empty entry per name.
+func zipArchive(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, name := range names {
+		if _, err := archive.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -1,12 +1,12 @@
 package services
 
 import (
-	"context"
 	"os"
 	"time"
 
+	"fizicamd-backend-go/internal/config"
+
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"github.com/jmoiron/sqlx"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -14,19 +14,48 @@ import (
 	"github.com/shirou/gopsutil/v3/process"
 )
 
+// MetricSample is one reading of the server. HeapUsedBytes (process RSS) and
+// HeapMaxBytes (system memory) keep their names from the Java backend for the
+// existing dashboard; the Go heap is in HeapAllocBytes and HeapGoalBytes.
 type MetricSample struct {
-	CapturedAt        time.Time `json:"capturedAt"`
-	HeapUsedBytes     int64     `json:"heapUsedBytes"`
-	HeapMaxBytes      int64     `json:"heapMaxBytes"`
-	SystemMemoryTotal int64     `json:"systemMemoryTotalBytes"`
-	SystemMemoryUsed  int64     `json:"systemMemoryUsedBytes"`
-	DiskTotalBytes    int64     `json:"diskTotalBytes"`
-	DiskUsedBytes     int64     `json:"diskUsedBytes"`
-	ProcessCpuLoad    float64   `json:"processCpuLoad"`
-	SystemCpuLoad     float64   `json:"systemCpuLoad"`
+	InstanceID         string    `json:"instanceId"`
+	Hostname           string    `json:"hostname,omitempty"`
+	CapturedAt         time.Time `json:"capturedAt"`
+	HeapUsedBytes      int64     `json:"heapUsedBytes"`
+	HeapMaxBytes       int64     `json:"heapMaxBytes"`
+	SystemMemoryTotal  int64     `json:"systemMemoryTotalBytes"`
+	SystemMemoryUsed   int64     `json:"systemMemoryUsedBytes"`
+	DiskTotalBytes     int64     `json:"diskTotalBytes"`
+	DiskUsedBytes      int64     `json:"diskUsedBytes"`
+	ProcessCpuLoad     float64   `json:"processCpuLoad"`
+	SystemCpuLoad      float64   `json:"systemCpuLoad"`
+	HeapAllocBytes     int64     `json:"heapAllocBytes"`
+	HeapGoalBytes      int64     `json:"heapGoalBytes"`
+	GCCycles           int64     `json:"gcCycles"`
+	GCPauseP99Seconds  float64   `json:"gcPauseP99Seconds"`
+	Goroutines         int64     `json:"goroutines"`
+	DBOpenConnections  int       `json:"dbOpenConnections"`
+	DBInUseConnections int       `json:"dbInUseConnections"`
+}
+
+// MetricsInstance identifies the server process that took a sample.
+type MetricsInstance struct {
+	ID       string
+	Hostname string
+}
+
+// MetricsInstanceFor names this process INSTANCE_ID, or its hostname when
+// that is unset.
+func MetricsInstanceFor(cfg config.Config) MetricsInstance {
+	hostname, _ := os.Hostname()
+	id := cfg.InstanceID
+	if id == "" {
+		id = hostname
+	}
+	return MetricsInstance{ID: id, Hostname: hostname}
 }
 
-func CaptureMetrics(db *sqlx.DB, diskPath string) (MetricSample, error) {
+func CaptureMetrics(db *sqlx.DB, diskPath string, instance MetricsInstance) (MetricSample, error) {
 	proc, _ := process.NewProcess(int32(os.Getpid()))
 	memStat, _ := mem.VirtualMemory()
 	diskStat, err := disk.Usage(diskPath)
@@ -48,33 +77,66 @@ func CaptureMetrics(db *sqlx.DB, diskPath string) (MetricSample, error) {
 	if len(sysCPU) > 0 {
 		sysCPUValue = sysCPU[0] / 100.0
 	}
+	runtimeStats := readRuntimeStats()
+	dbStats := db.Stats()
 	sample := MetricSample{
-		CapturedAt:        time.Now().UTC(),
-		HeapUsedBytes:     processRSS,
-		HeapMaxBytes:      int64(memStat.Total),
-		SystemMemoryTotal: int64(memStat.Total),
-		SystemMemoryUsed:  int64(memStat.Total - memStat.Available),
-		DiskTotalBytes:    int64(diskStat.Total),
-		DiskUsedBytes:     int64(diskStat.Used),
-		ProcessCpuLoad:    processCPU,
-		SystemCpuLoad:     sysCPUValue,
+		InstanceID:         instance.ID,
+		Hostname:           instance.Hostname,
+		CapturedAt:         time.Now().UTC(),
+		HeapUsedBytes:      processRSS,
+		HeapMaxBytes:       int64(memStat.Total),
+		SystemMemoryTotal:  int64(memStat.Total),
+		SystemMemoryUsed:   int64(memStat.Total - memStat.Available),
+		DiskTotalBytes:     int64(diskStat.Total),
+		DiskUsedBytes:      int64(diskStat.Used),
+		ProcessCpuLoad:     processCPU,
+		SystemCpuLoad:      sysCPUValue,
+		HeapAllocBytes:     runtimeStats.HeapAllocBytes,
+		HeapGoalBytes:      runtimeStats.HeapGoalBytes,
+		GCCycles:           runtimeStats.GCCycles,
+		GCPauseP99Seconds:  runtimeStats.GCPauseP99,
+		Goroutines:         runtimeStats.Goroutines,
+		DBOpenConnections:  dbStats.OpenConnections,
+		DBInUseConnections: dbStats.InUse,
 	}
 
 	_, err = db.Exec(`
 INSERT INTO server_metric_samples (
   id, captured_at, heap_used_bytes, heap_max_bytes, system_memory_total_bytes,
-  system_memory_used_bytes, disk_total_bytes, disk_used_bytes, process_cpu_load, system_cpu_load
-) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+  system_memory_used_bytes, disk_total_bytes, disk_used_bytes, process_cpu_load, system_cpu_load,
+  heap_alloc_bytes, heap_goal_bytes, gc_cycles, gc_pause_p99_seconds, goroutines,
+  db_open_connections, db_in_use_connections, instance_id, hostname
+) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19)
 `, uuid.NewString(), sample.CapturedAt, sample.HeapUsedBytes, sample.HeapMaxBytes, sample.SystemMemoryTotal,
-		sample.SystemMemoryUsed, sample.DiskTotalBytes, sample.DiskUsedBytes, sample.ProcessCpuLoad, sample.SystemCpuLoad)
+		sample.SystemMemoryUsed, sample.DiskTotalBytes, sample.DiskUsedBytes, sample.ProcessCpuLoad, sample.SystemCpuLoad,
+		sample.HeapAllocBytes, sample.HeapGoalBytes, sample.GCCycles, sample.GCPauseP99Seconds, sample.Goroutines,
+		sample.DBOpenConnections, sample.DBInUseConnections, sample.InstanceID, sample.Hostname)
 	if err != nil {
 		return MetricSample{}, err
 	}
 	return sample, nil
 }
 
-func LatestMetrics(db *sqlx.DB, limit int) ([]MetricSample, error) {
+// LatestMetrics returns the last limit samples, oldest first, of one
+// instance or, when instanceID is empty, of all of them.
+func LatestMetrics(db *sqlx.DB, limit int, instanceID string) ([]MetricSample, error) {
+	rows, err := selectMetricSamples(db, `WHERE ($2 = '' OR instance_id = $2) ORDER BY captured_at DESC LIMIT $1`, limit, instanceID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]MetricSample, 0, len(rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		items = append(items, rows[i])
+	}
+	return items, nil
+}
+
+// selectMetricSamples reads raw samples; clause holds the WHERE, ORDER BY and
+// LIMIT parts.
+func selectMetricSamples(db *sqlx.DB, clause string, args ...interface{}) ([]MetricSample, error) {
 	type row struct {
+		InstanceID        string    `db:"instance_id"`
+		Hostname          string    `db:"hostname"`
 		CapturedAt        time.Time `db:"captured_at"`
 		HeapUsedBytes     int64     `db:"heap_used_bytes"`
 		HeapMaxBytes      int64     `db:"heap_max_bytes"`
@@ -84,70 +146,46 @@ func LatestMetrics(db *sqlx.DB, limit int) ([]MetricSample, error) {
 		DiskUsedBytes     int64     `db:"disk_used_bytes"`
 		ProcessCpuLoad    float64   `db:"process_cpu_load"`
 		SystemCpuLoad     float64   `db:"system_cpu_load"`
+		HeapAllocBytes    int64     `db:"heap_alloc_bytes"`
+		HeapGoalBytes     int64     `db:"heap_goal_bytes"`
+		GCCycles          int64     `db:"gc_cycles"`
+		GCPauseP99        float64   `db:"gc_pause_p99_seconds"`
+		Goroutines        int64     `db:"goroutines"`
+		DBOpen            int       `db:"db_open_connections"`
+		DBInUse           int       `db:"db_in_use_connections"`
 	}
 	rows := []row{}
 	if err := db.Select(&rows, `
-SELECT captured_at, heap_used_bytes, heap_max_bytes, system_memory_total_bytes,
-       system_memory_used_bytes, disk_total_bytes, disk_used_bytes, process_cpu_load, system_cpu_load
+SELECT instance_id, hostname, captured_at, heap_used_bytes, heap_max_bytes, system_memory_total_bytes,
+       system_memory_used_bytes, disk_total_bytes, disk_used_bytes, process_cpu_load, system_cpu_load,
+       heap_alloc_bytes, heap_goal_bytes, gc_cycles, gc_pause_p99_seconds, goroutines,
+       db_open_connections, db_in_use_connections
 FROM server_metric_samples
-ORDER BY captured_at DESC
-LIMIT $1
-`, limit); err != nil {
+`+clause, args...); err != nil {
 		return nil, err
 	}
 	items := make([]MetricSample, 0, len(rows))
-	for i := len(rows) - 1; i >= 0; i-- {
+	for _, row := range rows {
 		items = append(items, MetricSample{
-			CapturedAt:        rows[i].CapturedAt,
-			HeapUsedBytes:     rows[i].HeapUsedBytes,
-			HeapMaxBytes:      rows[i].HeapMaxBytes,
-			SystemMemoryTotal: rows[i].SystemMemoryTotal,
-			SystemMemoryUsed:  rows[i].SystemMemoryUsed,
-			DiskTotalBytes:    rows[i].DiskTotalBytes,
-			DiskUsedBytes:     rows[i].DiskUsedBytes,
-			ProcessCpuLoad:    rows[i].ProcessCpuLoad,
-			SystemCpuLoad:     rows[i].SystemCpuLoad,
+			InstanceID:         row.InstanceID,
+			Hostname:           row.Hostname,
+			CapturedAt:         row.CapturedAt,
+			HeapUsedBytes:      row.HeapUsedBytes,
+			HeapMaxBytes:       row.HeapMaxBytes,
+			SystemMemoryTotal:  row.SystemMemoryTotal,
+			SystemMemoryUsed:   row.SystemMemoryUsed,
+			DiskTotalBytes:     row.DiskTotalBytes,
+			DiskUsedBytes:      row.DiskUsedBytes,
+			ProcessCpuLoad:     row.ProcessCpuLoad,
+			SystemCpuLoad:      row.SystemCpuLoad,
+			HeapAllocBytes:     row.HeapAllocBytes,
+			HeapGoalBytes:      row.HeapGoalBytes,
+			GCCycles:           row.GCCycles,
+			GCPauseP99Seconds:  row.GCPauseP99,
+			Goroutines:         row.Goroutines,
+			DBOpenConnections:  row.DBOpen,
+			DBInUseConnections: row.DBInUse,
 		})
 	}
 	return items, nil
 }
-
-type MetricsHub struct {
-	clients map[*websocket.Conn]bool
-	ch      chan MetricSample
-}
-
-func NewMetricsHub() *MetricsHub {
-	return &MetricsHub{
-		clients: map[*websocket.Conn]bool{},
-		ch:      make(chan MetricSample, 16),
-	}
-}
-
-func (h *MetricsHub) Run(ctx context.Context) {
-	for {
-		select {
-		case sample := <-h.ch:
-			for conn := range h.clients {
-				_ = conn.WriteJSON(sample)
-			}
-		case <-ctx.Done():
-			return
-		}
-	}
-}
-
-func (h *MetricsHub) Broadcast(sample MetricSample) {
-	select {
-	case h.ch <- sample:
-	default:
-	}
-}
-
-func (h *MetricsHub) Add(conn *websocket.Conn) {
-	h.clients[conn] = true
-}
-
-func (h *MetricsHub) Remove(conn *websocket.Conn) {
-	delete(h.clients, conn)
-}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// Samples and alerts are published on a Postgres channel so that every
+// instance's hub can forward them to its own WebSocket clients.
+const metricsChannel = "server_metrics"
+
+type metricsNotification struct {
+	Sample *MetricSample `json:"sample,omitempty"`
+	Alert  *Alert        `json:"alert,omitempty"`
+}
+
+func PublishMetricSample(db *sqlx.DB, sample MetricSample) error {
+	return publishMetrics(db, metricsNotification{Sample: &sample})
+}
+
+func PublishAlert(db *sqlx.DB, alert Alert) error {
+	return publishMetrics(db, metricsNotification{Alert: &alert})
+}
+
+func publishMetrics(db *sqlx.DB, notification metricsNotification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`SELECT pg_notify($1, $2)`, metricsChannel, string(payload))
+	return err
+}
+
+// ListenMetrics holds a pool connection listening on the metrics channel and
+// forwards what other instances publish to hub. The local instance's own
+// messages are skipped since the hub already has them. It returns when ctx
+// is done or the connection fails.
+func ListenMetrics(ctx context.Context, db *sqlx.DB, hub *MetricsHub, instanceID string) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+metricsChannel); err != nil {
+			return err
+		}
+		defer func() {
+			if !pgConn.IsClosed() {
+				_, _ = pgConn.Exec(context.Background(), "UNLISTEN *")
+			}
+		}()
+		for {
+			received, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			var notification metricsNotification
+			if json.Unmarshal([]byte(received.Payload), &notification) != nil {
+				continue
+			}
+			switch {
+			case notification.Sample != nil && notification.Sample.InstanceID != instanceID:
+				hub.Relay(*notification.Sample)
+			case notification.Alert != nil && notification.Alert.InstanceID != instanceID:
+				hub.BroadcastAlert(*notification.Alert)
+			}
+		}
+	})
+}
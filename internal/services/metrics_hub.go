@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	metricsClientQueue = 8
+	metricsWriteWait   = 10 * time.Second
+	metricsPongWait    = 60 * time.Second
+	metricsPingPeriod  = metricsPongWait * 9 / 10
+	metricsReadLimit   = 512
+)
+
+// MetricsHub fans samples and alerts out to WebSocket and SSE clients. Each client has its own
+// writer goroutine and a bounded queue; a client whose queue is full is
+// disconnected instead of delaying the others.
+type MetricsHub struct {
+	mu      sync.Mutex
+	clients map[*metricsClient]struct{}
+	closed  bool
+	writers sync.WaitGroup
+	latest  atomic.Pointer[MetricSample]
+}
+
+type metricsClient struct {
+	conn     *websocket.Conn
+	send     chan interface{}
+	quit     chan struct{}
+	stopOnce sync.Once
+	code     int
+	reason   string
+}
+
+func NewMetricsHub() *MetricsHub {
+	return &MetricsHub{clients: map[*metricsClient]struct{}{}}
+}
+
+// Run closes every client when ctx is done.
+func (h *MetricsHub) Run(ctx context.Context) {
+	<-ctx.Done()
+	h.Close()
+}
+
+// Close disconnects every client with a "going away" close frame and waits
+// for the frames to be written. Later connections are refused.
+func (h *MetricsHub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	for client := range h.clients {
+		client.stop(websocket.CloseGoingAway, "server shutting down")
+		delete(h.clients, client)
+	}
+	h.mu.Unlock()
+	h.writers.Wait()
+}
+
+// AlertMessage is how alerts are told apart from samples on the socket.
+type AlertMessage struct {
+	Type  string `json:"type"`
+	Alert Alert  `json:"alert"`
+}
+
+// Broadcast queues sample for every client without blocking.
+func (h *MetricsHub) Broadcast(sample MetricSample) {
+	h.latest.Store(&sample)
+	h.publish(sample)
+}
+
+// Relay queues a sample taken by another instance. Unlike Broadcast it does
+// not change Latest, which describes this process.
+func (h *MetricsHub) Relay(sample MetricSample) {
+	h.publish(sample)
+}
+
+// BroadcastAlert pushes an alert that fired or resolved to every client.
+func (h *MetricsHub) BroadcastAlert(alert Alert) {
+	h.publish(AlertMessage{Type: "alert", Alert: alert})
+}
+
+func (h *MetricsHub) publish(message interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients {
+		select {
+		case client.send <- message:
+		default:
+			client.stop(websocket.CloseTryAgainLater, "client too slow")
+			delete(h.clients, client)
+		}
+	}
+}
+
+// Latest returns the most recently broadcast sample.
+func (h *MetricsHub) Latest() (MetricSample, bool) {
+	sample := h.latest.Load()
+	if sample == nil {
+		return MetricSample{}, false
+	}
+	return *sample, true
+}
+
+// Serve streams samples and alerts to conn until the client disconnects, stops
+// answering pings, falls behind or the hub closes. It owns conn and closes
+// it before returning.
+func (h *MetricsHub) Serve(conn *websocket.Conn) {
+	client := newMetricsClient(conn)
+	if !h.register(client) {
+		client.stop(websocket.CloseGoingAway, "server shutting down")
+		client.writeClose()
+		return
+	}
+	go func() {
+		defer h.writers.Done()
+		client.writeLoop()
+	}()
+	client.readLoop()
+	h.unregister(client)
+	client.stop(websocket.CloseNormalClosure, "")
+}
+
+// MetricsSubscription receives the hub's messages without a WebSocket, e.g.
+// for a Server-Sent Events response. Its owner writes the messages itself.
+type MetricsSubscription struct {
+	hub       *MetricsHub
+	client    *metricsClient
+	closeOnce sync.Once
+}
+
+// Subscribe adds a subscriber, or returns nil once the hub is closed. The
+// subscription must be closed when the caller is done with it.
+func (h *MetricsHub) Subscribe() *MetricsSubscription {
+	client := newMetricsClient(nil)
+	if !h.register(client) {
+		return nil
+	}
+	return &MetricsSubscription{hub: h, client: client}
+}
+
+// Messages delivers samples and AlertMessages.
+func (s *MetricsSubscription) Messages() <-chan interface{} {
+	return s.client.send
+}
+
+// Done is closed when the hub drops the subscriber, because it fell behind
+// or the hub is closing.
+func (s *MetricsSubscription) Done() <-chan struct{} {
+	return s.client.quit
+}
+
+func (s *MetricsSubscription) Close() {
+	s.closeOnce.Do(func() {
+		s.hub.unregister(s.client)
+		s.client.stop(websocket.CloseNormalClosure, "")
+		s.hub.writers.Done()
+	})
+}
+
+func newMetricsClient(conn *websocket.Conn) *metricsClient {
+	return &metricsClient{
+		conn: conn,
+		send: make(chan interface{}, metricsClientQueue),
+		quit: make(chan struct{}),
+	}
+}
+
+// register adds client unless the hub is closed. Every registered client
+// counts as a writer until it is done writing, which Close waits for.
+func (h *MetricsHub) register(client *metricsClient) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.clients[client] = struct{}{}
+	h.writers.Add(1)
+	return true
+}
+
+func (h *MetricsHub) unregister(client *metricsClient) {
+	h.mu.Lock()
+	delete(h.clients, client)
+	h.mu.Unlock()
+}
+
+func (c *metricsClient) stop(code int, reason string) {
+	c.stopOnce.Do(func() {
+		c.code, c.reason = code, reason
+		close(c.quit)
+	})
+}
+
+// readLoop discards client messages and keeps the read deadline moving while
+// pongs arrive.
+func (c *metricsClient) readLoop() {
+	c.conn.SetReadLimit(metricsReadLimit)
+	_ = c.conn.SetReadDeadline(time.Now().Add(metricsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(metricsPongWait))
+	})
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *metricsClient) writeLoop() {
+	ticker := time.NewTicker(metricsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case message := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(metricsWriteWait))
+			if err := c.conn.WriteJSON(message); err != nil {
+				c.stop(websocket.CloseAbnormalClosure, "")
+				_ = c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(metricsWriteWait)); err != nil {
+				c.stop(websocket.CloseAbnormalClosure, "")
+				_ = c.conn.Close()
+				return
+			}
+		case <-c.quit:
+			c.writeClose()
+			return
+		}
+	}
+}
+
+// writeClose sends the close frame chosen by stop and closes the connection,
+// which also ends the read loop.
+func (c *metricsClient) writeClose() {
+	if c.code != websocket.CloseAbnormalClosure {
+		message := websocket.FormatCloseMessage(c.code, c.reason)
+		_ = c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(metricsWriteWait))
+	}
+	_ = c.conn.Close()
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"fizicamd-backend-go/internal/config"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Raw samples are rolled into per-minute and per-hour aggregates stored one
+// row per instance and metric in server_metric_rollups, then pruned.
+const (
+	MetricsResolutionRaw    = "raw"
+	MetricsResolutionMinute = "1m"
+	MetricsResolutionHour   = "1h"
+	MetricsResolutionAuto   = "auto"
+
+	maxMetricPoints = 5000
+)
+
+// sampleColumn maps a numeric server_metric_samples column to its field.
+type sampleColumn struct {
+	name string
+	set  func(sample *MetricSample, value float64)
+}
+
+var sampleColumns = []sampleColumn{
+	{"heap_used_bytes", func(s *MetricSample, v float64) { s.HeapUsedBytes = int64(v) }},
+	{"heap_max_bytes", func(s *MetricSample, v float64) { s.HeapMaxBytes = int64(v) }},
+	{"system_memory_total_bytes", func(s *MetricSample, v float64) { s.SystemMemoryTotal = int64(v) }},
+	{"system_memory_used_bytes", func(s *MetricSample, v float64) { s.SystemMemoryUsed = int64(v) }},
+	{"disk_total_bytes", func(s *MetricSample, v float64) { s.DiskTotalBytes = int64(v) }},
+	{"disk_used_bytes", func(s *MetricSample, v float64) { s.DiskUsedBytes = int64(v) }},
+	{"process_cpu_load", func(s *MetricSample, v float64) { s.ProcessCpuLoad = v }},
+	{"system_cpu_load", func(s *MetricSample, v float64) { s.SystemCpuLoad = v }},
+	{"heap_alloc_bytes", func(s *MetricSample, v float64) { s.HeapAllocBytes = int64(v) }},
+	{"heap_goal_bytes", func(s *MetricSample, v float64) { s.HeapGoalBytes = int64(v) }},
+	{"gc_cycles", func(s *MetricSample, v float64) { s.GCCycles = int64(v) }},
+	{"gc_pause_p99_seconds", func(s *MetricSample, v float64) { s.GCPauseP99Seconds = v }},
+	{"goroutines", func(s *MetricSample, v float64) { s.Goroutines = int64(v) }},
+	{"db_open_connections", func(s *MetricSample, v float64) { s.DBOpenConnections = int(v) }},
+	{"db_in_use_connections", func(s *MetricSample, v float64) { s.DBInUseConnections = int(v) }},
+}
+
+// MetricPoint is a sample or, for rollups, the bucket averages with the
+// bucket minimum and maximum alongside.
+type MetricPoint struct {
+	MetricSample
+	Min *MetricSample `json:"min,omitempty"`
+	Max *MetricSample `json:"max,omitempty"`
+}
+
+// MetricsRetention controls how long each tier is kept.
+type MetricsRetention struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+}
+
+func MetricsRetentionFor(cfg config.Config) MetricsRetention {
+	return MetricsRetention{
+		Raw:    time.Duration(cfg.MetricsRawRetentionHours) * time.Hour,
+		Minute: time.Duration(cfg.MetricsMinuteRetentionDays) * 24 * time.Hour,
+		Hour:   time.Duration(cfg.MetricsHourRetentionDays) * 24 * time.Hour,
+	}
+}
+
+type MetricsRollupReport struct {
+	MinuteBuckets int64
+	HourBuckets   int64
+	Pruned        int64
+}
+
+// RollupMetrics aggregates complete minutes and hours that are not rolled up
+// yet and prunes data older than the retention of its tier. Data is only
+// pruned once the next tier covers it. Each instance has its own watermark, so
+// an instance that lags behind or rejoins after a pause is not skipped past the
+// others' latest bucket. The last bucket of each instance and tier is
+// recomputed on every run, so repeated runs are harmless.
+func RollupMetrics(db *sqlx.DB, retention MetricsRetention, now time.Time) (MetricsRollupReport, error) {
+	report := MetricsRollupReport{}
+	now = now.UTC()
+
+	values := make([]string, 0, len(sampleColumns))
+	for _, column := range sampleColumns {
+		values = append(values, fmt.Sprintf("('%s', s.%s::float8)", column.name, column.name))
+	}
+	minuteEnd := now.Truncate(time.Minute)
+	result, err := db.Exec(`
+WITH watermarks AS (
+  SELECT instance_id, MAX(bucket_start) AS bucket_start
+  FROM server_metric_rollups WHERE resolution = $1 GROUP BY instance_id
+)
+INSERT INTO server_metric_rollups (resolution, instance_id, bucket_start, metric, min_value, avg_value, max_value, sample_count)
+SELECT $1, s.instance_id, date_trunc('minute', s.captured_at), m.metric, MIN(m.value), AVG(m.value), MAX(m.value), COUNT(*)
+FROM server_metric_samples s
+LEFT JOIN watermarks w ON w.instance_id = s.instance_id
+CROSS JOIN LATERAL (VALUES `+strings.Join(values, ", ")+`) AS m(metric, value)
+WHERE s.captured_at >= COALESCE(w.bucket_start, '-infinity')
+  AND s.captured_at < $2
+GROUP BY 2, 3, 4
+ON CONFLICT (resolution, instance_id, bucket_start, metric) DO UPDATE
+SET min_value = EXCLUDED.min_value, avg_value = EXCLUDED.avg_value,
+    max_value = EXCLUDED.max_value, sample_count = EXCLUDED.sample_count
+`, MetricsResolutionMinute, minuteEnd)
+	if err != nil {
+		return report, err
+	}
+	report.MinuteBuckets, _ = result.RowsAffected()
+
+	hourEnd := now.Truncate(time.Hour)
+	result, err = db.Exec(`
+WITH watermarks AS (
+  SELECT instance_id, MAX(bucket_start) AS bucket_start
+  FROM server_metric_rollups WHERE resolution = $1 GROUP BY instance_id
+)
+INSERT INTO server_metric_rollups (resolution, instance_id, bucket_start, metric, min_value, avg_value, max_value, sample_count)
+SELECT $1, r.instance_id, date_trunc('hour', r.bucket_start), r.metric, MIN(r.min_value),
+       SUM(r.avg_value * r.sample_count) / SUM(r.sample_count), MAX(r.max_value), SUM(r.sample_count)
+FROM server_metric_rollups r
+LEFT JOIN watermarks w ON w.instance_id = r.instance_id
+WHERE r.resolution = $2
+  AND r.bucket_start >= COALESCE(w.bucket_start, '-infinity')
+  AND r.bucket_start < $3
+GROUP BY 2, 3, 4
+ON CONFLICT (resolution, instance_id, bucket_start, metric) DO UPDATE
+SET min_value = EXCLUDED.min_value, avg_value = EXCLUDED.avg_value,
+    max_value = EXCLUDED.max_value, sample_count = EXCLUDED.sample_count
+`, MetricsResolutionHour, MetricsResolutionMinute, hourEnd)
+	if err != nil {
+		return report, err
+	}
+	report.HourBuckets, _ = result.RowsAffected()
+
+	prune := []struct {
+		query  string
+		cutoff time.Time
+	}{
+		{`DELETE FROM server_metric_samples WHERE captured_at < $1`, minTime(now.Add(-retention.Raw), minuteEnd)},
+		{`DELETE FROM server_metric_rollups WHERE resolution = '1m' AND bucket_start < $1`, minTime(now.Add(-retention.Minute), hourEnd)},
+		{`DELETE FROM server_metric_rollups WHERE resolution = '1h' AND bucket_start < $1`, now.Add(-retention.Hour)},
+	}
+	for _, step := range prune {
+		result, err := db.Exec(step.query, step.cutoff)
+		if err != nil {
+			return report, err
+		}
+		affected, _ := result.RowsAffected()
+		report.Pruned += affected
+	}
+	return report, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// PickMetricsResolution chooses the tier for a range: raw samples for short
+// recent ranges, minutes up to three days, hours beyond. Ranges reaching past
+// a tier's retention use the next tier.
+func PickMetricsResolution(from, to time.Time, retention MetricsRetention, now time.Time) string {
+	span := to.Sub(from)
+	switch {
+	case span <= 2*time.Hour && !from.Before(now.Add(-retention.Raw)):
+		return MetricsResolutionRaw
+	case span <= 72*time.Hour && !from.Before(now.Add(-retention.Minute)):
+		return MetricsResolutionMinute
+	default:
+		return MetricsResolutionHour
+	}
+}
+
+// MetricsRange returns the points between from and to at resolution, oldest
+// first, capped at maxMetricPoints. An empty instanceID returns the points of
+// every instance, each tagged with its instance.
+func MetricsRange(db *sqlx.DB, resolution string, from, to time.Time, instanceID string) ([]MetricPoint, error) {
+	if resolution == MetricsResolutionRaw {
+		samples, err := selectMetricSamples(db, `
+WHERE captured_at >= $1 AND captured_at < $2 AND ($4 = '' OR instance_id = $4)
+ORDER BY captured_at, instance_id LIMIT $3`, from, to, maxMetricPoints, instanceID)
+		if err != nil {
+			return nil, err
+		}
+		points := make([]MetricPoint, 0, len(samples))
+		for _, sample := range samples {
+			points = append(points, MetricPoint{MetricSample: sample})
+		}
+		return points, nil
+	}
+	rows := []struct {
+		InstanceID  string    `db:"instance_id"`
+		BucketStart time.Time `db:"bucket_start"`
+		Metric      string    `db:"metric"`
+		Min         float64   `db:"min_value"`
+		Avg         float64   `db:"avg_value"`
+		Max         float64   `db:"max_value"`
+	}{}
+	err := db.Select(&rows, `
+SELECT instance_id, bucket_start, metric, min_value, avg_value, max_value
+FROM server_metric_rollups
+WHERE resolution = $1 AND bucket_start >= $2 AND bucket_start < $3 AND ($5 = '' OR instance_id = $5)
+ORDER BY bucket_start, instance_id, metric
+LIMIT $4
+`, resolution, from, to, maxMetricPoints*len(sampleColumns), instanceID)
+	if err != nil {
+		return nil, err
+	}
+	setters := map[string]func(*MetricSample, float64){}
+	for _, column := range sampleColumns {
+		setters[column.name] = column.set
+	}
+	points := []MetricPoint{}
+	for _, row := range rows {
+		if len(points) == 0 || !points[len(points)-1].CapturedAt.Equal(row.BucketStart) || points[len(points)-1].InstanceID != row.InstanceID {
+			points = append(points, MetricPoint{
+				MetricSample: MetricSample{InstanceID: row.InstanceID, CapturedAt: row.BucketStart},
+				Min:          &MetricSample{InstanceID: row.InstanceID, CapturedAt: row.BucketStart},
+				Max:          &MetricSample{InstanceID: row.InstanceID, CapturedAt: row.BucketStart},
+			})
+		}
+		set, ok := setters[row.Metric]
+		if !ok {
+			continue
+		}
+		point := &points[len(points)-1]
+		set(&point.MetricSample, row.Avg)
+		set(point.Min, row.Min)
+		set(point.Max, row.Max)
+	}
+	return points, nil
+}
+
+// MetricsInstanceInfo is an instance seen in the raw samples.
+type MetricsInstanceInfo struct {
+	ID         string    `db:"instance_id" json:"instanceId"`
+	Hostname   string    `db:"hostname" json:"hostname"`
+	LastSeenAt time.Time `db:"last_seen_at" json:"lastSeenAt"`
+}
+
+// ListMetricsInstances lists the instances that recorded raw samples, most
+// recently seen first.
+func ListMetricsInstances(db *sqlx.DB) ([]MetricsInstanceInfo, error) {
+	items := []MetricsInstanceInfo{}
+	err := db.Select(&items, `
+SELECT DISTINCT ON (instance_id) instance_id, hostname, captured_at AS last_seen_at
+FROM server_metric_samples
+ORDER BY instance_id, captured_at DESC
+`)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].LastSeenAt.After(items[j].LastSeenAt) })
+	return items, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"fizicamd-backend-go/internal/config"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var rollupRetention = MetricsRetention{Raw: 48 * time.Hour, Minute: 14 * 24 * time.Hour, Hour: 365 * 24 * time.Hour}
+
+// perInstanceWatermark requires the rollup to resume each instance from its
+// own latest bucket rather than from the latest bucket of any instance.
+const perInstanceWatermark = `WITH watermarks AS \(\s+SELECT instance_id, MAX\(bucket_start\) AS bucket_start\s+FROM server_metric_rollups WHERE resolution = \$1 GROUP BY instance_id\s+\)`
+
+func TestRollupMetrics(t *testing.T) {
+	db, mock := newMockDB(t)
+	now := time.Date(2026, 3, 10, 14, 37, 42, 0, time.UTC)
+	minuteEnd := time.Date(2026, 3, 10, 14, 37, 0, 0, time.UTC)
+	hourEnd := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec(perInstanceWatermark+`.*FROM server_metric_samples s\s+LEFT JOIN watermarks w ON w.instance_id = s.instance_id`+
+		`.*WHERE s.captured_at >= COALESCE\(w.bucket_start, '-infinity'\)`).
+		WithArgs(MetricsResolutionMinute, minuteEnd).WillReturnResult(sqlmock.NewResult(0, 30))
+	mock.ExpectExec(perInstanceWatermark+`.*FROM server_metric_rollups r\s+LEFT JOIN watermarks w ON w.instance_id = r.instance_id`).
+		WithArgs(MetricsResolutionHour, MetricsResolutionMinute, hourEnd).WillReturnResult(sqlmock.NewResult(0, 15))
+	mock.ExpectExec(`DELETE FROM server_metric_samples WHERE captured_at < \$1`).
+		WithArgs(now.Add(-rollupRetention.Raw)).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(`DELETE FROM server_metric_rollups WHERE resolution = '1m'`).
+		WithArgs(now.Add(-rollupRetention.Minute)).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM server_metric_rollups WHERE resolution = '1h'`).
+		WithArgs(now.Add(-rollupRetention.Hour)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	report, err := RollupMetrics(db, rollupRetention, now)
+	if err != nil {
+		t.Fatalf("RollupMetrics() error = %v", err)
+	}
+	if report != (MetricsRollupReport{MinuteBuckets: 30, HourBuckets: 15, Pruned: 7}) {
+		t.Errorf("report = %+v", report)
+	}
+}
+
+func TestRollupMetricsKeepsDataTheNextTierLacks(t *testing.T) {
+	db, mock := newMockDB(t)
+	now := time.Date(2026, 3, 10, 14, 37, 42, 0, time.UTC)
+	// With retention shorter than a bucket, pruning stops at the start of the
+	// bucket not rolled up yet.
+	short := MetricsRetention{Raw: time.Second, Minute: time.Second, Hour: time.Hour}
+	mock.ExpectExec(`FROM server_metric_samples s`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`FROM server_metric_rollups r`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM server_metric_samples`).WithArgs(now.Truncate(time.Minute)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`resolution = '1m'`).WithArgs(now.Truncate(time.Hour)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`resolution = '1h'`).WithArgs(now.Add(-time.Hour)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if _, err := RollupMetrics(db, short, now); err != nil {
+		t.Fatalf("RollupMetrics() error = %v", err)
+	}
+}
+
+func TestRollupMetricsStopsOnError(t *testing.T) {
+	db, mock := newMockDB(t)
+	boom := errors.New("connection reset")
+	mock.ExpectExec(`FROM server_metric_samples s`).WillReturnError(boom)
+
+	if _, err := RollupMetrics(db, rollupRetention, time.Now()); !errors.Is(err, boom) {
+		t.Fatalf("error = %v, want %v", err, boom)
+	}
+}
+
+func TestPickMetricsResolution(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want string
+	}{
+		{"last hour", now.Add(-time.Hour), now, MetricsResolutionRaw},
+		{"short range past raw retention", now.Add(-72 * time.Hour), now.Add(-71 * time.Hour), MetricsResolutionMinute},
+		{"last day", now.Add(-24 * time.Hour), now, MetricsResolutionMinute},
+		{"last week", now.Add(-7 * 24 * time.Hour), now, MetricsResolutionHour},
+		{"short range past minute retention", now.Add(-30 * 24 * time.Hour), now.Add(-29 * 24 * time.Hour), MetricsResolutionHour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PickMetricsResolution(tt.from, tt.to, rollupRetention, now); got != tt.want {
+				t.Errorf("PickMetricsResolution() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsInstanceFor(t *testing.T) {
+	if instance := MetricsInstanceFor(config.Config{InstanceID: "api-2"}); instance.ID != "api-2" {
+		t.Errorf("ID = %q, want the configured INSTANCE_ID", instance.ID)
+	}
+	if instance := MetricsInstanceFor(config.Config{}); instance.ID == "" || instance.ID != instance.Hostname {
+		t.Errorf("instance = %+v, want the hostname as ID", instance)
+	}
+}
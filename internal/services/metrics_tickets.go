@@ -0,0 +1,59 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MetricsTicketTTL is how long a ticket can wait before being redeemed.
+const MetricsTicketTTL = 30 * time.Second
+
+// IssueMetricsTicket creates a one-time ticket that opens a metrics stream
+// for userID. Browsers cannot set headers on WebSocket or EventSource
+// requests, so the ticket goes in the URL instead of the access token. Only
+// its hash is stored, in the database so any instance can redeem it.
+func IssueMetricsTicket(db *sqlx.DB, userID string) (string, time.Time, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now().UTC()
+	expiresAt := now.Add(MetricsTicketTTL)
+	if _, err := db.Exec(`DELETE FROM metrics_tickets WHERE expires_at < $1`, now); err != nil {
+		return "", time.Time{}, err
+	}
+	_, err := db.Exec(`INSERT INTO metrics_tickets (ticket_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		hashMetricsTicket(ticket), userID, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return ticket, expiresAt, nil
+}
+
+// RedeemMetricsTicket consumes a ticket and returns the user it was issued
+// to. Unknown, used and expired tickets are rejected alike.
+func RedeemMetricsTicket(db *sqlx.DB, ticket string) (string, error) {
+	var userID string
+	err := db.Get(&userID, `
+DELETE FROM metrics_tickets
+WHERE ticket_hash = $1
+RETURNING CASE WHEN expires_at > $2 THEN user_id::text ELSE '' END
+`, hashMetricsTicket(ticket), time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && userID == "") {
+		return "", ErrUnauthorized("Authentication failed")
+	}
+	return userID, err
+}
+
+func hashMetricsTicket(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+// newMockDB returns a sqlx handle backed by sqlmock. Expected queries are
+// matched as regular expressions, and every expectation must be met by the
+// end of the test.
+func newMockDB(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New(
+		sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp),
+		sqlmock.ValueConverterOption(pgxValueConverter{}),
+	)
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	db := sqlx.NewDb(conn, "pgx")
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+		_ = db.Close()
+	})
+	return db, mock
+}
+
+// pgxValueConverter accepts slices as query arguments, as pgx does for
+// Postgres arrays, and otherwise converts like database/sql.
+type pgxValueConverter struct{}
+
+func (pgxValueConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	if value, err := driver.DefaultParameterConverter.ConvertValue(v); err == nil {
+		return value, nil
+	}
+	if reflect.TypeOf(v).Kind() == reflect.Slice {
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported argument type %T", v)
+}
+
+// wantStatus fails the test unless err is a ServiceError with status, or nil
+// when status is 0.
+func wantStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	if status == 0 {
+		if err != nil {
+			t.Fatalf("error = %v, want nil", err)
+		}
+		return
+	}
+	var serviceErr ServiceError
+	if !errors.As(err, &serviceErr) || serviceErr.Status != status {
+		t.Fatalf("error = %v, want status %d", err, status)
+	}
+}
+
+// memoryStorage is an in-memory ObjectStorage keyed by bucket/key.
+type memoryStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{objects: map[string][]byte{}}
+}
+
+func (m *memoryStorage) Put(ctx context.Context, bucket, key string, body io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[bucket+"/"+key] = data
+	return nil
+}
+
+func (m *memoryStorage) Get(ctx context.Context, bucket, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[bucket+"/"+key]
+	if !ok {
+		return nil, ObjectInfo{}, os.ErrNotExist
+	}
+	return nopSeekCloser{bytes.NewReader(data)}, ObjectInfo{Size: int64(len(data))}, nil
+}
+
+func (m *memoryStorage) Stat(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[bucket+"/"+key]
+	if !ok {
+		return ObjectInfo{}, os.ErrNotExist
+	}
+	return ObjectInfo{Size: int64(len(data))}, nil
+}
+
+func (m *memoryStorage) Delete(ctx context.Context, bucket, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, bucket+"/"+key)
+	return nil
+}
+
+func (m *memoryStorage) Presign(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	return "", errors.New("presign not supported")
+}
+
+func (m *memoryStorage) has(bucket, key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.objects[bucket+"/"+key]
+	return ok
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
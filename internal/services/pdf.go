@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"rsc.io/pdf"
+)
+
+const (
+	PDFThumbnailWidth   = 480
+	pdfThumbnailTimeout = 30 * time.Second
+	maxPDFInfoLength    = 500
+)
+
+// PDFInfo is the document information stored under "pdf" in the metadata of
+// PDF assets.
+type PDFInfo struct {
+	PageCount int    `json:"pageCount"`
+	Title     string `json:"title,omitempty"`
+	Author    string `json:"author,omitempty"`
+}
+
+// readPDFInfo reads the page count and the Title and Author entries of the
+// document information dictionary. Encrypted or damaged files yield false.
+func readPDFInfo(file io.ReaderAt, size int64) (info PDFInfo, ok bool) {
+	// The parser panics on some malformed files instead of returning errors.
+	defer func() {
+		if recover() != nil {
+			info, ok = PDFInfo{}, false
+		}
+	}()
+	reader, err := pdf.NewReader(file, size)
+	if err != nil {
+		return PDFInfo{}, false
+	}
+	documentInfo := reader.Trailer().Key("Info")
+	return PDFInfo{
+		PageCount: reader.NumPage(),
+		Title:     cleanPDFText(documentInfo.Key("Title").Text()),
+		Author:    cleanPDFText(documentInfo.Key("Author").Text()),
+	}, true
+}
+
+func cleanPDFText(value string) string {
+	value = strings.Join(strings.Fields(strings.ToValidUTF8(value, "")), " ")
+	if len(value) > maxPDFInfoLength {
+		value = strings.ToValidUTF8(value[:maxPDFInfoLength], "")
+	}
+	return value
+}
+
+// renderPDFThumbnail renders the first page of the PDF at path as a JPEG
+// PDFThumbnailWidth pixels wide with pdftoppm. A missing binary disables
+// thumbnails and yields nil.
+func renderPDFThumbnail(ctx context.Context, command, path string) (*encodedVariant, error) {
+	if command == "" {
+		return nil, nil
+	}
+	binary, err := exec.LookPath(command)
+	if err != nil {
+		return nil, nil
+	}
+	dir, err := os.MkdirTemp("", "pdf-thumb-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithTimeout(ctx, pdfThumbnailTimeout)
+	defer cancel()
+	prefix := filepath.Join(dir, "page")
+	cmd := exec.CommandContext(ctx, binary,
+		"-f", "1", "-l", "1", "-singlefile",
+		"-scale-to-x", strconv.Itoa(PDFThumbnailWidth), "-scale-to-y", "-1",
+		"-jpeg", "-jpegopt", fmt.Sprintf("quality=%d", variantJPEGQuality),
+		path, prefix)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pdftoppm: %v: %s", err, bytes.TrimSpace(output))
+	}
+	data, err := os.ReadFile(prefix + ".jpg")
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &encodedVariant{width: cfg.Width, height: cfg.Height, data: data}, nil
+}
+
+// RenderPDFPreview stores the first-page thumbnail of a PDF asset. pdftoppm
+// only ever sees files the scanner passed: assets that are not READY, or
+// already have a thumbnail, are skipped. A failed render is recorded in the
+// metadata so RenderPendingPDFPreviews does not retry it.
+func RenderPDFPreview(ctx context.Context, db *sqlx.DB, store ObjectStorage, command, assetID string) error {
+	if command == "" || !validUUID(assetID) {
+		return nil
+	}
+	row := struct {
+		Bucket     string `db:"bucket"`
+		StorageKey string `db:"storage_key"`
+	}{}
+	err := db.Get(&row, `
+SELECT m.bucket, m.storage_key FROM media_assets m
+WHERE m.id = $1::uuid AND m.content_type = $2 AND m.status = $3
+  AND NOT EXISTS (SELECT 1 FROM media_asset_variants v WHERE v.asset_id = m.id)
+`, assetID, MimePDF, MediaStatusReady)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	reader, _, err := store.Get(ctx, row.Bucket, row.StorageKey)
+	if err != nil {
+		return err
+	}
+	spool, err := os.CreateTemp("", "pdf-*")
+	if err != nil {
+		_ = reader.Close()
+		return err
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+	_, err = io.Copy(spool, reader)
+	_ = reader.Close()
+	if err != nil {
+		return err
+	}
+	thumbnail, renderErr := renderPDFThumbnail(ctx, command, spool.Name())
+	if renderErr != nil {
+		_, err := db.Exec(`
+UPDATE media_assets SET metadata = COALESCE(metadata, '{}'::jsonb) || '{"pdfThumbnailFailed": true}'::jsonb
+WHERE id = $1::uuid`, assetID)
+		if err != nil {
+			return err
+		}
+		return renderErr
+	}
+	if thumbnail == nil {
+		return nil
+	}
+	return storeImageVariants(db, store, row.Bucket, assetID, []encodedVariant{*thumbnail})
+}
+
+// RenderPendingPDFPreviews renders the thumbnails of up to limit READY PDFs
+// that have none yet, e.g. those scanned by the background loop, each bounded
+// by timeout. It returns how many it rendered.
+func RenderPendingPDFPreviews(db *sqlx.DB, store ObjectStorage, command string, timeout time.Duration, limit int) (int, error) {
+	if command == "" {
+		return 0, nil
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		return 0, nil
+	}
+	ids := []string{}
+	err := db.Select(&ids, `
+SELECT m.id FROM media_assets m
+WHERE m.content_type = $1 AND m.status = $2
+  AND NOT COALESCE((m.metadata->>'pdfThumbnailFailed')::boolean, false)
+  AND NOT EXISTS (SELECT 1 FROM media_asset_variants v WHERE v.asset_id = m.id)
+ORDER BY m.created_at
+LIMIT $3
+`, MimePDF, MediaStatusReady, limit)
+	if err != nil {
+		return 0, err
+	}
+	rendered := 0
+	for _, id := range ids {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := RenderPDFPreview(ctx, db, store, command, id)
+		cancel()
+		if err != nil {
+			continue
+		}
+		rendered++
+	}
+	return rendered, nil
+}
+
+// PDFPreview is what readers see of a PDF before downloading it.
+type PDFPreview struct {
+	PageCount      int     `json:"pageCount"`
+	Title          *string `json:"title,omitempty"`
+	Author         *string `json:"author,omitempty"`
+	ThumbnailWidth *int    `json:"-"`
+}
+
+// GetPDFPreviews loads the stored PDF information of the given assets in one
+// query, keyed by asset ID. Assets without it, e.g. PDFs uploaded before it
+// was extracted, are left out.
+func GetPDFPreviews(db *sqlx.DB, assetIDs []string) (map[string]PDFPreview, error) {
+	previews := map[string]PDFPreview{}
+	ids := make([]string, 0, len(assetIDs))
+	for _, id := range assetIDs {
+		if validUUID(id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return previews, nil
+	}
+	rows := []struct {
+		ID             string `db:"id"`
+		Info           []byte `db:"info"`
+		ThumbnailWidth *int   `db:"thumbnail_width"`
+	}{}
+	err := db.Select(&rows, `
+SELECT m.id, m.metadata->'pdf' AS info,
+       (SELECT MIN(v.width) FROM media_asset_variants v WHERE v.asset_id = m.id) AS thumbnail_width
+FROM media_assets m
+WHERE m.id = ANY($1::uuid[]) AND m.content_type = $2 AND m.metadata ? 'pdf'
+`, ids, MimePDF)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		var info PDFInfo
+		if err := json.Unmarshal(row.Info, &info); err != nil {
+			continue
+		}
+		previews[row.ID] = PDFPreview{
+			PageCount:      info.PageCount,
+			Title:          nullIfBlank(info.Title),
+			Author:         nullIfBlank(info.Author),
+			ThumbnailWidth: row.ThumbnailWidth,
+		}
+	}
+	return previews, nil
+}
+
+func nullIfBlank(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"fizicamd-backend-go/internal/config"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// StorageQuota is the space an owner may use for media. LimitBytes 0 means
+// unlimited.
+type StorageQuota struct {
+	LimitBytes int64
+	UsedBytes  int64
+}
+
+// Available returns the bytes that may still be stored and false when the
+// quota is unlimited.
+func (q StorageQuota) Available() (int64, bool) {
+	if q.LimitBytes <= 0 {
+		return 0, false
+	}
+	if q.UsedBytes >= q.LimitBytes {
+		return 0, true
+	}
+	return q.LimitBytes - q.UsedBytes, true
+}
+
+// Check fails when size more bytes would exceed the quota.
+func (q StorageQuota) Check(size int64) error {
+	available, limited := q.Available()
+	if limited && size > available {
+		return ErrTooLarge(fmt.Sprintf("Spațiul de stocare este insuficient: folosiți %d MB din %d MB.", q.UsedBytes>>20, q.LimitBytes>>20))
+	}
+	return nil
+}
+
+// RoleQuotaBytes returns the configured quota of a role; 0 means unlimited.
+func RoleQuotaBytes(cfg config.Config, role string) int64 {
+	switch strings.ToUpper(role) {
+	case "ADMIN":
+		return megabytes(cfg.StorageQuotaAdminMB)
+	case "TEACHER":
+		return megabytes(cfg.StorageQuotaTeacherMB)
+	default:
+		return megabytes(cfg.StorageQuotaStudentMB)
+	}
+}
+
+// QuotaLimitForRoles picks the most generous quota among roles.
+func QuotaLimitForRoles(cfg config.Config, roles []string) int64 {
+	if len(roles) == 0 {
+		return RoleQuotaBytes(cfg, "STUDENT")
+	}
+	var limit int64
+	for i, role := range roles {
+		value := RoleQuotaBytes(cfg, role)
+		if value <= 0 {
+			return 0
+		}
+		if i == 0 || value > limit {
+			limit = value
+		}
+	}
+	return limit
+}
+
+// StorageUsedBytes sums the size of the media assets owned by a user.
+func StorageUsedBytes(db *sqlx.DB, ownerID string) (int64, error) {
+	var used int64
+	err := db.Get(&used, `SELECT COALESCE(SUM(size_bytes), 0) FROM media_assets WHERE owner_user_id = $1`, ownerID)
+	return used, err
+}
+
+// QuotaFor loads the quota and current usage of a user.
+func QuotaFor(db *sqlx.DB, cfg config.Config, userID string) (StorageQuota, error) {
+	roles, err := FetchRoles(db, userID)
+	if err != nil {
+		return StorageQuota{}, err
+	}
+	used, err := StorageUsedBytes(db, userID)
+	if err != nil {
+		return StorageQuota{}, err
+	}
+	return StorageQuota{LimitBytes: QuotaLimitForRoles(cfg, roles), UsedBytes: used}, nil
+}
+
+type StorageTypeUsage struct {
+	Type  string `db:"type" json:"type"`
+	Bytes int64  `db:"bytes" json:"bytes"`
+	Count int    `db:"count" json:"count"`
+}
+
+// StorageUsageByType breaks a user's usage down by media type.
+func StorageUsageByType(db *sqlx.DB, ownerID string) ([]StorageTypeUsage, error) {
+	items := []StorageTypeUsage{}
+	err := db.Select(&items, `
+SELECT type, COALESCE(SUM(size_bytes), 0) AS bytes, COUNT(*) AS count
+FROM media_assets
+WHERE owner_user_id = $1
+GROUP BY type
+ORDER BY bytes DESC
+`, ownerID)
+	return items, err
+}
+
+type StorageConsumer struct {
+	UserID     string  `db:"user_id" json:"userId"`
+	Email      string  `db:"email" json:"email"`
+	UsedBytes  int64   `db:"used_bytes" json:"usedBytes"`
+	AssetCount int     `db:"asset_count" json:"assetCount"`
+	LimitBytes *int64  `db:"-" json:"limitBytes"`
+	UsedRatio  float64 `db:"-" json:"usedRatio"`
+}
+
+// TopStorageConsumers lists the users storing the most media.
+func TopStorageConsumers(db *sqlx.DB, cfg config.Config, limit int) ([]StorageConsumer, error) {
+	rows := []struct {
+		StorageConsumer
+		Roles string `db:"roles"`
+	}{}
+	err := db.Select(&rows, `
+SELECT u.id AS user_id, u.email, SUM(m.size_bytes) AS used_bytes, COUNT(*) AS asset_count,
+       COALESCE((SELECT string_agg(r.code, ',' ORDER BY r.code)
+                 FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+                 WHERE ur.user_id = u.id), '') AS roles
+FROM media_assets m
+JOIN users u ON u.id = m.owner_user_id
+GROUP BY u.id, u.email
+ORDER BY used_bytes DESC
+LIMIT $1
+`, limit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]StorageConsumer, len(rows))
+	for i, row := range rows {
+		items[i] = row.StorageConsumer
+		roles := []string{}
+		if row.Roles != "" {
+			roles = strings.Split(row.Roles, ",")
+		}
+		if quota := QuotaLimitForRoles(cfg, roles); quota > 0 {
+			items[i].LimitBytes = &quota
+			items[i].UsedRatio = float64(items[i].UsedBytes) / float64(quota)
+		}
+	}
+	return items, nil
+}
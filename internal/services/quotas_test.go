@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"fizicamd-backend-go/internal/config"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var quotaConfig = config.Config{StorageQuotaStudentMB: 10, StorageQuotaTeacherMB: 100, StorageQuotaAdminMB: 0}
+
+func TestStorageQuotaCheck(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name       string
+		quota      StorageQuota
+		size       int64
+		wantStatus int
+	}{
+		{"unlimited", StorageQuota{LimitBytes: 0, UsedBytes: 50 * mb}, 100 * mb, 0},
+		{"fits", StorageQuota{LimitBytes: 10 * mb, UsedBytes: 4 * mb}, 6 * mb, 0},
+		{"one byte over", StorageQuota{LimitBytes: 10 * mb, UsedBytes: 4 * mb}, 6*mb + 1, http.StatusRequestEntityTooLarge},
+		{"already over the limit", StorageQuota{LimitBytes: 10 * mb, UsedBytes: 12 * mb}, 1, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantStatus(t, tt.quota.Check(tt.size), tt.wantStatus)
+		})
+	}
+}
+
+func TestQuotaLimitForRoles(t *testing.T) {
+	tests := []struct {
+		roles []string
+		want  int64
+	}{
+		{nil, 10 << 20},
+		{[]string{"STUDENT"}, 10 << 20},
+		{[]string{"student", "TEACHER"}, 100 << 20},
+		{[]string{"TEACHER", "ADMIN"}, 0},
+	}
+	for _, tt := range tests {
+		if got := QuotaLimitForRoles(quotaConfig, tt.roles); got != tt.want {
+			t.Errorf("QuotaLimitForRoles(%v) = %d, want %d", tt.roles, got, tt.want)
+		}
+	}
+}
+
+func TestQuotaFor(t *testing.T) {
+	const user = "11111111-1111-1111-1111-111111111111"
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT r.code\s+FROM roles r`).WithArgs(user).WillReturnRows(sqlmock.NewRows([]string{"code"}).AddRow("TEACHER"))
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(size_bytes\), 0\) FROM media_assets WHERE owner_user_id = \$1`).WithArgs(user).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(5 << 20))
+
+	quota, err := QuotaFor(db, quotaConfig, user)
+	if err != nil {
+		t.Fatalf("QuotaFor() error = %v", err)
+	}
+	if quota.LimitBytes != 100<<20 || quota.UsedBytes != 5<<20 {
+		t.Errorf("quota = %+v", quota)
+	}
+}
+
+func TestQuotaForRolesError(t *testing.T) {
+	db, mock := newMockDB(t)
+	boom := errors.New("connection reset")
+	mock.ExpectQuery(`FROM roles r`).WillReturnError(boom)
+	if _, err := QuotaFor(db, quotaConfig, "user"); !errors.Is(err, boom) {
+		t.Fatalf("error = %v, want %v", err, boom)
+	}
+}
+
+func TestTopStorageConsumers(t *testing.T) {
+	db, mock := newMockDB(t)
+	// Roles come back aggregated with the usage, in a single query.
+	mock.ExpectQuery(`string_agg\(r.code, ','`).WithArgs(3).WillReturnRows(
+		sqlmock.NewRows([]string{"user_id", "email", "used_bytes", "asset_count", "roles"}).
+			AddRow("u1", "admin@example.com", 500<<20, 40, "ADMIN,TEACHER").
+			AddRow("u2", "teacher@example.com", 50<<20, 7, "TEACHER").
+			AddRow("u3", "student@example.com", 5<<20, 2, ""))
+
+	items, err := TopStorageConsumers(db, quotaConfig, 3)
+	if err != nil {
+		t.Fatalf("TopStorageConsumers() error = %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3", len(items))
+	}
+	if items[0].LimitBytes != nil || items[0].UsedRatio != 0 {
+		t.Errorf("admin = %+v, want no limit", items[0])
+	}
+	if items[1].LimitBytes == nil || *items[1].LimitBytes != 100<<20 || items[1].UsedRatio != 0.5 {
+		t.Errorf("teacher = %+v, want half of 100 MB", items[1])
+	}
+	if items[2].LimitBytes == nil || *items[2].LimitBytes != 10<<20 || items[2].UsedRatio != 0.5 {
+		t.Errorf("user without roles = %+v, want half of the student quota", items[2])
+	}
+}
+
+func TestCreateUploadOverQuota(t *testing.T) {
+	db, _ := newMockDB(t)
+	policy := UploadPolicy{
+		Kinds: map[string]UploadKind{MimeZIP: {MediaType: "archive", MaxBytes: 100 << 20}},
+		Quota: &StorageQuota{LimitBytes: 10 << 20, UsedBytes: 8 << 20},
+	}
+	_, err := CreateUpload(db, t.TempDir(), policy, "user", "big.zip", "", 3<<20, 0)
+	wantStatus(t, err, http.StatusRequestEntityTooLarge)
+}
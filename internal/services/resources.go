@@ -12,14 +12,6 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
-var blockTypes = map[string]bool{
-	"TEXT":    true,
-	"LINK":    true,
-	"IMAGE":   true,
-	"PDF":     true,
-	"FORMULA": true,
-}
-
 func Slugify(value string) string {
 	lower := strings.ToLower(strings.TrimSpace(value))
 	var b strings.Builder
@@ -103,64 +95,10 @@ func NormalizeRequired(value, message string) (string, error) {
 	return trimmed, nil
 }
 
-type Block struct {
-	Type    string  `json:"type"`
-	Text    *string `json:"text,omitempty"`
-	URL     *string `json:"url,omitempty"`
-	AssetID *string `json:"assetId,omitempty"`
-	Caption *string `json:"caption,omitempty"`
-	Title   *string `json:"title,omitempty"`
-}
-
-func ValidateBlocks(raw json.RawMessage) ([]Block, error) {
-	if len(raw) == 0 {
-		return []Block{}, nil
-	}
-	var blocks []Block
-	if err := json.Unmarshal(raw, &blocks); err != nil {
-		return nil, err
-	}
-	cleaned := make([]Block, 0, len(blocks))
-	for _, block := range blocks {
-		blockType := strings.ToUpper(strings.TrimSpace(block.Type))
-		if !blockTypes[blockType] {
-			continue
-		}
-		switch blockType {
-		case "TEXT":
-			if block.Text != nil {
-				text := strings.TrimSpace(*block.Text)
-				if text != "" {
-					cleaned = append(cleaned, Block{Type: "TEXT", Text: &text, Title: block.Title})
-				}
-			}
-		case "LINK":
-			if block.URL != nil {
-				url := strings.TrimSpace(*block.URL)
-				if url != "" {
-					title := block.Title
-					if title == nil {
-						fallback := "Link"
-						title = &fallback
-					}
-					cleaned = append(cleaned, Block{Type: "LINK", URL: &url, Title: title})
-				}
-			}
-		case "IMAGE", "PDF":
-			if block.AssetID == nil || strings.TrimSpace(*block.AssetID) == "" {
-				return nil, errors.New("Încărcarea fișierului pentru blocurile media este obligatorie.")
-			}
-			asset := strings.TrimSpace(*block.AssetID)
-			cleaned = append(cleaned, Block{Type: blockType, AssetID: &asset, Caption: block.Caption, Title: block.Title})
-		case "FORMULA":
-			if block.Text == nil || strings.TrimSpace(*block.Text) == "" {
-				return nil, errors.New("Formula nu poate fi goală.")
-			}
-			text := strings.TrimSpace(*block.Text)
-			cleaned = append(cleaned, Block{Type: "FORMULA", Text: &text, Title: block.Title})
-		}
-	}
-	return cleaned, nil
+// validUUID reports whether value is a UUID in the canonical textual form,
+// so lookups by a malformed ID can answer "not found" without a query.
+func validUUID(value string) bool {
+	return len(value) == 36 && uuid.Validate(value) == nil
 }
 
 func CleanSearchTerm(term string) string {
@@ -169,3 +107,37 @@ func CleanSearchTerm(term string) string {
 	cleaned = re.ReplaceAllString(cleaned, " ")
 	return cleaned
 }
+
+// RebuildSearchText recomputes search_text from the stored blocks of every
+// resource, or only of those whose search_text is still empty unless all is
+// set. It returns the number of resources updated.
+func RebuildSearchText(db *sqlx.DB, all bool) (int, error) {
+	rows := []struct {
+		ID         string `db:"id"`
+		Content    []byte `db:"content"`
+		SearchText string `db:"search_text"`
+	}{}
+	if err := db.Select(&rows, `
+SELECT id, content, search_text FROM resource_entries
+WHERE $1 OR search_text = ''
+ORDER BY created_at
+`, all); err != nil {
+		return 0, err
+	}
+	updated := 0
+	for _, row := range rows {
+		var items []json.RawMessage
+		if err := json.Unmarshal(row.Content, &items); err != nil {
+			continue
+		}
+		searchText := BlockSearchText(items)
+		if searchText == row.SearchText {
+			continue
+		}
+		if _, err := db.Exec(`UPDATE resource_entries SET search_text = $2 WHERE id = $1`, row.ID, searchText); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+)
+
+const (
+	metricHeapObjects = "/memory/classes/heap/objects:bytes"
+	metricHeapGoal    = "/gc/heap/goal:bytes"
+	metricGCCycles    = "/gc/cycles/total:gc-cycles"
+	metricGCPauses    = "/sched/pauses/total/gc:seconds"
+	metricGoroutines  = "/sched/goroutines:goroutines"
+)
+
+// RuntimeStats are the Go runtime figures recorded with each metric sample.
+type RuntimeStats struct {
+	HeapAllocBytes int64
+	HeapGoalBytes  int64
+	GCCycles       int64
+	GCPauseP99     float64
+	Goroutines     int64
+}
+
+// pauseHistory keeps the GC pause histogram of the previous sample so the p99
+// covers only the pauses since then.
+var pauseHistory struct {
+	mu     sync.Mutex
+	counts []uint64
+}
+
+func readRuntimeStats() RuntimeStats {
+	samples := []metrics.Sample{
+		{Name: metricHeapObjects},
+		{Name: metricHeapGoal},
+		{Name: metricGCCycles},
+		{Name: metricGCPauses},
+		{Name: metricGoroutines},
+	}
+	metrics.Read(samples)
+	stats := RuntimeStats{}
+	for _, sample := range samples {
+		switch sample.Name {
+		case metricHeapObjects:
+			stats.HeapAllocBytes = uint64Value(sample)
+		case metricHeapGoal:
+			stats.HeapGoalBytes = uint64Value(sample)
+		case metricGCCycles:
+			stats.GCCycles = uint64Value(sample)
+		case metricGoroutines:
+			stats.Goroutines = uint64Value(sample)
+		case metricGCPauses:
+			if sample.Value.Kind() == metrics.KindFloat64Histogram {
+				stats.GCPauseP99 = recentPauseP99(sample.Value.Float64Histogram())
+			}
+		}
+	}
+	return stats
+}
+
+func uint64Value(sample metrics.Sample) int64 {
+	if sample.Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(sample.Value.Uint64())
+}
+
+// recentPauseP99 returns the 99th percentile of the GC pauses recorded since
+// the previous call, or 0 when there were none.
+func recentPauseP99(hist *metrics.Float64Histogram) float64 {
+	pauseHistory.mu.Lock()
+	defer pauseHistory.mu.Unlock()
+	delta := make([]uint64, len(hist.Counts))
+	var total uint64
+	for i, count := range hist.Counts {
+		if len(pauseHistory.counts) == len(hist.Counts) && count >= pauseHistory.counts[i] {
+			count -= pauseHistory.counts[i]
+		}
+		delta[i] = count
+		total += count
+	}
+	pauseHistory.counts = append(pauseHistory.counts[:0], hist.Counts...)
+	if total == 0 {
+		return 0
+	}
+	threshold := uint64(math.Ceil(float64(total) * 0.99))
+	var seen uint64
+	for i, count := range delta {
+		seen += count
+		if seen >= threshold {
+			// Buckets[i+1] is the upper bound of Counts[i]; the last bucket
+			// may be unbounded.
+			upper := hist.Buckets[i+1]
+			if math.IsInf(upper, 1) {
+				return hist.Buckets[i]
+			}
+			return upper
+		}
+	}
+	return 0
+}
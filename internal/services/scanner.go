@@ -0,0 +1,270 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"fizicamd-backend-go/internal/config"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	MediaStatusPendingScan = "PENDING_SCAN"
+	MediaStatusReady       = "READY"
+	MediaStatusQuarantined = "QUARANTINED"
+	// MediaStatusScanFailed marks files the scanner gave up on. Like
+	// quarantined files they are never served.
+	MediaStatusScanFailed = "SCAN_FAILED"
+)
+
+// A file that could not be scanned is retried after scanRetryDelay, doubling
+// with each attempt up to scanRetryMaxDelay, and marked SCAN_FAILED after
+// maxScanAttempts.
+const (
+	maxScanAttempts   = 5
+	scanRetryDelay    = time.Minute
+	scanRetryMaxDelay = time.Hour
+)
+
+// ErrScanTooLarge is returned when clamd refuses a file above its
+// StreamMaxLength. Retrying cannot help, so the file is given up on at once.
+var ErrScanTooLarge = errors.New("clamd: file exceeds StreamMaxLength")
+
+const (
+	ScannerBackendNone  = "none"
+	ScannerBackendClamd = "clamd"
+)
+
+const clamdChunkSize = 64 << 10
+
+type ScanResult struct {
+	Clean     bool
+	Signature string
+}
+
+// Scanner inspects file content for malware.
+type Scanner interface {
+	Scan(ctx context.Context, body io.Reader) (ScanResult, error)
+}
+
+// OpenScanner builds the configured scanner ("none" or "clamd").
+func OpenScanner(cfg config.Config) (Scanner, error) {
+	switch strings.ToLower(cfg.ScannerBackend) {
+	case "", ScannerBackendNone:
+		return NoopScanner{}, nil
+	case ScannerBackendClamd:
+		return NewClamdScanner(cfg.ClamdAddress)
+	}
+	return nil, fmt.Errorf("unknown scanner backend: %s", cfg.ScannerBackend)
+}
+
+// NoopScanner reports every file as clean. It is meant for development
+// setups without a clamd daemon.
+type NoopScanner struct{}
+
+func (NoopScanner) Scan(ctx context.Context, body io.Reader) (ScanResult, error) {
+	return ScanResult{Clean: true}, nil
+}
+
+// ClamdScanner streams files to a clamd daemon with the INSTREAM command.
+type ClamdScanner struct {
+	network string
+	address string
+}
+
+// NewClamdScanner accepts "tcp://host:port" or "unix:///path/to/clamd.ctl".
+func NewClamdScanner(address string) (*ClamdScanner, error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		return &ClamdScanner{network: "tcp", address: strings.TrimPrefix(address, "tcp://")}, nil
+	case strings.HasPrefix(address, "unix://"):
+		return &ClamdScanner{network: "unix", address: strings.TrimPrefix(address, "unix://")}, nil
+	}
+	return nil, fmt.Errorf("invalid clamd address: %s", address)
+}
+
+func (c *ClamdScanner) Scan(ctx context.Context, body io.Reader) (ScanResult, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, err
+	}
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return ScanResult{}, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return ScanResult{}, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, readErr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, err
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return ScanResult{}, err
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply reads "stream: OK", "stream: <signature> FOUND" or
+// "<message> ERROR".
+func parseClamdReply(reply string) (ScanResult, error) {
+	switch {
+	case strings.Contains(reply, "size limit exceeded"):
+		return ScanResult{}, ErrScanTooLarge
+	case strings.HasSuffix(reply, " OK"):
+		return ScanResult{Clean: true}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		return ScanResult{Clean: false, Signature: signature}, nil
+	}
+	return ScanResult{}, fmt.Errorf("clamd: %s", reply)
+}
+
+// ScanAsset scans a PENDING_SCAN asset and marks it READY or QUARANTINED. On
+// scanner errors the attempt is recorded and the asset stays pending until a
+// later pass retries it, or becomes SCAN_FAILED once retries are exhausted.
+func ScanAsset(ctx context.Context, db *sqlx.DB, store ObjectStorage, scanner Scanner, assetID string) (string, error) {
+	row := struct {
+		Bucket       string `db:"bucket"`
+		StorageKey   string `db:"storage_key"`
+		Status       string `db:"status"`
+		ScanAttempts int    `db:"scan_attempts"`
+	}{}
+	if err := db.Get(&row, `SELECT bucket, storage_key, status, scan_attempts FROM media_assets WHERE id = $1`, assetID); err != nil {
+		return "", err
+	}
+	if row.Status != MediaStatusPendingScan {
+		return row.Status, nil
+	}
+	reader, _, err := store.Get(ctx, row.Bucket, row.StorageKey)
+	if err != nil {
+		return recordScanFailure(db, assetID, row.ScanAttempts+1, err)
+	}
+	result, err := scanner.Scan(ctx, reader)
+	_ = reader.Close()
+	if err != nil {
+		return recordScanFailure(db, assetID, row.ScanAttempts+1, err)
+	}
+	status := MediaStatusReady
+	scan := map[string]interface{}{"scannedAt": time.Now().UTC().Format(time.RFC3339)}
+	if !result.Clean {
+		status = MediaStatusQuarantined
+		scan["signature"] = result.Signature
+	}
+	scanJSON, _ := json.Marshal(map[string]interface{}{"scan": scan})
+	_, err = db.Exec(`
+UPDATE media_assets
+SET status = $2, metadata = COALESCE(metadata, '{}'::jsonb) || $3::jsonb, scan_error = NULL, scan_retry_at = NULL, updated_at = $4
+WHERE id = $1 AND status = $5
+`, assetID, status, string(scanJSON), time.Now().UTC(), MediaStatusPendingScan)
+	if err != nil {
+		return row.Status, err
+	}
+	return status, nil
+}
+
+// recordScanFailure stores attempt and scanErr on a pending asset and
+// schedules the next attempt, or gives up on the asset. It returns the new
+// status along with scanErr.
+func recordScanFailure(db *sqlx.DB, assetID string, attempt int, scanErr error) (string, error) {
+	status := MediaStatusPendingScan
+	if attempt >= maxScanAttempts || errors.Is(scanErr, ErrScanTooLarge) {
+		status = MediaStatusScanFailed
+	}
+	now := time.Now().UTC()
+	_, err := db.Exec(`
+UPDATE media_assets
+SET status = $2, scan_attempts = $3, scan_error = $4, scan_retry_at = $5, updated_at = $6
+WHERE id = $1 AND status = $7
+`, assetID, status, attempt, scanErr.Error(), now.Add(scanRetryBackoff(attempt)), now, MediaStatusPendingScan)
+	if err != nil {
+		return MediaStatusPendingScan, errors.Join(scanErr, err)
+	}
+	return status, scanErr
+}
+
+// scanRetryBackoff is the delay before the attempt after attempt.
+func scanRetryBackoff(attempt int) time.Duration {
+	delay := scanRetryDelay
+	for i := 1; i < attempt && delay < scanRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, scanRetryMaxDelay)
+}
+
+type ScanReport struct {
+	Scanned     int `json:"scanned"`
+	Quarantined int `json:"quarantined"`
+	Failed      int `json:"failed"`
+	GaveUp      int `json:"gaveUp"`
+}
+
+// ScanPendingAssets scans up to limit assets waiting for a scan whose retry
+// delay has passed, oldest first, each bounded by timeout. It returns
+// ErrJobRunning when another instance is already scanning.
+func ScanPendingAssets(db *sqlx.DB, store ObjectStorage, scanner Scanner, timeout time.Duration, limit int) (ScanReport, error) {
+	report := ScanReport{}
+	err := withAdvisoryLock(db, "media_scan", ErrJobRunning, func() error {
+		ids := []string{}
+		err := db.Select(&ids, `
+SELECT id FROM media_assets
+WHERE status = $1 AND (scan_retry_at IS NULL OR scan_retry_at <= now())
+ORDER BY created_at
+LIMIT $2
+`, MediaStatusPendingScan, limit)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			status, err := ScanAsset(ctx, db, store, scanner, id)
+			cancel()
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+			case err != nil:
+				report.Failed++
+				if status == MediaStatusScanFailed {
+					report.GaveUp++
+				}
+			case status == MediaStatusQuarantined:
+				report.Scanned++
+				report.Quarantined++
+			case status == MediaStatusReady:
+				report.Scanned++
+			}
+		}
+		return nil
+	})
+	return report, err
+}
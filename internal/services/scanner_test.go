@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"fizicamd-backend-go/internal/config"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type stubScanner struct {
+	result ScanResult
+	err    error
+}
+
+func (s stubScanner) Scan(ctx context.Context, body io.Reader) (ScanResult, error) {
+	_, _ = io.Copy(io.Discard, body)
+	return s.result, s.err
+}
+
+const scannedAsset = "88888888-8888-8888-8888-888888888888"
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply   string
+		want    ScanResult
+		wantErr error
+	}{
+		{"stream: OK", ScanResult{Clean: true}, nil},
+		{"stream: Eicar-Test-Signature FOUND", ScanResult{Signature: "Eicar-Test-Signature"}, nil},
+		{"INSTREAM size limit exceeded. ERROR", ScanResult{}, ErrScanTooLarge},
+	}
+	for _, tt := range tests {
+		got, err := parseClamdReply(tt.reply)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("parseClamdReply(%q) = %+v, %v", tt.reply, got, err)
+		}
+	}
+	if _, err := parseClamdReply("Can't allocate memory ERROR"); err == nil {
+		t.Error("parseClamdReply() accepted an error reply")
+	}
+}
+
+func TestScanRetryBackoff(t *testing.T) {
+	for attempt, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 7: time.Hour, 50: time.Hour} {
+		if got := scanRetryBackoff(attempt); got != want {
+			t.Errorf("scanRetryBackoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func expectPendingAsset(mock sqlmock.Sqlmock, attempts int) {
+	mock.ExpectQuery(`SELECT bucket, storage_key, status, scan_attempts FROM media_assets WHERE id = \$1`).WithArgs(scannedAsset).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "storage_key", "status", "scan_attempts"}).AddRow("media", "file.pdf", MediaStatusPendingScan, attempts))
+}
+
+func TestScanAssetFailures(t *testing.T) {
+	unavailable := errors.New("dial tcp: connection refused")
+	tests := []struct {
+		name       string
+		attempts   int
+		scanErr    error
+		wantStatus string
+	}{
+		{"retried later", 0, unavailable, MediaStatusPendingScan},
+		{"given up after the last attempt", maxScanAttempts - 1, unavailable, MediaStatusScanFailed},
+		{"too large for clamd", 0, ErrScanTooLarge, MediaStatusScanFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			store := newMemoryStorage()
+			store.objects["media/file.pdf"] = []byte("%PDF")
+			expectPendingAsset(mock, tt.attempts)
+			mock.ExpectExec(`UPDATE media_assets\s+SET status = \$2, scan_attempts = \$3, scan_error = \$4, scan_retry_at = \$5`).
+				WithArgs(scannedAsset, tt.wantStatus, tt.attempts+1, tt.scanErr.Error(), sqlmock.AnyArg(), sqlmock.AnyArg(), MediaStatusPendingScan).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			status, err := ScanAsset(context.Background(), db, store, stubScanner{err: tt.scanErr}, scannedAsset)
+			if !errors.Is(err, tt.scanErr) || status != tt.wantStatus {
+				t.Fatalf("ScanAsset() = %q, %v, want %q", status, err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestScanAssetMissingObject(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectPendingAsset(mock, 2)
+	mock.ExpectExec(`UPDATE media_assets\s+SET status = \$2, scan_attempts = \$3`).
+		WithArgs(scannedAsset, MediaStatusPendingScan, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), MediaStatusPendingScan).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := ScanAsset(context.Background(), db, newMemoryStorage(), stubScanner{}, scannedAsset); err == nil {
+		t.Fatal("ScanAsset() succeeded without an object to scan")
+	}
+}
+
+func TestScanAssetClean(t *testing.T) {
+	db, mock := newMockDB(t)
+	store := newMemoryStorage()
+	store.objects["media/file.pdf"] = []byte("%PDF")
+	expectPendingAsset(mock, 3)
+	mock.ExpectExec(`SET status = \$2, metadata = .*scan_error = NULL, scan_retry_at = NULL`).
+		WithArgs(scannedAsset, MediaStatusReady, sqlmock.AnyArg(), sqlmock.AnyArg(), MediaStatusPendingScan).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	status, err := ScanAsset(context.Background(), db, store, stubScanner{result: ScanResult{Clean: true}}, scannedAsset)
+	if err != nil || status != MediaStatusReady {
+		t.Fatalf("ScanAsset() = %q, %v, want READY", status, err)
+	}
+}
+
+func TestScanPendingAssets(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`pg_try_advisory_lock`).WithArgs("media_scan").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectQuery(`SELECT id FROM media_assets\s+WHERE status = \$1 AND \(scan_retry_at IS NULL OR scan_retry_at <= now\(\)\)`).
+		WithArgs(MediaStatusPendingScan, 10).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(scannedAsset))
+	expectPendingAsset(mock, maxScanAttempts-1)
+	mock.ExpectExec(`UPDATE media_assets\s+SET status = \$2, scan_attempts`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`pg_advisory_unlock`).WithArgs("media_scan").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	store := newMemoryStorage()
+	store.objects["media/file.pdf"] = []byte("%PDF")
+	report, err := ScanPendingAssets(db, store, stubScanner{err: errors.New("timeout")}, time.Second, 10)
+	if err != nil {
+		t.Fatalf("ScanPendingAssets() error = %v", err)
+	}
+	if report != (ScanReport{Failed: 1, GaveUp: 1}) {
+		t.Errorf("report = %+v, want one failure given up on", report)
+	}
+}
+
+func TestScanPendingAssetsBusy(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`pg_try_advisory_lock`).WithArgs("media_scan").WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+
+	if _, err := ScanPendingAssets(db, newMemoryStorage(), stubScanner{}, time.Second, 10); !errors.Is(err, ErrJobRunning) {
+		t.Fatalf("error = %v, want ErrJobRunning", err)
+	}
+}
+
+func TestUploadPoliciesCappedToScanLimit(t *testing.T) {
+	cfg := config.Config{MediaMaxImageMB: 10, MediaMaxDocumentMB: 50, MediaMaxArchiveMB: 200, MediaMaxVideoMB: 500, MediaMaxAvatarMB: 2, ScanMaxMB: 25}
+	cfg.ScannerBackend = ScannerBackendNone
+	if got := ResourceUploadPolicy(cfg).Kinds[MimeMP4].MaxBytes; got != 500<<20 {
+		t.Errorf("video limit without a scanner = %d, want 500 MB", got)
+	}
+	cfg.ScannerBackend = ScannerBackendClamd
+	policy := ResourceUploadPolicy(cfg)
+	for contentType, want := range map[string]int64{MimeMP4: 25 << 20, MimeZIP: 25 << 20, MimePDF: 25 << 20, MimePNG: 10 << 20} {
+		if got := policy.Kinds[contentType].MaxBytes; got != want {
+			t.Errorf("%s limit with clamd = %d, want %d", contentType, got, want)
+		}
+	}
+}
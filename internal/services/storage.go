@@ -0,0 +1,243 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fizicamd-backend-go/internal/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	StorageBackendDisk = "disk"
+	StorageBackendS3   = "s3"
+)
+
+var (
+	ErrObjectNotFound      = errors.New("object not found")
+	ErrPresignUnsupported  = errors.New("presigned urls are not supported by this storage backend")
+	errInvalidStorageKey   = errors.New("invalid storage key")
+	errUnknownStorageDrive = errors.New("unknown storage backend")
+)
+
+type ObjectInfo struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// ObjectStorage stores media files addressed by a logical bucket (see
+// BucketUsers/BucketResources) and a storage key.
+type ObjectStorage interface {
+	Put(ctx context.Context, bucket, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, bucket, key string) (io.ReadSeekCloser, ObjectInfo, error)
+	Stat(ctx context.Context, bucket, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, bucket, key string) error
+	Presign(ctx context.Context, bucket, key string, ttl time.Duration) (string, error)
+}
+
+// OpenStorage builds the named storage backend ("disk" or "s3") from cfg.
+func OpenStorage(cfg config.Config, backend string) (ObjectStorage, error) {
+	switch strings.ToLower(backend) {
+	case "", StorageBackendDisk:
+		return NewDiskStorage(cfg.MediaStoragePath), nil
+	case StorageBackendS3:
+		return NewS3Storage(cfg)
+	}
+	return nil, fmt.Errorf("%w: %s", errUnknownStorageDrive, backend)
+}
+
+// DiskStorage keeps files under basePath/bucket/key.
+type DiskStorage struct {
+	basePath string
+}
+
+func NewDiskStorage(basePath string) *DiskStorage {
+	return &DiskStorage{basePath: basePath}
+}
+
+func (d *DiskStorage) path(bucket, key string) (string, error) {
+	if bucket == "" || key == "" || strings.Contains(bucket, "..") || strings.Contains(key, "..") {
+		return "", errInvalidStorageKey
+	}
+	return filepath.Join(d.basePath, bucket, filepath.FromSlash(key)), nil
+}
+
+func (d *DiskStorage) Put(ctx context.Context, bucket, key string, body io.Reader, size int64, contentType string) error {
+	target, err := d.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (d *DiskStorage) Get(ctx context.Context, bucket, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	target, err := d.path(bucket, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	file, err := os.Open(target)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ObjectInfo{}, ErrObjectNotFound
+		}
+		return nil, ObjectInfo{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, ObjectInfo{}, err
+	}
+	return file, ObjectInfo{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (d *DiskStorage) Stat(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	target, err := d.path(bucket, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	stat, err := os.Stat(target)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (d *DiskStorage) Delete(ctx context.Context, bucket, key string) error {
+	target, err := d.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *DiskStorage) Presign(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+// S3Storage keeps files in a single S3-compatible bucket using
+// "<bucket>/<key>" object names.
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3Storage(cfg config.Config) (*S3Storage, error) {
+	if cfg.S3Endpoint == "" || cfg.S3Bucket == "" {
+		return nil, errors.New("s3 storage requires S3_ENDPOINT and S3_BUCKET")
+	}
+	client, err := minio.New(cfg.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.S3AccessKey, cfg.S3SecretKey, ""),
+		Secure: cfg.S3UseSSL,
+		Region: cfg.S3Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3Storage{client: client, bucket: cfg.S3Bucket}, nil
+}
+
+func (s *S3Storage) objectName(bucket, key string) string {
+	return path.Join(bucket, key)
+}
+
+func (s *S3Storage) Put(ctx context.Context, bucket, key string, body io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.objectName(bucket, key), body, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, bucket, key string) (io.ReadSeekCloser, ObjectInfo, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, s.objectName(bucket, key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectInfo{}, s.mapError(err)
+	}
+	stat, err := object.Stat()
+	if err != nil {
+		_ = object.Close()
+		return nil, ObjectInfo{}, s.mapError(err)
+	}
+	return object, ObjectInfo{Size: stat.Size, ModTime: stat.LastModified, ContentType: stat.ContentType}, nil
+}
+
+func (s *S3Storage) Stat(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	stat, err := s.client.StatObject(ctx, s.bucket, s.objectName(bucket, key), minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, s.mapError(err)
+	}
+	return ObjectInfo{Size: stat.Size, ModTime: stat.LastModified, ContentType: stat.ContentType}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, bucket, key string) error {
+	return s.mapError(s.client.RemoveObject(ctx, s.bucket, s.objectName(bucket, key), minio.RemoveObjectOptions{}))
+}
+
+func (s *S3Storage) Presign(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	presigned, err := s.client.PresignedGetObject(ctx, s.bucket, s.objectName(bucket, key), ttl, url.Values{})
+	if err != nil {
+		return "", err
+	}
+	return presigned.String(), nil
+}
+
+func (s *S3Storage) mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrObjectNotFound
+	}
+	return err
+}
+
+// CopyObject copies one object between storage backends.
+func CopyObject(ctx context.Context, src, dst ObjectStorage, bucket, key, contentType string) (int64, error) {
+	reader, info, err := src.Get(ctx, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if err := dst.Put(ctx, bucket, key, reader, info.Size, contentType); err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// UploadChecksumAlgorithms are the tus checksum algorithms accepted in
+// Upload-Checksum headers.
+var UploadChecksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// ErrChecksumMismatch uses the status code defined by the tus checksum
+// extension.
+var ErrChecksumMismatch = ServiceError{Status: 460, Message: "Suma de control a fragmentului nu corespunde."}
+
+// Upload is an incomplete (or just finalized) resumable upload. The received
+// bytes live in a partial file named after the upload ID under
+// MEDIA_UPLOAD_PATH, which must be shared by every instance (or requests
+// pinned to one with sticky sessions) for uploads to resume elsewhere.
+type Upload struct {
+	ID          string     `db:"id"`
+	OwnerID     string     `db:"owner_user_id"`
+	Filename    string     `db:"filename"`
+	ContentType string     `db:"content_type"`
+	Length      int64      `db:"upload_length"`
+	Offset      int64      `db:"upload_offset"`
+	AssetID     *string    `db:"asset_id"`
+	ExpiresAt   time.Time  `db:"expires_at"`
+	CreatedAt   time.Time  `db:"created_at"`
+	UpdatedAt   *time.Time `db:"updated_at"`
+}
+
+const uploadColumns = `id, owner_user_id, filename, content_type, upload_length, upload_offset, asset_id, expires_at, created_at, updated_at`
+
+// ErrUploadBusy is returned while another request, possibly on another
+// instance, is writing to the same upload.
+var ErrUploadBusy = ServiceError{Status: 409, Message: "Încărcarea este folosită de altă cerere."}
+
+// A request writing to an upload holds a lease stored in its media_uploads
+// row rather than a lock tied to a database session, so no connection stays
+// checked out while a slow client streams its chunk. The lease is renewed
+// every uploadLeaseRenewal while the request runs and lapses uploadLeaseTTL
+// after an instance dies.
+const (
+	uploadLeaseTTL     = 2 * time.Minute
+	uploadLeaseRenewal = 30 * time.Second
+)
+
+type uploadLease struct {
+	db        *sqlx.DB
+	id        string
+	token     string
+	renewedAt atomic.Int64
+	lost      atomic.Bool
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// claimUpload takes the lease of an unexpired upload owned by ownerID in one
+// statement and starts renewing it. It returns ErrUploadBusy while another
+// request holds the lease. Call release when done.
+func claimUpload(db *sqlx.DB, id, ownerID string) (Upload, *uploadLease, error) {
+	var upload Upload
+	if !validUUID(id) {
+		return upload, nil, ErrNotFound("Încărcarea nu a fost găsită sau a expirat.")
+	}
+	token := uuid.NewString()
+	err := db.Get(&upload, `
+UPDATE media_uploads SET lease_token = $3, leased_until = now() + $4 * interval '1 second'
+WHERE id = $1::uuid AND owner_user_id = $2 AND (expires_at > now() OR asset_id IS NOT NULL)
+  AND (leased_until IS NULL OR leased_until < now())
+RETURNING `+uploadColumns, id, ownerID, token, int(uploadLeaseTTL/time.Second))
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := GetUpload(db, id, ownerID); err != nil {
+			return upload, nil, err
+		}
+		return upload, nil, ErrUploadBusy
+	}
+	if err != nil {
+		return upload, nil, err
+	}
+	lease := &uploadLease{db: db, id: upload.ID, token: token, stop: make(chan struct{}), done: make(chan struct{})}
+	lease.renewedAt.Store(time.Now().UnixNano())
+	go lease.keepAlive()
+	return upload, lease, nil
+}
+
+func (l *uploadLease) keepAlive() {
+	defer close(l.done)
+	ticker := time.NewTicker(uploadLeaseRenewal)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			result, err := l.db.Exec(`
+UPDATE media_uploads SET leased_until = now() + $3 * interval '1 second'
+WHERE id = $1 AND lease_token = $2`, l.id, l.token, int(uploadLeaseTTL/time.Second))
+			if err != nil {
+				// Retried on the next tick; held reports the lease lost once
+				// it may have lapsed.
+				continue
+			}
+			if affected, _ := result.RowsAffected(); affected == 0 {
+				l.lost.Store(true)
+				return
+			}
+			l.renewedAt.Store(time.Now().UnixNano())
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// held reports whether the lease is certainly still ours.
+func (l *uploadLease) held() bool {
+	renewed := time.Unix(0, l.renewedAt.Load())
+	return !l.lost.Load() && time.Since(renewed) < uploadLeaseTTL-uploadLeaseRenewal
+}
+
+// reader wraps r so that reading fails with ErrUploadBusy once the lease may
+// have passed to another request, before any more bytes are written.
+func (l *uploadLease) reader(r io.Reader) io.Reader {
+	return leasedReader{r: r, lease: l}
+}
+
+// release stops renewing the lease and frees the upload. A failed release
+// only delays the next request until the lease lapses.
+func (l *uploadLease) release() {
+	close(l.stop)
+	<-l.done
+	_, _ = l.db.Exec(`UPDATE media_uploads SET lease_token = NULL, leased_until = NULL WHERE id = $1 AND lease_token = $2`, l.id, l.token)
+}
+
+type leasedReader struct {
+	r     io.Reader
+	lease *uploadLease
+}
+
+func (r leasedReader) Read(p []byte) (int, error) {
+	if !r.lease.held() {
+		return 0, ErrUploadBusy
+	}
+	n, err := r.r.Read(p)
+	if !r.lease.held() {
+		return 0, ErrUploadBusy
+	}
+	return n, err
+}
+
+func uploadPath(dir, id string) string {
+	return filepath.Join(dir, id+".part")
+}
+
+// CreateUpload registers a new resumable upload of length bytes.
+func CreateUpload(db *sqlx.DB, dir string, policy UploadPolicy, ownerID, filename, contentType string, length int64, ttl time.Duration) (Upload, error) {
+	if length <= 0 {
+		return Upload{}, ErrBadRequest("Fișierul este gol.")
+	}
+	if max := policy.MaxBytes(); length > max {
+		return Upload{}, ErrTooLarge(fmt.Sprintf("Fișierul depășește limita de %d MB.", max>>20))
+	}
+	if policy.Quota != nil {
+		if err := policy.Quota.Check(length); err != nil {
+			return Upload{}, err
+		}
+	}
+	if declared := canonicalContentType(contentType); declared != "" && declared != "application/octet-stream" {
+		if _, ok := policy.Kinds[declared]; !ok {
+			return Upload{}, ErrUnsupportedMediaType(fmt.Sprintf("Tipul fișierului (%s) nu este acceptat.", declared))
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Upload{}, err
+	}
+	now := time.Now().UTC()
+	upload := Upload{
+		ID:          uuid.NewString(),
+		OwnerID:     ownerID,
+		Filename:    filename,
+		ContentType: contentType,
+		Length:      length,
+		ExpiresAt:   now.Add(ttl),
+		CreatedAt:   now,
+	}
+	file, err := os.OpenFile(uploadPath(dir, upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return Upload{}, err
+	}
+	_ = file.Close()
+	_, err = db.Exec(`
+INSERT INTO media_uploads (id, owner_user_id, filename, content_type, upload_length, upload_offset, expires_at, created_at, updated_at)
+VALUES ($1,$2,$3,$4,$5,0,$6,$7,$7)
+`, upload.ID, ownerID, filename, contentType, length, upload.ExpiresAt, now)
+	if err != nil {
+		_ = os.Remove(uploadPath(dir, upload.ID))
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// GetUpload returns an unexpired upload owned by ownerID.
+func GetUpload(db *sqlx.DB, id, ownerID string) (Upload, error) {
+	var upload Upload
+	if !validUUID(id) {
+		return Upload{}, ErrNotFound("Încărcarea nu a fost găsită sau a expirat.")
+	}
+	err := db.Get(&upload, `
+SELECT `+uploadColumns+`
+FROM media_uploads
+WHERE id = $1::uuid AND owner_user_id = $2 AND (expires_at > now() OR asset_id IS NOT NULL)
+`, id, ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Upload{}, ErrNotFound("Încărcarea nu a fost găsită sau a expirat.")
+	}
+	return upload, err
+}
+
+// ParseUploadChecksum splits an Upload-Checksum header ("sha1 <base64>").
+func ParseUploadChecksum(header string) (string, []byte, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return "", nil, ErrBadRequest("Antetul Upload-Checksum este invalid.")
+	}
+	algorithm = strings.ToLower(algorithm)
+	if _, ok := UploadChecksumAlgorithms[algorithm]; !ok {
+		return "", nil, ErrBadRequest("Algoritmul de control nu este suportat: " + algorithm)
+	}
+	digest, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", nil, ErrBadRequest("Antetul Upload-Checksum este invalid.")
+	}
+	return algorithm, digest, nil
+}
+
+// AppendUploadChunk writes body at offset. Without a checksum the bytes
+// received before an interrupted request are kept so the client can resume;
+// with one, the chunk is discarded unless it matches. Once the last byte
+// arrives the file is stored through SaveMediaAsset and the upload is linked
+// to the new asset. Concurrent requests for one upload get ErrUploadBusy.
+func AppendUploadChunk(db *sqlx.DB, store ObjectStorage, dir string, policy UploadPolicy, id, ownerID string, offset int64, body io.Reader, algorithm string, digest []byte) (Upload, error) {
+	upload, lease, err := claimUpload(db, id, ownerID)
+	if err != nil {
+		return upload, err
+	}
+	defer lease.release()
+	if upload.AssetID != nil || upload.Offset != offset {
+		return upload, ServiceError{Status: 409, Message: "Poziția fragmentului nu corespunde încărcării."}
+	}
+	if policy.Quota != nil {
+		// Other uploads may have used up the space since this one started.
+		if err := policy.Quota.Check(upload.Length); err != nil {
+			return upload, err
+		}
+	}
+	file, err := os.OpenFile(uploadPath(dir, upload.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		return upload, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return upload, err
+	}
+	var writer io.Writer = file
+	var hasher hash.Hash
+	if algorithm != "" {
+		hasher = UploadChecksumAlgorithms[algorithm]()
+		writer = io.MultiWriter(file, hasher)
+	}
+	written, copyErr := io.Copy(writer, io.LimitReader(lease.reader(body), upload.Length-offset))
+	if errors.Is(copyErr, ErrUploadBusy) {
+		// Another request may own the file now; leave it alone.
+		return upload, copyErr
+	}
+	if hasher != nil && (copyErr != nil || !bytes.Equal(hasher.Sum(nil), digest)) {
+		_ = file.Truncate(offset)
+		if copyErr != nil {
+			return upload, copyErr
+		}
+		return upload, ErrChecksumMismatch
+	}
+	upload.Offset = offset + written
+	if err := updateLeasedUpload(db, lease, `upload_offset = $3`, upload.Offset); err != nil {
+		return upload, err
+	}
+	if copyErr != nil {
+		return upload, copyErr
+	}
+	if upload.Offset < upload.Length {
+		return upload, nil
+	}
+	return finalizeUpload(db, store, dir, policy, upload, lease)
+}
+
+// updateLeasedUpload sets one column of an upload, provided the lease is
+// still held.
+func updateLeasedUpload(db *sqlx.DB, lease *uploadLease, set string, value interface{}) error {
+	result, err := db.Exec(`UPDATE media_uploads SET `+set+`, updated_at = $4 WHERE id = $1 AND lease_token = $2`,
+		lease.id, lease.token, value, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrUploadBusy
+	}
+	return nil
+}
+
+func finalizeUpload(db *sqlx.DB, store ObjectStorage, dir string, policy UploadPolicy, upload Upload, lease *uploadLease) (Upload, error) {
+	file, err := os.Open(uploadPath(dir, upload.ID))
+	if err != nil {
+		return upload, err
+	}
+	assetID, _, err := SaveMediaAsset(db, store, policy, upload.ContentType, upload.Filename, upload.OwnerID, lease.reader(file))
+	_ = file.Close()
+	if err != nil {
+		// A rejected file cannot become valid by resending it. Other
+		// failures (storage, database, a lost lease) keep the upload so the
+		// client can retry the last PATCH.
+		var rejected ServiceError
+		if errors.As(err, &rejected) && rejected != ErrUploadBusy {
+			_ = removeUpload(db, dir, upload.ID)
+		}
+		return upload, err
+	}
+	if err := updateLeasedUpload(db, lease, `asset_id = $3`, assetID); err != nil {
+		return upload, err
+	}
+	_ = os.Remove(uploadPath(dir, upload.ID))
+	upload.AssetID = &assetID
+	return upload, nil
+}
+
+// DeleteUpload abandons an upload owned by ownerID.
+func DeleteUpload(db *sqlx.DB, dir, id, ownerID string) error {
+	upload, lease, err := claimUpload(db, id, ownerID)
+	if err != nil {
+		return err
+	}
+	defer lease.release()
+	return removeUpload(db, dir, upload.ID)
+}
+
+func removeUpload(db *sqlx.DB, dir, id string) error {
+	if _, err := db.Exec(`DELETE FROM media_uploads WHERE id = $1`, id); err != nil {
+		return err
+	}
+	if err := os.Remove(uploadPath(dir, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ExpireUploads removes expired uploads and their partial files and returns
+// how many were removed. Finalized uploads are dropped once expired too; the
+// asset they produced is unaffected. Uploads a request still holds a lease on
+// are left for the next run.
+func ExpireUploads(db *sqlx.DB, dir string) (int, error) {
+	ids := []string{}
+	err := db.Select(&ids, `
+DELETE FROM media_uploads
+WHERE expires_at <= now() AND (leased_until IS NULL OR leased_until < now())
+RETURNING id`)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := os.Remove(uploadPath(dir, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return len(ids), err
+		}
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	uploadID    = "55555555-5555-5555-5555-555555555555"
+	uploadOwner = "66666666-6666-6666-6666-666666666666"
+)
+
+func TestParseUploadChecksum(t *testing.T) {
+	digest := sha256.Sum256([]byte("abc"))
+	encoded := base64.StdEncoding.EncodeToString(digest[:])
+	tests := []struct {
+		name       string
+		header     string
+		want       string
+		wantStatus int
+	}{
+		{"sha256", "sha256 " + encoded, "sha256", 0},
+		{"algorithm is case insensitive", " SHA256 " + encoded + " ", "sha256", 0},
+		{"missing digest", "sha256", "", http.StatusBadRequest},
+		{"unsupported algorithm", "crc32 " + encoded, "", http.StatusBadRequest},
+		{"invalid base64", "sha256 ???", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithm, got, err := ParseUploadChecksum(tt.header)
+			wantStatus(t, err, tt.wantStatus)
+			if tt.wantStatus == 0 && (algorithm != tt.want || string(got) != string(digest[:])) {
+				t.Errorf("ParseUploadChecksum() = %q, %x", algorithm, got)
+			}
+		})
+	}
+}
+
+func uploadRows(length, offset int64) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "owner_user_id", "filename", "content_type", "upload_length", "upload_offset", "asset_id", "expires_at", "created_at", "updated_at"}).
+		AddRow(uploadID, uploadOwner, "video.mp4", "video/mp4", length, offset, nil, now.Add(time.Hour), now, nil)
+}
+
+// expectClaim expects the lease to be taken and, at the end of the request,
+// released.
+func expectClaim(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`UPDATE media_uploads SET lease_token = \$3.*\(leased_until IS NULL OR leased_until < now\(\)\)`).
+		WithArgs(uploadID, uploadOwner, sqlmock.AnyArg(), int(uploadLeaseTTL/time.Second)).WillReturnRows(rows)
+}
+
+func expectRelease(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`UPDATE media_uploads SET lease_token = NULL`).WithArgs(uploadID, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// partialFile creates the upload's partial file holding content.
+func partialFile(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(uploadPath(dir, uploadID), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func readPartial(t *testing.T, dir string) string {
+	t.Helper()
+	content, err := os.ReadFile(uploadPath(dir, uploadID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestAppendUploadChunkWritesAtOffset(t *testing.T) {
+	db, mock := newMockDB(t)
+	dir := partialFile(t, "hello")
+	expectClaim(mock, uploadRows(20, 5))
+	mock.ExpectExec(`UPDATE media_uploads SET upload_offset = \$3, updated_at = \$4 WHERE id = \$1 AND lease_token = \$2`).
+		WithArgs(uploadID, sqlmock.AnyArg(), int64(11), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRelease(mock)
+
+	upload, err := AppendUploadChunk(db, newMemoryStorage(), dir, UploadPolicy{}, uploadID, uploadOwner, 5, strings.NewReader(" world"), "", nil)
+	if err != nil {
+		t.Fatalf("AppendUploadChunk() error = %v", err)
+	}
+	if upload.Offset != 11 || upload.AssetID != nil {
+		t.Errorf("upload = %+v, want offset 11 and no asset", upload)
+	}
+	if got := readPartial(t, dir); got != "hello world" {
+		t.Errorf("partial file = %q", got)
+	}
+}
+
+func TestAppendUploadChunkOffsetMismatch(t *testing.T) {
+	db, mock := newMockDB(t)
+	dir := partialFile(t, "hello")
+	expectClaim(mock, uploadRows(20, 5))
+	expectRelease(mock)
+
+	_, err := AppendUploadChunk(db, newMemoryStorage(), dir, UploadPolicy{}, uploadID, uploadOwner, 0, strings.NewReader("x"), "", nil)
+	wantStatus(t, err, http.StatusConflict)
+	if got := readPartial(t, dir); got != "hello" {
+		t.Errorf("partial file = %q, want it untouched", got)
+	}
+}
+
+func TestAppendUploadChunkChecksumMismatch(t *testing.T) {
+	db, mock := newMockDB(t)
+	dir := partialFile(t, "hello")
+	expectClaim(mock, uploadRows(20, 5))
+	expectRelease(mock)
+
+	digest := sha256.Sum256([]byte("something else"))
+	_, err := AppendUploadChunk(db, newMemoryStorage(), dir, UploadPolicy{}, uploadID, uploadOwner, 5, strings.NewReader(" world"), "sha256", digest[:])
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("error = %v, want ErrChecksumMismatch", err)
+	}
+	if got := readPartial(t, dir); got != "hello" {
+		t.Errorf("partial file = %q, want the chunk discarded", got)
+	}
+}
+
+func TestAppendUploadChunkLeaseLost(t *testing.T) {
+	db, mock := newMockDB(t)
+	dir := partialFile(t, "")
+	expectClaim(mock, uploadRows(20, 0))
+	// The lease lapsed and was taken over while the chunk streamed.
+	mock.ExpectExec(`UPDATE media_uploads SET upload_offset = \$3`).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectRelease(mock)
+
+	_, err := AppendUploadChunk(db, newMemoryStorage(), dir, UploadPolicy{}, uploadID, uploadOwner, 0, strings.NewReader("abc"), "", nil)
+	if !errors.Is(err, ErrUploadBusy) {
+		t.Fatalf("error = %v, want ErrUploadBusy", err)
+	}
+}
+
+func TestAppendUploadChunkNotClaimed(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows       *sqlmock.Rows
+		wantStatus int
+	}{
+		{"leased by another request", uploadRows(20, 0), http.StatusConflict},
+		{"missing or expired", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`UPDATE media_uploads SET lease_token`).WillReturnError(sql.ErrNoRows)
+			lookup := mock.ExpectQuery(`SELECT id, owner_user_id`).WithArgs(uploadID, uploadOwner)
+			if tt.rows != nil {
+				lookup.WillReturnRows(tt.rows)
+			} else {
+				lookup.WillReturnError(sql.ErrNoRows)
+			}
+			_, err := AppendUploadChunk(db, newMemoryStorage(), t.TempDir(), UploadPolicy{}, uploadID, uploadOwner, 0, strings.NewReader("x"), "", nil)
+			wantStatus(t, err, tt.wantStatus)
+		})
+	}
+}
+
+func TestLeasedReaderStopsWhenLeaseLost(t *testing.T) {
+	lease := &uploadLease{}
+	lease.renewedAt.Store(time.Now().UnixNano())
+	reader := lease.reader(strings.NewReader("abc"))
+	if n, err := reader.Read(make([]byte, 1)); n != 1 || err != nil {
+		t.Fatalf("Read() = %d, %v", n, err)
+	}
+	lease.lost.Store(true)
+	if _, err := reader.Read(make([]byte, 1)); !errors.Is(err, ErrUploadBusy) {
+		t.Fatalf("Read() error = %v, want ErrUploadBusy", err)
+	}
+	lease.lost.Store(false)
+	lease.renewedAt.Store(time.Now().Add(-uploadLeaseTTL).UnixNano())
+	if _, err := reader.Read(make([]byte, 1)); !errors.Is(err, ErrUploadBusy) {
+		t.Fatalf("Read() with a stale renewal error = %v, want ErrUploadBusy", err)
+	}
+}
+
+func TestExpireUploads(t *testing.T) {
+	db, mock := newMockDB(t)
+	dir := partialFile(t, "partial")
+	mock.ExpectQuery(`DELETE FROM media_uploads\s+WHERE expires_at <= now\(\) AND \(leased_until IS NULL OR leased_until < now\(\)\)\s+RETURNING id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uploadID).AddRow("77777777-7777-7777-7777-777777777777"))
+
+	removed, err := ExpireUploads(db, dir)
+	if err != nil || removed != 2 {
+		t.Fatalf("ExpireUploads() = %d, %v, want 2", removed, err)
+	}
+	if _, err := os.Stat(uploadPath(dir, uploadID)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial file still present: %v", err)
+	}
+}
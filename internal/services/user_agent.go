@@ -0,0 +1,90 @@
+package services
+
+import "strings"
+
+// UserAgentInfo is the coarse breakdown of a User-Agent header used by the
+// visit analytics.
+type UserAgentInfo struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+const unknownAgent = "Unknown"
+
+// ParseUserAgent recognises the common browsers, operating systems and
+// device classes. Order matters: most browsers also claim to be Safari or
+// Chrome, and iOS claims to be "like Mac OS X".
+func ParseUserAgent(ua string) UserAgentInfo {
+	if strings.TrimSpace(ua) == "" {
+		return UserAgentInfo{Browser: unknownAgent, OS: unknownAgent, Device: "unknown"}
+	}
+	lower := strings.ToLower(ua)
+	return UserAgentInfo{
+		Browser: parseBrowser(lower),
+		OS:      parseOS(lower),
+		Device:  parseDevice(lower),
+	}
+}
+
+func parseBrowser(ua string) string {
+	switch {
+	case isBot(ua):
+		return "Bot"
+	case strings.Contains(ua, "edg/"), strings.Contains(ua, "edga/"), strings.Contains(ua, "edgios/"):
+		return "Edge"
+	case strings.Contains(ua, "opr/"), strings.Contains(ua, "opera"):
+		return "Opera"
+	case strings.Contains(ua, "samsungbrowser/"):
+		return "Samsung Internet"
+	case strings.Contains(ua, "yabrowser/"):
+		return "Yandex"
+	case strings.Contains(ua, "firefox/"), strings.Contains(ua, "fxios/"):
+		return "Firefox"
+	case strings.Contains(ua, "crios/"), strings.Contains(ua, "chrome/"), strings.Contains(ua, "chromium/"):
+		return "Chrome"
+	case strings.Contains(ua, "safari/"):
+		return "Safari"
+	}
+	return "Other"
+}
+
+func parseOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "windows"):
+		return "Windows"
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return "iOS"
+	case strings.Contains(ua, "android"):
+		return "Android"
+	case strings.Contains(ua, "cros"):
+		return "ChromeOS"
+	case strings.Contains(ua, "mac os x"), strings.Contains(ua, "macintosh"):
+		return "macOS"
+	case strings.Contains(ua, "linux"):
+		return "Linux"
+	}
+	return "Other"
+}
+
+func parseDevice(ua string) string {
+	switch {
+	case isBot(ua):
+		return "bot"
+	case strings.Contains(ua, "ipad"), strings.Contains(ua, "tablet"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return "tablet"
+	case strings.Contains(ua, "mobile"), strings.Contains(ua, "iphone"), strings.Contains(ua, "ipod"):
+		return "mobile"
+	}
+	return "desktop"
+}
+
+func isBot(ua string) bool {
+	for _, marker := range []string{"bot", "crawler", "spider", "slurp", "headless", "curl/", "wget/", "python-requests"} {
+		if strings.Contains(ua, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package services
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		ua   string
+		want UserAgentInfo
+	}{
+		{"empty", "  ", UserAgentInfo{Browser: "Unknown", OS: "Unknown", Device: "unknown"}},
+		{
+			"chrome on windows",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			UserAgentInfo{Browser: "Chrome", OS: "Windows", Device: "desktop"},
+		},
+		{
+			"edge on windows",
+			"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+			UserAgentInfo{Browser: "Edge", OS: "Windows", Device: "desktop"},
+		},
+		{
+			"opera on linux",
+			"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 OPR/110.0.0.0",
+			UserAgentInfo{Browser: "Opera", OS: "Linux", Device: "desktop"},
+		},
+		{
+			"firefox on macos",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.4; rv:125.0) Gecko/20100101 Firefox/125.0",
+			UserAgentInfo{Browser: "Firefox", OS: "macOS", Device: "desktop"},
+		},
+		{
+			"safari on macos",
+			"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+			UserAgentInfo{Browser: "Safari", OS: "macOS", Device: "desktop"},
+		},
+		{
+			"safari on iphone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			UserAgentInfo{Browser: "Safari", OS: "iOS", Device: "mobile"},
+		},
+		{
+			"chrome on ipad",
+			"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+			UserAgentInfo{Browser: "Chrome", OS: "iOS", Device: "tablet"},
+		},
+		{
+			"firefox on iphone",
+			"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) FxiOS/125.0 Mobile/15E148 Safari/605.1.15",
+			UserAgentInfo{Browser: "Firefox", OS: "iOS", Device: "mobile"},
+		},
+		{
+			"chrome on android phone",
+			"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.82 Mobile Safari/537.36",
+			UserAgentInfo{Browser: "Chrome", OS: "Android", Device: "mobile"},
+		},
+		{
+			"android tablet",
+			"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			UserAgentInfo{Browser: "Chrome", OS: "Android", Device: "tablet"},
+		},
+		{
+			"samsung internet",
+			"Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+			UserAgentInfo{Browser: "Samsung Internet", OS: "Android", Device: "mobile"},
+		},
+		{
+			"chromebook",
+			"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			UserAgentInfo{Browser: "Chrome", OS: "ChromeOS", Device: "desktop"},
+		},
+		{
+			"googlebot",
+			"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			UserAgentInfo{Browser: "Bot", OS: "Other", Device: "bot"},
+		},
+		{
+			"headless chrome",
+			"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/124.0.0.0 Safari/537.36",
+			UserAgentInfo{Browser: "Bot", OS: "Linux", Device: "bot"},
+		},
+		{"curl", "curl/8.5.0", UserAgentInfo{Browser: "Bot", OS: "Other", Device: "bot"}},
+		{"unrecognised", "SomeClient/1.0", UserAgentInfo{Browser: "Other", OS: "Other", Device: "desktop"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUserAgent(tt.ua); got != tt.want {
+				t.Errorf("ParseUserAgent(%q) = %+v, want %+v", tt.ua, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,2 @@
+ALTER TABLE resource_entries
+  ADD COLUMN IF NOT EXISTS search_text TEXT NOT NULL DEFAULT '';
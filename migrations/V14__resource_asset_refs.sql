@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS resource_asset_refs (
+  resource_id UUID NOT NULL REFERENCES resource_entries(id) ON DELETE CASCADE,
+  asset_id UUID NOT NULL REFERENCES media_assets(id) ON DELETE CASCADE,
+  usage TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (resource_id, asset_id, usage)
+);
+
+CREATE INDEX IF NOT EXISTS idx_resource_asset_refs_asset ON resource_asset_refs(asset_id);
+
+INSERT INTO resource_asset_refs (resource_id, asset_id, usage)
+SELECT r.id, r.avatar_media_id, 'AVATAR'
+FROM resource_entries r
+JOIN media_assets m ON m.id = r.avatar_media_id
+ON CONFLICT DO NOTHING;
+
+INSERT INTO resource_asset_refs (resource_id, asset_id, usage)
+SELECT DISTINCT refs.resource_id, m.id, 'BLOCK'
+FROM (
+  SELECT r.id AS resource_id, block->>'assetId' AS asset_id
+  FROM resource_entries r, jsonb_array_elements(r.content) AS block
+  UNION ALL
+  SELECT r.id, item->>'assetId'
+  FROM resource_entries r, jsonb_array_elements(r.content) AS block, jsonb_array_elements(
+    CASE WHEN jsonb_typeof(block->'items') = 'array' THEN block->'items' ELSE '[]'::jsonb END
+  ) AS item
+) refs
+JOIN media_assets m ON m.id::text = refs.asset_id
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,12 @@
+ALTER TABLE media_assets ADD COLUMN IF NOT EXISTS group_id UUID NULL REFERENCES groups(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_media_assets_group ON media_assets(group_id);
+
+UPDATE media_assets m
+SET access_policy = 'PUBLIC'
+WHERE m.access_policy <> 'PUBLIC'
+  AND EXISTS (
+    SELECT 1 FROM resource_asset_refs r
+    JOIN resource_entries e ON e.id = r.resource_id
+    WHERE r.asset_id = m.id AND e.status = 'PUBLISHED'
+  );
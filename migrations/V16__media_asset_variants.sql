@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS media_asset_variants (
+  asset_id UUID NOT NULL REFERENCES media_assets(id) ON DELETE CASCADE,
+  width INT NOT NULL,
+  height INT NOT NULL,
+  content_type TEXT NOT NULL,
+  storage_key TEXT NOT NULL,
+  size_bytes BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (asset_id, width)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS media_uploads (
+  id UUID PRIMARY KEY,
+  owner_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  filename TEXT NOT NULL DEFAULT '',
+  content_type TEXT NOT NULL DEFAULT '',
+  upload_length BIGINT NOT NULL,
+  upload_offset BIGINT NOT NULL DEFAULT 0,
+  asset_id UUID NULL REFERENCES media_assets(id) ON DELETE SET NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_uploads_expires ON media_uploads(expires_at);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS media_blobs (
+  id UUID PRIMARY KEY,
+  bucket TEXT NOT NULL,
+  storage_key TEXT NOT NULL,
+  sha256 TEXT NULL,
+  content_type TEXT NOT NULL,
+  size_bytes BIGINT NOT NULL,
+  ref_count INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_blobs_sha256 ON media_blobs(sha256, bucket, size_bytes);
+
+ALTER TABLE media_assets ADD COLUMN IF NOT EXISTS blob_id UUID NULL REFERENCES media_blobs(id);
+
+CREATE INDEX IF NOT EXISTS idx_media_assets_blob ON media_assets(blob_id);
+
+-- Existing files become one blob each, reusing the asset ID.
+INSERT INTO media_blobs (id, bucket, storage_key, sha256, content_type, size_bytes, ref_count, created_at)
+SELECT id, bucket, storage_key, sha256, content_type, size_bytes, 1, created_at
+FROM media_assets
+WHERE blob_id IS NULL
+ON CONFLICT (id) DO NOTHING;
+
+UPDATE media_assets SET blob_id = id WHERE blob_id IS NULL;
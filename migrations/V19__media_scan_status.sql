@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_media_assets_pending_scan ON media_assets(created_at) WHERE status = 'PENDING_SCAN';
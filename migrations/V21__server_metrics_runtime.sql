@@ -0,0 +1,8 @@
+ALTER TABLE server_metric_samples
+  ADD COLUMN IF NOT EXISTS heap_alloc_bytes BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS heap_goal_bytes BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS gc_cycles BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS gc_pause_p99_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS goroutines INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS db_open_connections INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS db_in_use_connections INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS server_metric_rollups (
+  resolution TEXT NOT NULL,
+  bucket_start TIMESTAMPTZ NOT NULL,
+  metric TEXT NOT NULL,
+  min_value DOUBLE PRECISION NOT NULL,
+  avg_value DOUBLE PRECISION NOT NULL,
+  max_value DOUBLE PRECISION NOT NULL,
+  sample_count BIGINT NOT NULL,
+  PRIMARY KEY (resolution, bucket_start, metric)
+);
+
+CREATE INDEX IF NOT EXISTS idx_server_metric_samples_captured_at_asc ON server_metric_samples (captured_at);
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS alert_rules (
+  id UUID PRIMARY KEY,
+  name TEXT NOT NULL,
+  metric TEXT NOT NULL,
+  comparison TEXT NOT NULL,
+  threshold DOUBLE PRECISION NOT NULL,
+  clear_threshold DOUBLE PRECISION NOT NULL,
+  for_seconds INT NOT NULL DEFAULT 0,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+  id UUID PRIMARY KEY,
+  rule_id UUID REFERENCES alert_rules(id) ON DELETE SET NULL,
+  rule_name TEXT NOT NULL,
+  metric TEXT NOT NULL,
+  comparison TEXT NOT NULL,
+  threshold DOUBLE PRECISION NOT NULL,
+  status TEXT NOT NULL,
+  value DOUBLE PRECISION NOT NULL,
+  peak_value DOUBLE PRECISION NOT NULL,
+  fired_at TIMESTAMPTZ NOT NULL,
+  resolved_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_alerts_fired_at ON alerts (fired_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_firing_rule ON alerts (rule_id) WHERE status = 'FIRING';
+
+INSERT INTO alert_rules (id, name, metric, comparison, threshold, clear_threshold, for_seconds)
+SELECT uuid_generate_v4(), rule.name, rule.metric, 'above', rule.threshold, rule.clear_threshold, 300
+FROM (VALUES
+  ('Disc aproape plin', 'disk_used_ratio', 0.9, 0.85),
+  ('CPU sistem ridicat', 'system_cpu_load', 0.9, 0.8),
+  ('Memorie sistem ridicată', 'memory_used_ratio', 0.9, 0.85)
+) AS rule(name, metric, threshold, clear_threshold)
+WHERE NOT EXISTS (SELECT 1 FROM alert_rules);
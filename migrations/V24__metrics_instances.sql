@@ -0,0 +1,12 @@
+ALTER TABLE server_metric_samples ADD COLUMN IF NOT EXISTS instance_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE server_metric_samples ADD COLUMN IF NOT EXISTS hostname TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_server_metric_samples_instance ON server_metric_samples (instance_id, captured_at);
+
+ALTER TABLE server_metric_rollups ADD COLUMN IF NOT EXISTS instance_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE server_metric_rollups DROP CONSTRAINT IF EXISTS server_metric_rollups_pkey;
+ALTER TABLE server_metric_rollups ADD PRIMARY KEY (resolution, instance_id, bucket_start, metric);
+
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS instance_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS hostname TEXT NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS idx_alerts_firing_rule;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_firing_rule_instance ON alerts (rule_id, instance_id) WHERE status = 'FIRING';
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS metrics_tickets (
+  ticket_hash TEXT PRIMARY KEY,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_metrics_tickets_expires_at ON metrics_tickets (expires_at);
@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS analytics_daily (
+  day DATE PRIMARY KEY,
+  visits BIGINT NOT NULL,
+  visitors BIGINT NOT NULL,
+  new_visitors BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS analytics_daily_visitors (
+  day DATE NOT NULL,
+  visitor_hash TEXT NOT NULL,
+  visits BIGINT NOT NULL,
+  PRIMARY KEY (day, visitor_hash)
+);
+
+CREATE TABLE IF NOT EXISTS analytics_visitors (
+  visitor_hash TEXT PRIMARY KEY,
+  first_day DATE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS analytics_daily_paths (
+  day DATE NOT NULL,
+  path TEXT NOT NULL,
+  visits BIGINT NOT NULL,
+  PRIMARY KEY (day, path)
+);
+
+CREATE TABLE IF NOT EXISTS analytics_daily_referrers (
+  day DATE NOT NULL,
+  domain TEXT NOT NULL,
+  visits BIGINT NOT NULL,
+  PRIMARY KEY (day, domain)
+);
+
+CREATE TABLE IF NOT EXISTS analytics_daily_agents (
+  day DATE NOT NULL,
+  dimension TEXT NOT NULL,
+  value TEXT NOT NULL,
+  visits BIGINT NOT NULL,
+  PRIMARY KEY (day, dimension, value)
+);
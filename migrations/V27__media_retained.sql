@@ -0,0 +1,2 @@
+ALTER TABLE media_assets
+  ADD COLUMN IF NOT EXISTS retained BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,6 @@
+-- Policies chosen by an owner or admin are kept when resources are published
+-- or unpublished; only automatic ones follow resource usage.
+ALTER TABLE media_assets
+  ADD COLUMN IF NOT EXISTS access_policy_manual BOOLEAN NOT NULL DEFAULT false;
+
+UPDATE media_assets SET access_policy_manual = true WHERE access_policy = 'GROUP';
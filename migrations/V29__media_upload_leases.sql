@@ -0,0 +1,5 @@
+-- The request writing to an upload holds a lease on its row instead of a
+-- database session, so no connection is kept open while a client streams.
+ALTER TABLE media_uploads
+  ADD COLUMN IF NOT EXISTS lease_token UUID NULL,
+  ADD COLUMN IF NOT EXISTS leased_until TIMESTAMPTZ NULL;
@@ -0,0 +1,6 @@
+-- Failed scans are retried with a growing delay and given up on after a few
+-- attempts (status SCAN_FAILED) instead of being picked up on every pass.
+ALTER TABLE media_assets
+  ADD COLUMN IF NOT EXISTS scan_attempts INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS scan_error TEXT NULL,
+  ADD COLUMN IF NOT EXISTS scan_retry_at TIMESTAMPTZ NULL;